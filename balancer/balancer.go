@@ -0,0 +1,448 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+// Package balancer provides the client balancer of tnet, which picks one of the endpoints
+// of a replica set for each call, and ejects the unhealthy endpoints.
+package balancer
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"trpc.group/trpc-go/tnet"
+	"trpc.group/trpc-go/tnet/log"
+	"trpc.group/trpc-go/tnet/pool"
+)
+
+var (
+	// ErrNoEndpoint is returned when there is no endpoint to pick.
+	ErrNoEndpoint = errors.New("balancer: no endpoint")
+	// ErrBalancerClosed is returned when using a closed Balancer.
+	ErrBalancerClosed = errors.New("balancer: balancer is closed")
+)
+
+// replicas is the number of the virtual nodes of each endpoint on the hash ring.
+const replicas = 100
+
+// Balancer picks an endpoint for each call by the Strategy, and creates the connections by
+// the Dialer or takes them from the pool.
+//
+// An ejected endpoint is not picked until it is probed back, unless all the endpoints are
+// ejected, in which case all of them are picked as usual.
+type Balancer struct {
+	network string
+	opts    options
+	dialer  tnet.Dialer
+	pool    *pool.Pool
+	cancel  context.CancelFunc
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	byAddress map[string]*endpoint
+	ring      []ringNode
+	conns     map[tnet.Conn]*endpoint
+	next      uint64
+	closed    bool
+}
+
+type endpoint struct {
+	address     string
+	conns       map[tnet.Conn]struct{}
+	failures    int
+	lastFailure time.Time
+	ejected     bool
+	probeTimer  *time.Timer
+	removed     bool
+}
+
+type ringNode struct {
+	hash     uint64
+	endpoint *endpoint
+}
+
+// New creates a Balancer of the named network, the addresses are set by WithAddresses or
+// WithResolver.
+func New(network string, opt ...Option) (*Balancer, error) {
+	opts := options{}
+	opts.setDefault()
+	for _, o := range opt {
+		o(&opts)
+	}
+	b := &Balancer{
+		network:   network,
+		opts:      opts,
+		dialer:    opts.dialer,
+		byAddress: make(map[string]*endpoint),
+		conns:     make(map[tnet.Conn]*endpoint),
+	}
+	// The Options of the user's Dialer are not modified.
+	b.dialer.Options = append(append([]tnet.Option(nil), opts.dialer.Options...),
+		tnet.WithTCPInterceptors(b.intercept))
+
+	addresses := opts.addresses
+	if opts.resolver != nil {
+		var err error
+		if addresses, err = opts.resolver(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	b.update(addresses)
+	b.pool = pool.New(append(append([]pool.Option(nil), opts.poolOptions...), pool.WithDialer(b.dialer))...)
+	if opts.resolver != nil {
+		var ctx context.Context
+		ctx, b.cancel = context.WithCancel(context.Background())
+		go b.resolveLoop(ctx)
+	}
+	return b, nil
+}
+
+// Dial dials a new connection to the endpoint picked by key, the key is used by ConsistentHash only.
+func (b *Balancer) Dial(ctx context.Context, key string) (tnet.Conn, error) {
+	ep, err := b.pick(key)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := b.dialer.DialContext(ctx, b.network, ep.address)
+	if err != nil {
+		b.dialFailed(ctx, ep, err)
+		return nil, err
+	}
+	b.track(ep, conn)
+	return conn, nil
+}
+
+// Get gets a connection of the endpoint picked by key from the pool, the key is used by
+// ConsistentHash only. Close the returned connection to put it back to the pool.
+func (b *Balancer) Get(ctx context.Context, key string) (*pool.Conn, error) {
+	ep, err := b.pick(key)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := b.pool.Get(ctx, b.network, ep.address)
+	if err != nil {
+		b.dialFailed(ctx, ep, err)
+		return nil, err
+	}
+	b.track(ep, conn.Conn)
+	return conn, nil
+}
+
+// Pick returns the address of the endpoint picked by key, the key is used by ConsistentHash only.
+func (b *Balancer) Pick(key string) (string, error) {
+	ep, err := b.pick(key)
+	if err != nil {
+		return "", err
+	}
+	return ep.address, nil
+}
+
+// Refresh resolves the addresses by the resolver at once.
+func (b *Balancer) Refresh(ctx context.Context) error {
+	if b.opts.resolver == nil {
+		return nil
+	}
+	addresses, err := b.opts.resolver(ctx)
+	if err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		return ErrNoEndpoint
+	}
+	b.update(addresses)
+	return nil
+}
+
+// Close closes the Balancer and its pool, the connections created by Dial are not closed.
+func (b *Balancer) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	for _, ep := range b.endpoints {
+		ep.stopProbe()
+	}
+	b.mu.Unlock()
+	if b.cancel != nil {
+		b.cancel()
+	}
+	return b.pool.Close()
+}
+
+// EndpointStats is the statistics of an endpoint.
+type EndpointStats struct {
+	Address string
+	// Ejected is whether the endpoint is ejected.
+	Ejected bool
+	// Failures is the number of the recent failures.
+	Failures int
+	// Conns is the number of the active connections created by the Balancer.
+	Conns int
+	// Pending is the number of bytes in the outbound buffers of the connections.
+	Pending int
+}
+
+// Stats returns the statistics of the endpoints.
+func (b *Balancer) Stats() []EndpointStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := make([]EndpointStats, 0, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		stats = append(stats, EndpointStats{
+			Address:  ep.address,
+			Ejected:  ep.ejected,
+			Failures: ep.failures,
+			Conns:    len(ep.conns),
+			Pending:  ep.pending(),
+		})
+	}
+	return stats
+}
+
+func (b *Balancer) pick(key string) (*endpoint, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrBalancerClosed
+	}
+	candidates := make([]*endpoint, 0, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		if !ep.ejected {
+			candidates = append(candidates, ep)
+		}
+	}
+	allEjected := len(candidates) == 0
+	if allEjected {
+		candidates = b.endpoints
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	start := int(b.next % uint64(len(candidates)))
+	b.next++
+	switch b.opts.strategy {
+	case LeastPending:
+		picked, least := candidates[start], candidates[start].pending()
+		for i := 1; i < len(candidates) && least > 0; i++ {
+			ep := candidates[(start+i)%len(candidates)]
+			if pending := ep.pending(); pending < least {
+				picked, least = ep, pending
+			}
+		}
+		return picked, nil
+	case ConsistentHash:
+		h := hash(key)
+		i := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+		for n := 0; n < len(b.ring); n++ {
+			node := b.ring[(i+n)%len(b.ring)]
+			if allEjected || !node.endpoint.ejected {
+				return node.endpoint, nil
+			}
+		}
+		return nil, ErrNoEndpoint
+	default:
+		return candidates[start], nil
+	}
+}
+
+// update replaces the endpoints by addresses, the state of the existing endpoints is kept.
+func (b *Balancer) update(addresses []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	endpoints := make([]*endpoint, 0, len(addresses))
+	byAddress := make(map[string]*endpoint, len(addresses))
+	for _, address := range addresses {
+		if _, ok := byAddress[address]; ok {
+			continue
+		}
+		ep, ok := b.byAddress[address]
+		if !ok {
+			ep = &endpoint{address: address, conns: make(map[tnet.Conn]struct{})}
+		}
+		endpoints = append(endpoints, ep)
+		byAddress[address] = ep
+	}
+	for address, ep := range b.byAddress {
+		if _, ok := byAddress[address]; !ok {
+			ep.removed = true
+			ep.stopProbe()
+		}
+	}
+	b.endpoints, b.byAddress = endpoints, byAddress
+
+	b.ring = b.ring[:0]
+	for _, ep := range endpoints {
+		for i := 0; i < replicas; i++ {
+			b.ring = append(b.ring, ringNode{hash: hash(ep.address + "#" + strconv.Itoa(i)), endpoint: ep})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+}
+
+func (b *Balancer) resolveLoop(ctx context.Context) {
+	ticker := time.NewTicker(b.opts.resolveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := b.Refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Errorf("balancer resolve %s addresses error: %v", b.network, err)
+		}
+	}
+}
+
+// track records conn as a connection of ep, until it is closed.
+func (b *Balancer) track(ep *endpoint, conn tnet.Conn) {
+	b.mu.Lock()
+	b.conns[conn] = ep
+	ep.conns[conn] = struct{}{}
+	b.mu.Unlock()
+	if !conn.IsActive() {
+		// The conn is closed before it is tracked, so the close hook missed it.
+		b.untrack(conn)
+	}
+}
+
+func (b *Balancer) untrack(conn tnet.Conn) *endpoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ep, ok := b.conns[conn]
+	if !ok {
+		return nil
+	}
+	delete(b.conns, conn)
+	delete(ep.conns, conn)
+	return ep
+}
+
+// intercept untracks the connection when it is closed, and records the failure if it is
+// closed by error.
+func (b *Balancer) intercept(conn tnet.Conn, kind tnet.HookKind, next tnet.TCPHandler) error {
+	if kind != tnet.HookClosed {
+		return next(conn)
+	}
+	if ep := b.untrack(conn); ep != nil {
+		if cc, ok := conn.(tnet.ContextConn); ok {
+			switch cc.CloseReason() {
+			case tnet.CloseReasonPeerReset, tnet.CloseReasonIOError:
+				b.fail(ep)
+			}
+		}
+	}
+	return next(conn)
+}
+
+// dialFailed records the failure if err is caused by dialing. The failure caused by the
+// cancellation or deadline of the caller's ctx is not the endpoint's fault, so it is ignored.
+func (b *Balancer) dialFailed(ctx context.Context, ep *endpoint, err error) {
+	if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+		return
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		b.fail(ep)
+	}
+}
+
+func (b *Balancer) fail(ep *endpoint) {
+	if b.opts.maxFailures <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Sub(ep.lastFailure) > b.opts.ejectDuration {
+		ep.failures = 0
+	}
+	ep.failures++
+	ep.lastFailure = now
+	if ep.failures >= b.opts.maxFailures && !ep.ejected && !ep.removed && !b.closed {
+		ep.ejected = true
+		b.scheduleProbeLocked(ep)
+	}
+}
+
+func (b *Balancer) scheduleProbeLocked(ep *endpoint) {
+	ep.probeTimer = time.AfterFunc(b.opts.ejectDuration, func() { b.probe(ep) })
+}
+
+// probe dials the ejected endpoint, and takes it back if the dialing succeeds.
+func (b *Balancer) probe(ep *endpoint) {
+	timeout := b.opts.dialer.Timeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	// The probe connection is not tracked, so the user's Dialer is used.
+	d := b.opts.dialer
+	d.Timeout = timeout
+	conn, err := d.DialContext(context.Background(), b.network, ep.address)
+	if err == nil {
+		conn.Close()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || ep.removed {
+		return
+	}
+	if err != nil {
+		b.scheduleProbeLocked(ep)
+		return
+	}
+	ep.ejected = false
+	ep.failures = 0
+	ep.probeTimer = nil
+}
+
+func (ep *endpoint) pending() int {
+	var n int
+	for conn := range ep.conns {
+		n += tnet.OutboundBuffered(conn)
+	}
+	return n
+}
+
+func (ep *endpoint) stopProbe() {
+	if ep.probeTimer != nil {
+		ep.probeTimer.Stop()
+		ep.probeTimer = nil
+	}
+}
+
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// Mix the bits, since the fnv hashes of the similar strings are close to each other.
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,218 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package balancer_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet/balancer"
+	"trpc.group/trpc-go/tnet/pool"
+)
+
+// startServer starts a server which discards the data if discard is true, or never reads.
+func startServer(t *testing.T, discard bool) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			if discard {
+				go io.Copy(io.Discard, conn)
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// closedAddr returns an address which refuses the connections.
+func closedAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	require.Nil(t, ln.Close())
+	return ln.Addr().String()
+}
+
+func TestBalancer_RoundRobin(t *testing.T) {
+	addrs := []string{startServer(t, true), startServer(t, true), startServer(t, true)}
+	b, err := balancer.New("tcp", balancer.WithAddresses(addrs...))
+	require.Nil(t, err)
+	defer b.Close()
+
+	for i := 0; i < 6; i++ {
+		conn, err := b.Dial(context.Background(), "")
+		require.Nil(t, err)
+		assert.Equal(t, addrs[i%3], conn.RemoteAddr().String())
+		require.Nil(t, conn.Close())
+	}
+
+	// Get takes the connections from the pool.
+	conn, err := b.Get(context.Background(), "")
+	require.Nil(t, err)
+	local := conn.LocalAddr().String()
+	assert.Equal(t, addrs[0], conn.RemoteAddr().String())
+	assert.Equal(t, 1, b.Stats()[0].Conns)
+	require.Nil(t, conn.Close())
+	for i := 0; i < 2; i++ {
+		conn, err := b.Get(context.Background(), "")
+		require.Nil(t, err)
+		require.Nil(t, conn.Close())
+	}
+	conn, err = b.Get(context.Background(), "")
+	require.Nil(t, err)
+	assert.Equal(t, local, conn.LocalAddr().String())
+	require.Nil(t, conn.Close())
+
+	require.Nil(t, b.Close())
+	_, err = b.Dial(context.Background(), "")
+	assert.Equal(t, balancer.ErrBalancerClosed, err)
+}
+
+func TestBalancer_LeastPending(t *testing.T) {
+	stalled, normal := startServer(t, false), startServer(t, true)
+	b, err := balancer.New("tcp", balancer.WithAddresses(stalled, normal),
+		balancer.WithStrategy(balancer.LeastPending))
+	require.Nil(t, err)
+	defer b.Close()
+
+	conn, err := b.Dial(context.Background(), "")
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Equal(t, stalled, conn.RemoteAddr().String())
+	// The peer never reads, so the data is kept in the outbound buffer.
+	_, err = conn.Write(make([]byte, 32<<20))
+	require.Nil(t, err)
+	require.Eventually(t, func() bool { return b.Stats()[0].Pending > 0 }, time.Second, 10*time.Millisecond)
+	for i := 0; i < 4; i++ {
+		addr, err := b.Pick("")
+		require.Nil(t, err)
+		assert.Equal(t, normal, addr)
+	}
+}
+
+func TestBalancer_ConsistentHash(t *testing.T) {
+	addrs := []string{"127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3", "127.0.0.1:4"}
+	resolved := addrs
+	b, err := balancer.New("tcp", balancer.WithStrategy(balancer.ConsistentHash),
+		balancer.WithResolver(func(context.Context) ([]string, error) { return resolved, nil }, time.Hour))
+	require.Nil(t, err)
+	defer b.Close()
+
+	keys := make([]string, 100)
+	picked := make(map[string]string)
+	used := make(map[string]bool)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		addr, err := b.Pick(keys[i])
+		require.Nil(t, err)
+		picked[keys[i]] = addr
+		used[addr] = true
+		again, err := b.Pick(keys[i])
+		require.Nil(t, err)
+		assert.Equal(t, addr, again)
+	}
+	assert.Len(t, used, len(addrs))
+
+	// Only the keys of the removed endpoint are remapped.
+	resolved = addrs[1:]
+	require.Nil(t, b.Refresh(context.Background()))
+	assert.Len(t, b.Stats(), 3)
+	for _, key := range keys {
+		addr, err := b.Pick(key)
+		require.Nil(t, err)
+		if picked[key] != addrs[0] {
+			assert.Equal(t, picked[key], addr)
+		} else {
+			assert.NotEqual(t, addrs[0], addr)
+		}
+	}
+
+	resolved = nil
+	assert.Equal(t, balancer.ErrNoEndpoint, b.Refresh(context.Background()))
+	_, err = balancer.New("tcp")
+	assert.Equal(t, balancer.ErrNoEndpoint, err)
+	resolveErr := errors.New("resolve error")
+	_, err = balancer.New("tcp", balancer.WithResolver(func(context.Context) ([]string, error) {
+		return nil, resolveErr
+	}, 0))
+	assert.Equal(t, resolveErr, err)
+}
+
+func TestBalancer_Ejection(t *testing.T) {
+	bad, good := closedAddr(t), startServer(t, true)
+	b, err := balancer.New("tcp", balancer.WithAddresses(bad, good),
+		balancer.WithEjection(2, 100*time.Millisecond),
+		balancer.WithPoolOptions(pool.WithMaxIdle(0)))
+	require.Nil(t, err)
+	defer b.Close()
+
+	var failures int
+	for i := 0; i < 4; i++ {
+		conn, err := b.Get(context.Background(), "")
+		if err != nil {
+			failures++
+			continue
+		}
+		assert.Equal(t, good, conn.RemoteAddr().String())
+		require.Nil(t, conn.Close())
+	}
+	assert.Equal(t, 2, failures)
+	stats := b.Stats()
+	assert.True(t, stats[0].Ejected)
+	assert.Equal(t, 2, stats[0].Failures)
+
+	// The ejected endpoint is not picked.
+	for i := 0; i < 4; i++ {
+		addr, err := b.Pick("")
+		require.Nil(t, err)
+		assert.Equal(t, good, addr)
+	}
+
+	// The endpoint is taken back once the probe succeeds.
+	ln, err := net.Listen("tcp", bad)
+	require.Nil(t, err)
+	defer ln.Close()
+	require.Eventually(t, func() bool { return !b.Stats()[0].Ejected }, 2*time.Second, 20*time.Millisecond)
+	assert.Zero(t, b.Stats()[0].Failures)
+}
+
+func TestBalancer_EjectionIgnoresCallerContext(t *testing.T) {
+	b, err := balancer.New("tcp", balancer.WithAddresses(startServer(t, true)),
+		balancer.WithEjection(1, time.Minute),
+		balancer.WithPoolOptions(pool.WithMaxIdle(0)))
+	require.Nil(t, err)
+	defer b.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = b.Get(ctx, "")
+	assert.True(t, errors.Is(err, context.Canceled))
+	_, err = b.Dial(ctx, "")
+	assert.True(t, errors.Is(err, context.Canceled))
+	stats := b.Stats()
+	assert.False(t, stats[0].Ejected)
+	assert.Zero(t, stats[0].Failures)
+}
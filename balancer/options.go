@@ -0,0 +1,123 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package balancer
+
+import (
+	"context"
+	"time"
+
+	"trpc.group/trpc-go/tnet"
+	"trpc.group/trpc-go/tnet/pool"
+)
+
+const (
+	defaultResolveInterval = 30 * time.Second
+	defaultMaxFailures     = 3
+	defaultEjectDuration   = 10 * time.Second
+	defaultProbeTimeout    = time.Second
+)
+
+// Resolver returns the current addresses of the endpoints.
+type Resolver func(ctx context.Context) ([]string, error)
+
+// Strategy is how the Balancer picks an endpoint.
+type Strategy int
+
+const (
+	// RoundRobin picks the endpoints in turn.
+	RoundRobin Strategy = iota
+	// LeastPending picks the endpoint of which the connections have the least bytes
+	// in the outbound buffers, see tnet.OutboundBuffered.
+	LeastPending
+	// ConsistentHash picks the endpoint by the key, the same key is mapped to the same
+	// endpoint, and only the keys of an endpoint are remapped when it is removed or ejected.
+	ConsistentHash
+)
+
+type options struct {
+	addresses       []string
+	resolver        Resolver
+	resolveInterval time.Duration
+	strategy        Strategy
+	dialer          tnet.Dialer
+	poolOptions     []pool.Option
+	maxFailures     int
+	ejectDuration   time.Duration
+}
+
+func (o *options) setDefault() {
+	o.resolveInterval = defaultResolveInterval
+	o.maxFailures = defaultMaxFailures
+	o.ejectDuration = defaultEjectDuration
+}
+
+// Option is the option of Balancer.
+type Option func(*options)
+
+// WithAddresses sets the static addresses of the endpoints.
+func WithAddresses(addresses ...string) Option {
+	return func(o *options) {
+		o.addresses = addresses
+	}
+}
+
+// WithResolver sets the resolver of the addresses, which is called when the Balancer is
+// created and every interval after that, default interval is 30s. The state of the
+// endpoints still resolved is kept. It takes precedence over WithAddresses.
+func WithResolver(r Resolver, interval time.Duration) Option {
+	return func(o *options) {
+		o.resolver = r
+		if interval > 0 {
+			o.resolveInterval = interval
+		}
+	}
+}
+
+// WithStrategy sets how the Balancer picks an endpoint, default is RoundRobin.
+func WithStrategy(s Strategy) Option {
+	return func(o *options) {
+		o.strategy = s
+	}
+}
+
+// WithDialer sets the Dialer used to create the connections. The Options of the Dialer are
+// applied to every connection, and the Balancer appends its own interceptor to them to track
+// the connections.
+func WithDialer(d tnet.Dialer) Option {
+	return func(o *options) {
+		o.dialer = d
+	}
+}
+
+// WithPoolOptions sets the options of the pool used by Balancer.Get.
+// pool.WithDialer is overridden by the dialer of the Balancer.
+func WithPoolOptions(opt ...pool.Option) Option {
+	return func(o *options) {
+		o.poolOptions = opt
+	}
+}
+
+// WithEjection sets that an endpoint is ejected after maxFailures failures, where failures
+// are dial errors and connections closed by io errors or peer resets, and the failures
+// older than ejectDuration are forgotten. The ejected endpoint is probed by dialing after
+// ejectDuration, and picked again once a probe succeeds. Default is 3 failures and 10s.
+// If maxFailures <= 0, no endpoint is ejected.
+func WithEjection(maxFailures int, ejectDuration time.Duration) Option {
+	return func(o *options) {
+		o.maxFailures = maxFailures
+		if ejectDuration > 0 {
+			o.ejectDuration = ejectDuration
+		}
+	}
+}
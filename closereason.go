@@ -0,0 +1,140 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/atomic"
+	"golang.org/x/sys/unix"
+)
+
+// CloseReason denotes why a connection is closed. It implements error, and it is
+// the cause of the connection context, see ContextConn.Context.
+type CloseReason int32
+
+const (
+	// CloseReasonNone means that the connection is not closed.
+	CloseReasonNone CloseReason = iota
+	// CloseReasonUser means that the connection is closed by user calling Close.
+	CloseReasonUser
+	// CloseReasonEOF means that the connection is closed by the peer.
+	CloseReasonEOF
+	// CloseReasonPeerReset means that the connection is reset by the peer.
+	CloseReasonPeerReset
+	// CloseReasonIdleTimeout means that the connection is idle for longer than the idle timeout.
+	CloseReasonIdleTimeout
+	// CloseReasonReadIdleTimeout means that nothing is read for longer than the read idle timeout.
+	CloseReasonReadIdleTimeout
+	// CloseReasonWriteIdleTimeout means that nothing is written for longer than the write idle timeout.
+	CloseReasonWriteIdleTimeout
+	// CloseReasonLimitExceeded means that a limit such as the outbound buffer limit is exceeded.
+	CloseReasonLimitExceeded
+	// CloseReasonServiceShutdown means that the service of the connection is shut down.
+	CloseReasonServiceShutdown
+	// CloseReasonHandlerError means that the handler or hook returns error or panics.
+	CloseReasonHandlerError
+	// CloseReasonIOError means that reading or writing the socket fails.
+	CloseReasonIOError
+	// CloseReasonProxyHeader means that the PROXY protocol header is invalid, or it is not
+	// received within the header timeout while it is required.
+	CloseReasonProxyHeader
+	// CloseReasonDetached means that the socket is detached from tnet by Detach, which is
+	// still open outside of tnet.
+	CloseReasonDetached
+)
+
+var closeReasonNames = [...]string{
+	CloseReasonNone:             "none",
+	CloseReasonUser:             "user close",
+	CloseReasonEOF:              "EOF",
+	CloseReasonPeerReset:        "peer reset",
+	CloseReasonIdleTimeout:      "idle timeout",
+	CloseReasonReadIdleTimeout:  "read idle timeout",
+	CloseReasonWriteIdleTimeout: "write idle timeout",
+	CloseReasonLimitExceeded:    "limit exceeded",
+	CloseReasonServiceShutdown:  "service shutdown",
+	CloseReasonHandlerError:     "handler error",
+	CloseReasonIOError:          "io error",
+	CloseReasonProxyHeader:      "proxy header error",
+	CloseReasonDetached:         "detached",
+}
+
+// String implements fmt.Stringer.
+func (r CloseReason) String() string {
+	if r < 0 || int(r) >= len(closeReasonNames) {
+		return fmt.Sprintf("CloseReason(%d)", r)
+	}
+	return closeReasonNames[r]
+}
+
+// Error implements error.
+func (r CloseReason) Error() string {
+	return "conn closed: " + r.String()
+}
+
+// closeReasonOf returns the close reason of the socket error.
+func closeReasonOf(err error) CloseReason {
+	if errors.Is(err, unix.ECONNRESET) || errors.Is(err, unix.EPIPE) {
+		return CloseReasonPeerReset
+	}
+	return CloseReasonIOError
+}
+
+// connContext is the context of a connection, which is canceled with the close reason
+// when the connection is closed. The context is created lazily.
+type connContext struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel func(error)
+	done   bool
+	reason atomic.Int32
+}
+
+// Context returns the context of the connection, it is canceled when the connection is closed,
+// and its cause is the CloseReason.
+func (c *connContext) Context() context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx == nil {
+		c.ctx, c.cancel = newCauseContext()
+		if c.done {
+			c.cancel(c.CloseReason())
+		}
+	}
+	return c.ctx
+}
+
+// CloseReason returns why the connection is closed, or CloseReasonNone if it is active.
+func (c *connContext) CloseReason() CloseReason {
+	return CloseReason(c.reason.Load())
+}
+
+// setCloseReason records the close reason, only the first one takes effect.
+func (c *connContext) setCloseReason(r CloseReason) {
+	c.reason.CompareAndSwap(int32(CloseReasonNone), int32(r))
+}
+
+// cancelContext cancels the context with the close reason.
+func (c *connContext) cancelContext() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done = true
+	if c.cancel != nil {
+		c.cancel(c.CloseReason())
+	}
+}
@@ -0,0 +1,40 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+//go:build go1.20
+// +build go1.20
+
+package tnet_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+)
+
+func TestTCPContextCause(t *testing.T) {
+	ln, err := net.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	defer ln.Close()
+	conn, err := tnet.DialTCP("tcp", ln.Addr().String(), time.Second)
+	require.Nil(t, err)
+	ctx := conn.(tnet.ContextConn).Context()
+	require.Nil(t, conn.Close())
+	<-ctx.Done()
+	assert.Equal(t, tnet.CloseReasonUser, context.Cause(ctx))
+}
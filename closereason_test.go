@@ -0,0 +1,158 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+)
+
+func TestTCPCloseReason(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		opts    []tnet.Option
+		handler tnet.TCPHandler
+		client  func(c *net.TCPConn, cancel context.CancelFunc)
+		want    tnet.CloseReason
+	}{
+		{
+			name:   "eof",
+			client: func(c *net.TCPConn, _ context.CancelFunc) { c.Close() },
+			want:   tnet.CloseReasonEOF,
+		},
+		{
+			name: "peer reset",
+			client: func(c *net.TCPConn, _ context.CancelFunc) {
+				c.SetLinger(0)
+				c.Close()
+			},
+			want: tnet.CloseReasonPeerReset,
+		},
+		{
+			name: "idle timeout",
+			opts: []tnet.Option{tnet.WithTCPIdleTimeout(time.Second)},
+			want: tnet.CloseReasonIdleTimeout,
+		},
+		{
+			name: "read idle timeout",
+			opts: []tnet.Option{tnet.WithTCPReadIdleTimeout(time.Second)},
+			want: tnet.CloseReasonReadIdleTimeout,
+		},
+		{
+			name: "write idle timeout",
+			opts: []tnet.Option{tnet.WithTCPWriteIdleTimeout(time.Second)},
+			want: tnet.CloseReasonWriteIdleTimeout,
+		},
+		{
+			name:    "handler error",
+			handler: func(tnet.Conn) error { return errors.New("handler error") },
+			client: func(c *net.TCPConn, _ context.CancelFunc) {
+				c.Write(helloWorld)
+			},
+			want: tnet.CloseReasonHandlerError,
+		},
+		{
+			name:   "service shutdown",
+			client: func(_ *net.TCPConn, cancel context.CancelFunc) { cancel() },
+			want:   tnet.CloseReasonServiceShutdown,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			type closed struct {
+				reason tnet.CloseReason
+				ctxErr error
+			}
+			ch := make(chan closed, 1)
+			handler := tt.handler
+			if handler == nil {
+				handler = func(tnet.Conn) error { return nil }
+			}
+			ln, err := tnet.Listen("tcp", getTestAddr())
+			require.Nil(t, err)
+			opts := append([]tnet.Option{
+				tnet.WithOnTCPOpened(func(conn tnet.Conn) error {
+					assert.Equal(t, tnet.CloseReasonNone, conn.(tnet.ContextConn).CloseReason())
+					assert.Nil(t, conn.(tnet.ContextConn).Context().Err())
+					return nil
+				}),
+				tnet.WithOnTCPClosed(func(conn tnet.Conn) error {
+					ch <- closed{reason: conn.(tnet.ContextConn).CloseReason(), ctxErr: conn.(tnet.ContextConn).Context().Err()}
+					return nil
+				}),
+			}, tt.opts...)
+			s, err := tnet.NewTCPService(ln, handler, opts...)
+			require.Nil(t, err)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go s.Serve(ctx)
+
+			c, err := net.Dial("tcp", ln.Addr().String())
+			require.Nil(t, err)
+			defer c.Close()
+			// Make sure the conn is opened before the client action.
+			time.Sleep(10 * time.Millisecond)
+			if tt.client != nil {
+				tt.client(c.(*net.TCPConn), cancel)
+			}
+			select {
+			case got := <-ch:
+				assert.Equal(t, tt.want, got.reason)
+				assert.Equal(t, context.Canceled, got.ctxErr)
+			// The idle timers are checked every second.
+			case <-time.After(4 * time.Second):
+				t.Fatal("wait conn closed timeout")
+			}
+		})
+	}
+}
+
+func TestTCPCloseReasonUser(t *testing.T) {
+	ln, err := net.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	defer ln.Close()
+	conn, err := tnet.DialTCP("tcp", ln.Addr().String(), time.Second)
+	require.Nil(t, err)
+	ctx := conn.(tnet.ContextConn).Context()
+	assert.Same(t, ctx, conn.(tnet.ContextConn).Context())
+	require.Nil(t, conn.Close())
+	<-ctx.Done()
+	assert.Equal(t, tnet.CloseReasonUser, conn.(tnet.ContextConn).CloseReason())
+	// The reason is not overwritten by the later close.
+	require.Nil(t, conn.Close())
+	assert.Equal(t, tnet.CloseReasonUser, conn.(tnet.ContextConn).CloseReason())
+}
+
+func TestUDPCloseReason(t *testing.T) {
+	conn, err := tnet.DialUDP("udp", getTestAddr(), time.Second)
+	require.Nil(t, err)
+	require.Nil(t, conn.Close())
+	// The context created after close is canceled too.
+	<-conn.(tnet.ContextConn).Context().Done()
+	assert.Equal(t, tnet.CloseReasonUser, conn.(tnet.ContextConn).CloseReason())
+}
+
+func TestCloseReasonString(t *testing.T) {
+	assert.Equal(t, "peer reset", tnet.CloseReasonPeerReset.String())
+	assert.Equal(t, "conn closed: EOF", tnet.CloseReasonEOF.Error())
+	assert.Equal(t, "CloseReason(100)", tnet.CloseReason(100).String())
+}
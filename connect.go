@@ -0,0 +1,238 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	"go.uber.org/atomic"
+	"golang.org/x/sys/unix"
+	"trpc.group/trpc-go/tnet/internal/netutil"
+	"trpc.group/trpc-go/tnet/internal/poller"
+)
+
+// resolveTCPAddrs resolves address to the tcp addrs of network, the result is in the order of lookup.
+func resolveTCPAddrs(ctx context.Context, network, address string) ([]*net.TCPAddr, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	portnum, err := net.DefaultResolver.LookupPort(ctx, network, port)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IPAddr
+	if host == "" {
+		// Same as net.Dial, empty host means the local system.
+		ips = []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}, {IP: net.IPv6loopback}}
+	} else if ip, zone := splitHostZone(host); net.ParseIP(ip) != nil {
+		ips = []net.IPAddr{{IP: net.ParseIP(ip), Zone: zone}}
+	} else if ips, err = net.DefaultResolver.LookupIPAddr(ctx, host); err != nil {
+		return nil, err
+	}
+	addrs := make([]*net.TCPAddr, 0, len(ips))
+	for _, ip := range ips {
+		isIPv4 := ip.IP.To4() != nil
+		if (network == "tcp4" && !isIPv4) || (network == "tcp6" && isIPv4) {
+			continue
+		}
+		addrs = append(addrs, &net.TCPAddr{IP: ip.IP, Port: portnum, Zone: ip.Zone})
+	}
+	if len(addrs) == 0 {
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+	}
+	return addrs, nil
+}
+
+func splitHostZone(host string) (string, string) {
+	for i := len(host) - 1; i >= 0; i-- {
+		if host[i] == '%' {
+			return host[:i], host[i+1:]
+		}
+	}
+	return host, ""
+}
+
+// connectTCP connects to raddr by a nonblocking socket, and waits for the completion of connecting
+// by poller. The socket is bound to laddr if it is not nil, and control is called before connecting
+// if it is not nil. It returns the connected fd and the local address.
+func connectTCP(
+	ctx context.Context,
+	network string,
+	laddr, raddr *net.TCPAddr,
+	control func(network, address string, c syscall.RawConn) error,
+) (int, net.Addr, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, nil, err
+	}
+	family, sa, err := netutil.TCPAddrToSockaddr(raddr)
+	if err != nil {
+		return -1, nil, err
+	}
+	fd, err := netutil.Socket(family, unix.SOCK_STREAM, unix.IPPROTO_TCP)
+	if err != nil {
+		return -1, nil, err
+	}
+	if err := setupSocket(fd, family, network, laddr, raddr, control); err != nil {
+		unix.Close(fd)
+		return -1, nil, err
+	}
+	if err := connect(ctx, fd, sa); err != nil {
+		unix.Close(fd)
+		return -1, nil, err
+	}
+	// Same as the default of net.Dialer.
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY, 1); err != nil {
+		unix.Close(fd)
+		return -1, nil, os.NewSyscallError("setsockopt", err)
+	}
+	lsa, err := unix.Getsockname(fd)
+	if err != nil {
+		unix.Close(fd)
+		return -1, nil, os.NewSyscallError("getsockname", err)
+	}
+	return fd, netutil.SockaddrToTCPOrUnixAddr(lsa), nil
+}
+
+func setupSocket(
+	fd, family int,
+	network string,
+	laddr, raddr *net.TCPAddr,
+	control func(network, address string, c syscall.RawConn) error,
+) error {
+	if control != nil {
+		// Same as net.Dialer, the network passed to control is always suffixed with the ip version.
+		if family == unix.AF_INET {
+			network = "tcp4"
+		} else {
+			network = "tcp6"
+		}
+		if err := control(network, raddr.String(), rawConn(fd)); err != nil {
+			return err
+		}
+	}
+	if laddr == nil {
+		return nil
+	}
+	lsa, err := netutil.TCPAddrToSockaddrOfFamily(family, laddr)
+	if err != nil {
+		return err
+	}
+	return os.NewSyscallError("bind", unix.Bind(fd, lsa))
+}
+
+// rawConn implements syscall.RawConn for the Control of Dialer, only Control is supported.
+type rawConn int
+
+// Control calls f with the fd.
+func (c rawConn) Control(f func(fd uintptr)) error {
+	f(uintptr(c))
+	return nil
+}
+
+// Read is not supported.
+func (c rawConn) Read(func(fd uintptr) bool) error {
+	return errors.New("tnet: Read of RawConn is not supported")
+}
+
+// Write is not supported.
+func (c rawConn) Write(func(fd uintptr) bool) error {
+	return errors.New("tnet: Write of RawConn is not supported")
+}
+
+func connect(ctx context.Context, fd int, sa unix.Sockaddr) error {
+	switch err := unix.Connect(fd, sa); err {
+	case unix.EINPROGRESS, unix.EALREADY, unix.EINTR:
+	case nil, unix.EISCONN:
+		return nil
+	default:
+		return os.NewSyscallError("connect", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Wait for the fd to be writable, which means that connecting is finished.
+	w := &connectWaiter{writable: make(chan struct{}, 1)}
+	desc := poller.NewDesc()
+	desc.Lock()
+	desc.FD = fd
+	desc.Data = w
+	desc.OnWrite = onConnectWritable
+	desc.OnHup = func(data interface{}) { onConnectWritable(data) }
+	desc.Unlock()
+	if err := desc.PickPoller(); err != nil {
+		poller.FreeDesc(desc)
+		return err
+	}
+	defer func() {
+		// The callbacks may still be running, mark the waiter done before the desc is freed
+		// and reused. The desc may have been detached already, so the error is ignored.
+		w.done.Store(true)
+		desc.Close()
+		poller.FreeDesc(desc)
+	}()
+	for {
+		// The event is reported once, so that the callback needn't detach the desc.
+		if err := desc.Control(poller.WritableOnce); err != nil {
+			return err
+		}
+		select {
+		case <-w.writable:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		// It fails if the poller has detached the desc on hang up, so the error is ignored.
+		desc.Control(poller.Detach)
+		errno, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+		if err != nil {
+			return os.NewSyscallError("getsockopt", err)
+		}
+		switch err := unix.Errno(errno); err {
+		case unix.EINPROGRESS, unix.EALREADY, unix.EINTR:
+			continue
+		case unix.Errno(0), unix.EISCONN:
+			return nil
+		default:
+			return os.NewSyscallError("connect", err)
+		}
+	}
+}
+
+// connectWaiter waits for the writable event of a connecting fd. done is set once the
+// connecting goroutine stops waiting, after which the desc may be reused by other fds.
+type connectWaiter struct {
+	writable chan struct{}
+	done     atomic.Bool
+}
+
+// onConnectWritable only wakes up the connecting goroutine, which owns the desc, as the
+// callback may run after the desc is freed, such as the OnHup run in a new goroutine.
+func onConnectWritable(data interface{}) error {
+	w, ok := data.(*connectWaiter)
+	if !ok || w == nil {
+		return errors.New("onConnectWritable: invalid data")
+	}
+	if w.done.Load() {
+		return nil
+	}
+	select {
+	case w.writable <- struct{}{}:
+	default:
+	}
+	return nil
+}
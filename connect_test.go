@@ -0,0 +1,58 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_partialDeadline(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		deadline  time.Time
+		remaining int
+		want      time.Time
+		wantErr   error
+	}{
+		{"split equally", now.Add(10 * time.Second), 2, now.Add(5 * time.Second), nil},
+		{"sane minimum", now.Add(3 * time.Second), 3, now.Add(2 * time.Second), nil},
+		{"less than minimum", now.Add(time.Second), 3, now.Add(time.Second), nil},
+		{"last address", now.Add(10 * time.Second), 1, now.Add(10 * time.Second), nil},
+		{"expired", now.Add(-time.Second), 1, time.Time{}, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		got, err := partialDeadline(now, tt.deadline, tt.remaining)
+		assert.Equal(t, tt.wantErr, err, tt.name)
+		assert.True(t, tt.want.Equal(got), tt.name)
+	}
+}
+
+func Test_onConnectWritable(t *testing.T) {
+	w := &connectWaiter{writable: make(chan struct{}, 1)}
+	assert.Nil(t, onConnectWritable(w))
+	assert.Nil(t, onConnectWritable(w))
+	assert.Len(t, w.writable, 1)
+	<-w.writable
+
+	// The late callbacks are ignored once the connecting goroutine stops waiting.
+	w.done.Store(true)
+	assert.Nil(t, onConnectWritable(w))
+	assert.Len(t, w.writable, 0)
+	assert.NotNil(t, onConnectWritable(nil))
+}
@@ -0,0 +1,26 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+//go:build !go1.20
+// +build !go1.20
+
+package tnet
+
+import "context"
+
+// newCauseContext returns a context which can be canceled. context.Cause is not supported
+// before go1.20, use CloseReason of the connection instead.
+func newCauseContext() (context.Context, func(error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return ctx, func(error) { cancel() }
+}
@@ -0,0 +1,153 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// NewConn adopts the stream connection c, such as the one hijacked from net/http or dialed by
+// other libraries, so that it is served by tnet poller in the same way as the dialed tnet
+// connection, see Dialer.Options for opt. c must implement syscall.Conn, the socket of it is
+// duplicated and c is closed on success. The data c has buffered in user space, such as the
+// bufio.Reader of the hijacked connection, is not moved to the returned connection.
+func NewConn(c net.Conn, opt ...Option) (Conn, error) {
+	if c == nil {
+		return nil, errors.New("tnet new conn: conn is nil")
+	}
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("tnet new conn: type %T doesn't implement syscall.Conn", c)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("tnet new conn: %w", err)
+	}
+	fd := -1
+	var dupErr error
+	if err := rc.Control(func(sysfd uintptr) {
+		fd, dupErr = dupFD(int(sysfd))
+	}); err != nil {
+		return nil, fmt.Errorf("tnet new conn: %w", err)
+	}
+	if dupErr != nil {
+		return nil, fmt.Errorf("tnet new conn: %w", dupErr)
+	}
+
+	opts := options{}
+	opts.setDefault()
+	for _, o := range opt {
+		o.f(&opts)
+	}
+	opts.applyTCPInterceptors(nil)
+	conn, err := newTCPConn(fd, c.LocalAddr().Network(), c.LocalAddr(), c.RemoteAddr(), &opts)
+	if err != nil {
+		return nil, fmt.Errorf("tnet new conn: %w", err)
+	}
+	// The socket is still open by the duplicated fd.
+	c.Close()
+	return conn, nil
+}
+
+// Detach detaches the socket of conn from tnet and returns it as a standard net.Conn, so that
+// it can be handed over to the libraries which want *net.TCPConn, use its File method to get
+// an *os.File. The data received but not read yet is returned, which must be consumed before
+// reading the returned connection. conn is closed with CloseReasonDetached, and the OnClosed
+// hook is called. Stop reading and writing conn before Detach, and Detach fails if the data
+// written to conn is not sent yet.
+func Detach(conn Conn) (net.Conn, []byte, error) {
+	tc, ok := conn.(*tcpconn)
+	if !ok || tc == nil {
+		return nil, nil, fmt.Errorf("tnet detach: type %T is not tnet tcp connection", conn)
+	}
+	if !tc.beginJobSafely(apiCtrl) {
+		return nil, nil, ErrConnClosed
+	}
+	if tc.outBuffer.LenRead() > 0 {
+		tc.endJobSafely(apiCtrl)
+		return nil, nil, errors.New("tnet detach: outbound data is not sent")
+	}
+	fd, err := dupFD(tc.nfd.FD())
+	tc.endJobSafely(apiCtrl)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tnet detach: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("tnet-%s", tc.RemoteAddr()))
+	defer f.Close()
+	nc, err := net.FileConn(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tnet detach: %w", err)
+	}
+
+	// The socket is not closed by tnet, since it is still open by the duplicated fd.
+	// The data received is moved to the closed read buffer.
+	tc.closeWithReason(CloseReasonDetached)
+	var unread []byte
+	if n := tc.closedReadBuf.LenRead(); n > 0 {
+		if unread, err = tc.closedReadBuf.Next(n); err != nil {
+			nc.Close()
+			return nil, nil, fmt.Errorf("tnet detach: %w", err)
+		}
+	}
+	return nc, unread, nil
+}
+
+// dupFD duplicates fd, the new one is close-on-exec and non-blocking.
+func dupFD(fd int) (int, error) {
+	nfd, err := unix.FcntlInt(uintptr(fd), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return -1, os.NewSyscallError("fcntl", err)
+	}
+	if err := unix.SetNonblock(nfd, true); err != nil {
+		unix.Close(nfd)
+		return -1, os.NewSyscallError("setnonblock", err)
+	}
+	return nfd, nil
+}
+
+// SyscallConn implements syscall.Conn. Only the Control method of the returned RawConn is
+// supported, since the socket is read and written by tnet poller.
+func (tc *tcpconn) SyscallConn() (syscall.RawConn, error) {
+	return tcpRawConn{tc: tc}, nil
+}
+
+type tcpRawConn struct {
+	tc *tcpconn
+}
+
+// Control calls f with the fd of the connection, which is valid only during the call.
+func (c tcpRawConn) Control(f func(fd uintptr)) error {
+	if !c.tc.beginJobSafely(apiCtrl) {
+		return ErrConnClosed
+	}
+	defer c.tc.endJobSafely(apiCtrl)
+	f(uintptr(c.tc.nfd.FD()))
+	return nil
+}
+
+// Read is not supported.
+func (c tcpRawConn) Read(func(fd uintptr) bool) error {
+	return errors.New("tnet: Read of RawConn is not supported")
+}
+
+// Write is not supported.
+func (c tcpRawConn) Write(func(fd uintptr) bool) error {
+	return errors.New("tnet: Write of RawConn is not supported")
+}
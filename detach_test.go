@@ -0,0 +1,124 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+)
+
+func TestNewConn(t *testing.T) {
+	addr := startGreetingServer(t, []byte("greeting"))
+	c, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+
+	received := make(chan []byte, 1)
+	conn, err := tnet.NewConn(c, tnet.WithOnTCPOpened(func(conn tnet.Conn) error {
+		return conn.SetOnRequest(func(conn tnet.Conn) error {
+			if conn.Len() < len("greeting") {
+				return tnet.EAGAIN
+			}
+			b, err := conn.Next(len("greeting"))
+			if err != nil {
+				return err
+			}
+			received <- append([]byte(nil), b...)
+			return conn.SetOnRequest(func(tnet.Conn) error { return nil })
+		})
+	}))
+	require.Nil(t, err)
+	defer conn.Close()
+	select {
+	case b := <-received:
+		assert.Equal(t, "greeting", string(b))
+	case <-time.After(time.Second):
+		t.Fatal("greeting is not received")
+	}
+	assert.Equal(t, c.LocalAddr().String(), conn.LocalAddr().String())
+	assert.Equal(t, addr, conn.RemoteAddr().String())
+
+	// c is closed, the socket is served by tnet.
+	_, err = c.Write(helloWorld)
+	assert.NotNil(t, err)
+	_, err = conn.Write(helloWorld)
+	require.Nil(t, err)
+
+	_, err = tnet.NewConn(nil)
+	assert.NotNil(t, err)
+	p1, p2 := net.Pipe()
+	defer p2.Close()
+	_, err = tnet.NewConn(p1)
+	assert.NotNil(t, err)
+}
+
+func TestDetach(t *testing.T) {
+	addr := startGreetingServer(t, []byte("hello world"))
+	closed := make(chan tnet.CloseReason, 1)
+	d := tnet.Dialer{Options: []tnet.Option{tnet.WithOnTCPClosed(func(conn tnet.Conn) error {
+		closed <- conn.(tnet.ContextConn).CloseReason()
+		return nil
+	})}}
+	conn, err := d.DialContext(context.Background(), "tcp", addr)
+	require.Nil(t, err)
+	b, err := conn.ReadN(len("hello"))
+	require.Nil(t, err)
+	assert.Equal(t, "hello", string(b))
+	require.Eventually(t, func() bool { return conn.Len() == len(" world") },
+		time.Second, 10*time.Millisecond)
+
+	nc, unread, err := tnet.Detach(conn)
+	require.Nil(t, err)
+	defer nc.Close()
+	assert.Equal(t, " world", string(unread))
+	assert.Equal(t, tnet.CloseReasonDetached, <-closed)
+	assert.False(t, conn.IsActive())
+	_, ok := nc.(*net.TCPConn)
+	assert.True(t, ok)
+
+	// The detached connection is still connected to the peer.
+	_, err = nc.Write(helloWorld)
+	require.Nil(t, err)
+	buf := make([]byte, len(helloWorld))
+	require.Nil(t, nc.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = io.ReadFull(nc, buf)
+	require.Nil(t, err)
+	assert.Equal(t, helloWorld, buf)
+
+	_, _, err = tnet.Detach(conn)
+	assert.NotNil(t, err)
+}
+
+func TestConn_SyscallConn(t *testing.T) {
+	addr := startGreetingServer(t, nil)
+	conn, err := tnet.DialTCP("tcp", addr, time.Second)
+	require.Nil(t, err)
+	rc, err := conn.(syscall.Conn).SyscallConn()
+	require.Nil(t, err)
+	var fd uintptr
+	require.Nil(t, rc.Control(func(sysfd uintptr) { fd = sysfd }))
+	assert.NotZero(t, fd)
+	assert.NotNil(t, rc.Read(func(uintptr) bool { return true }))
+	assert.NotNil(t, rc.Write(func(uintptr) bool { return true }))
+
+	require.Nil(t, conn.Close())
+	assert.NotNil(t, rc.Control(func(uintptr) {}))
+}
@@ -17,7 +17,9 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/url"
 	"sync"
+	"syscall"
 	"time"
 
 	"trpc.group/trpc-go/tnet/internal/iovec"
@@ -39,18 +41,211 @@ func DialContextTCP(ctx context.Context, network, address string) (Conn, error)
 }
 
 func dialTCP(ctx context.Context, network, address string, timeout time.Duration) (Conn, error) {
+	d := &Dialer{Timeout: timeout}
+	return d.DialContext(ctx, network, address)
+}
+
+// Dialer contains options for connecting to an address. The zero value is equivalent to
+// DialContextTCP.
+type Dialer struct {
+	// LocalAddr is the local address to use when dialing, it must be a *net.TCPAddr.
+	// If nil, a local address is automatically chosen.
+	LocalAddr net.Addr
+
+	// Timeout is the maximum amount of time a dial will wait for a connect to complete.
+	// The default is no timeout, and the deadline of the ctx is still respected.
+	Timeout time.Duration
+
+	// Control is called after creating the socket but before connecting, the same as
+	// net.Dialer.Control. Only the Control method of the syscall.RawConn is supported.
+	Control func(network, address string, c syscall.RawConn) error
+
+	// Options are applied to the dialed connection before it is scheduled on a poller,
+	// in the same way as they are applied to the connections of the tcp service, except
+	// that OnTCPOpened is called after it is scheduled. Use WithOnTCPOpened to set the
+	// TCPHandler by Conn.SetOnRequest, the data received before it is handled once the
+	// hook returns. Options about the service and udp are ignored.
+	Options []Option
+
+	// Proxy returns the proxy to connect to address through, the same as http.Transport.Proxy.
+	// If Proxy is nil or returns a nil URL, no proxy is used. The supported schemes are
+	// "socks5", "socks5h" and "http", see ProxyURL and ProxyFromEnvironment. The host is
+	// resolved locally with "socks5", and by the proxy with "socks5h". The handshake
+	// with the proxy is bounded by Timeout or the deadline of the ctx, or 30 seconds if
+	// neither is set.
+	Proxy func(address string) (*url.URL, error)
+
+	// ProxyHeader is the PROXY protocol header sent before any other data, see
+	// WithProxyProtocol. If both SourceAddr and DestAddr of the header are nil, the local
+	// and remote addresses of the connection are sent, the remote address is the proxy if
+	// Proxy is used.
+	ProxyHeader *ProxyHeader
+}
+
+// DialContext connects to the address on the named network using the provided context.
+// Valid networks for DialContext are "tcp", "tcp4" (IPv4-only), "tcp6" (IPv6-only).
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (Conn, error) {
 	reportDialTCP()
 	switch network {
 	case "tcp", "tcp4", "tcp6":
 	default:
 		return nil, fmt.Errorf("DialTCP: unknown network %s", network)
 	}
-	d := net.Dialer{Timeout: timeout}
-	c, err := d.DialContext(ctx, network, address)
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	conn, err := d.dialTCP(ctx, network, address)
 	if err != nil {
-		return nil, fmt.Errorf("dial network %s, address %s with timeout %+v error: %w", network, address, timeout, err)
+		return nil, fmt.Errorf("dial network %s, address %s with timeout %+v error: %w", network, address, d.Timeout, err)
+	}
+	return conn, nil
+}
+
+// dialTCP connects to address directly, or through the proxy returned by d.Proxy.
+// The connecting is done by tnet poller, so no net.Conn is created.
+func (d *Dialer) dialTCP(ctx context.Context, network, address string) (Conn, error) {
+	opts := options{}
+	opts.setDefault()
+	for _, o := range d.Options {
+		o.f(&opts)
+	}
+	opts.applyTCPInterceptors(nil)
+
+	if d.Proxy != nil {
+		proxy, err := d.Proxy(address)
+		if err != nil {
+			return nil, &net.OpError{Op: "proxyconnect", Net: network, Source: d.LocalAddr, Err: err}
+		}
+		if proxy != nil {
+			return d.dialProxy(ctx, network, address, proxy, &opts)
+		}
+	}
+	fd, laddr, raddr, err := d.connect(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if d.ProxyHeader == nil {
+		return newTCPConn(fd, network, laddr, raddr, &opts)
+	}
+	conn, err := scheduleTCPConn(fd, network, laddr, raddr, &opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.writeProxyHeader(conn); err != nil {
+		conn.closeWithReason(CloseReasonIOError)
+		return nil, &net.OpError{Op: "dial", Net: network, Source: laddr, Addr: raddr, Err: err}
+	}
+	if err := conn.openScheduled(&opts); err != nil {
+		return nil, err
+	}
+	conn.triggerRequest()
+	return conn, nil
+}
+
+// writeProxyHeader sends d.ProxyHeader on the connection.
+func (d *Dialer) writeProxyHeader(conn *tcpconn) error {
+	h := *d.ProxyHeader
+	if h.SourceAddr == nil && h.DestAddr == nil {
+		h.SourceAddr, h.DestAddr = conn.LocalAddr(), conn.RemoteAddr()
+	}
+	b, err := h.Encode()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(b)
+	return err
+}
+
+// connect connects to the addresses resolved from address in order until one succeeds.
+func (d *Dialer) connect(ctx context.Context, network, address string) (int, net.Addr, net.Addr, error) {
+	var laddr *net.TCPAddr
+	if d.LocalAddr != nil {
+		var ok bool
+		if laddr, ok = d.LocalAddr.(*net.TCPAddr); !ok {
+			return 0, nil, nil, &net.OpError{Op: "dial", Net: network, Source: d.LocalAddr,
+				Err: &net.AddrError{Err: "mismatched local address type", Addr: d.LocalAddr.String()}}
+		}
+	}
+	raddrs, err := resolveTCPAddrs(ctx, network, address)
+	if err != nil {
+		return 0, nil, nil, &net.OpError{Op: "dial", Net: network, Source: d.LocalAddr, Err: err}
 	}
-	return newTCPConn(c, network)
+
+	if laddr != nil && len(laddr.IP) != 0 && !laddr.IP.IsUnspecified() {
+		// Skip the addresses of which the ip version is different from the local address.
+		matched := raddrs[:0]
+		for _, raddr := range raddrs {
+			if (laddr.IP.To4() == nil) == (raddr.IP.To4() == nil) {
+				matched = append(matched, raddr)
+			}
+		}
+		raddrs = matched
+	}
+
+	var firstErr error
+	for i, raddr := range raddrs {
+		fd, la, err := d.connectOne(ctx, network, laddr, raddr, len(raddrs)-i)
+		if err == nil {
+			return fd, la, raddr, nil
+		}
+		if firstErr == nil {
+			firstErr = &net.OpError{Op: "dial", Net: network, Source: d.LocalAddr, Addr: raddr, Err: err}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = &net.OpError{Op: "dial", Net: network, Source: d.LocalAddr,
+			Err: &net.AddrError{Err: "no suitable address found", Addr: address}}
+	}
+	return 0, nil, nil, firstErr
+}
+
+// connectOne connects to raddr, which is one of the remaining addresses to try. Same as net.Dialer,
+// each address gets a share of the time left before the deadline of ctx, so that a black holed
+// address doesn't use up the time of the others.
+func (d *Dialer) connectOne(
+	ctx context.Context,
+	network string,
+	laddr, raddr *net.TCPAddr,
+	remaining int,
+) (int, net.Addr, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		partial, err := partialDeadline(time.Now(), deadline, remaining)
+		if err != nil {
+			return -1, nil, err
+		}
+		if partial.Before(deadline) {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, partial)
+			defer cancel()
+		}
+	}
+	return connectTCP(ctx, network, laddr, raddr, d.Control)
+}
+
+// partialDeadline returns the deadline to use for a single address, when multiple addresses
+// are pending, the same as net.Dialer.
+func partialDeadline(now, deadline time.Time, remaining int) (time.Time, error) {
+	timeRemaining := deadline.Sub(now)
+	if timeRemaining <= 0 {
+		return time.Time{}, context.DeadlineExceeded
+	}
+	// Tentatively allocate equal time to each remaining address.
+	timeout := timeRemaining / time.Duration(remaining)
+	// If the time per address is too short, steal from the end of the list.
+	const saneMinimum = 2 * time.Second
+	if timeout < saneMinimum {
+		if timeRemaining < saneMinimum {
+			timeout = timeRemaining
+		} else {
+			timeout = saneMinimum
+		}
+	}
+	return now.Add(timeout), nil
 }
 
 // DialUDP connects to the address on the named network within the timeout.
@@ -65,34 +260,81 @@ func DialUDP(network, address string, timeout time.Duration) (PacketConn, error)
 	return dialUDP(network, address, timeout)
 }
 
-func newTCPConn(c net.Conn, network string) (Conn, error) {
-	fd, err := netutil.GetFD(c)
+// newTCPConn schedules the connection on a poller and opens it, OnTCPOpened is called after
+// the connection is scheduled, so that it can write to the connection.
+func newTCPConn(fd int, network string, laddr, raddr net.Addr, opts *options) (Conn, error) {
+	conn, err := scheduleTCPConn(fd, network, laddr, raddr, opts)
 	if err != nil {
-		c.Close()
-		return nil, fmt.Errorf("dial tcp get fd error: %w", err)
+		return nil, err
 	}
+	if err := conn.openScheduled(opts); err != nil {
+		return nil, err
+	}
+	// The data received before OnTCPOpened sets the handler is handled now.
+	conn.triggerRequest()
+	return conn, nil
+}
+
+// scheduleTCPConn applies opts to the connection and schedules it on a poller, so that the
+// handshake can be done on it. The connection is kept blocking and without the OnTCPOpened
+// and OnTCPClosed hooks until it is opened by openScheduled.
+func scheduleTCPConn(fd int, network string, laddr, raddr net.Addr, opts *options) (*tcpconn, error) {
+	conn := allocTCPConn(fd, network, laddr, raddr)
+	handshakeOpts := *opts
+	handshakeOpts.nonblocking, handshakeOpts.onTCPClosed = false, nil
+	conn.interceptors = opts.tcpInterceptors
+	if err := conn.applyOptions(&handshakeOpts); err != nil {
+		conn.closeWithReason(CloseReasonIOError)
+		return nil, err
+	}
+	if err := conn.nfd.Schedule(tcpOnRead, tcpOnWrite, tcpOnHup, conn); err != nil {
+		conn.closeWithReason(CloseReasonIOError)
+		return nil, fmt.Errorf("dial tcp net fd schedule error: %w", err)
+	}
+	metrics.Add(metrics.TCPConnsCreate, 1)
+	return conn, nil
+}
+
+func allocTCPConn(fd int, network string, laddr, raddr net.Addr) *tcpconn {
 	conn := &tcpconn{
 		nfd: netFD{
 			fd:      fd,
 			fdtype:  fdTCP,
-			sock:    c,
-			laddr:   c.LocalAddr(),
-			raddr:   c.RemoteAddr(),
+			laddr:   laddr,
+			raddr:   raddr,
 			network: network,
 		},
 		readTrigger:    make(chan struct{}, 1),
 		closedFinished: make(chan struct{}, 1),
 		writevData:     iovec.NewIOData(),
 	}
+	conn.nfd.stats.init()
 	conn.inBuffer.Initialize()
 	conn.outBuffer.Initialize()
 	conn.closedReadBuf.Initialize(nil, ErrConnClosed)
-	if err := conn.nfd.Schedule(tcpOnRead, tcpOnWrite, tcpOnHup, conn); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("dial tcp net fd schedule error: %w", err)
+	return conn
+}
+
+// openScheduled opens the connection returned by scheduleTCPConn after the handshake, the options
+// left out by scheduleTCPConn are published atomically as the connection is being polled.
+// The connection is closed on error.
+func (tc *tcpconn) openScheduled(opts *options) error {
+	tc.SetNonBlocking(opts.nonblocking)
+	if opts.onTCPClosed != nil {
+		tc.SetOnClosed(opts.onTCPClosed)
 	}
-	metrics.Add(metrics.TCPConnsCreate, 1)
-	return conn, nil
+	return tc.callOnOpened(opts)
+}
+
+// callOnOpened executes the hook function set by the user for tcp connection creation.
+func (tc *tcpconn) callOnOpened(opts *options) error {
+	if opts.onTCPOpened != nil {
+		if err := tc.callTCPHandler(TCPHandler(opts.onTCPOpened)); err != nil {
+			tc.closeWithReason(CloseReasonHandlerError)
+			return fmt.Errorf("on tcp opened error: %w", err)
+		}
+	}
+	return nil
 }
 
 func dialUDP(network, address string, timeout time.Duration) (PacketConn, error) {
@@ -117,10 +359,11 @@ func dialUDP(network, address string, timeout time.Duration) (PacketConn, error)
 		},
 		readTrigger: make(chan struct{}, 1),
 	}
+	conn.nfd.stats.init()
 	conn.inBuffer.Initialize()
 	conn.outBuffer.Initialize()
 	if err := conn.schedule(); err != nil {
-		conn.Close()
+		conn.closeWithReason(CloseReasonIOError)
 		return nil, fmt.Errorf("dial udp net fd schedule error: %w", err)
 	}
 	return conn, nil
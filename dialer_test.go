@@ -15,11 +15,13 @@ package tnet_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -424,3 +426,165 @@ func containsAny(s string, substrings []string) bool {
 func contains(s, substr string) bool {
 	return len(substr) > 0 && len(s) >= len(substr) && strings.Contains(s, substr)
 }
+
+func TestDialTCP_Native(t *testing.T) {
+	ln, err := net.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	// The port is closed, the connecting is refused.
+	_, err = tnet.DialTCP("tcp", ln.Addr().String(), time.Second)
+	assert.True(t, errors.Is(err, syscall.ECONNREFUSED))
+
+	ln, err = net.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	defer ln.Close()
+	port = ln.Addr().(*net.TCPAddr).Port
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = tnet.DialContextTCP(ctx, "tcp", ln.Addr().String())
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	_, err = tnet.DialTCP("tcp6", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+	assert.NotNil(t, err)
+
+	// The host name is resolved.
+	conn, err := tnet.DialTCP("tcp4", fmt.Sprintf("localhost:%d", port), time.Second)
+	require.Nil(t, err)
+	defer conn.Close()
+	peer, err := ln.Accept()
+	require.Nil(t, err)
+	defer peer.Close()
+	assert.Equal(t, peer.RemoteAddr().String(), conn.LocalAddr().String())
+	assert.Equal(t, peer.LocalAddr().String(), conn.RemoteAddr().String())
+
+	_, err = conn.Write(helloWorld)
+	require.Nil(t, err)
+	buf := make([]byte, len(helloWorld))
+	_, err = io.ReadFull(peer, buf)
+	require.Nil(t, err)
+	assert.Equal(t, helloWorld, buf)
+}
+
+func TestDialer(t *testing.T) {
+	ln, err := net.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	defer ln.Close()
+	peers := make(chan net.Conn, 2)
+	go func() {
+		for {
+			peer, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// Send data at once, the handler set by OnTCPOpened must not miss it.
+			peer.Write(helloWorld)
+			peers <- peer
+		}
+	}()
+
+	var controlNetwork, controlAddress string
+	received := make(chan []byte, 1)
+	closed := make(chan struct{}, 1)
+	d := &tnet.Dialer{
+		LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Timeout:   time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			controlNetwork, controlAddress = network, address
+			return c.Control(func(fd uintptr) {
+				assert.Nil(t, syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1))
+			})
+		},
+		Options: []tnet.Option{
+			tnet.WithOnTCPOpened(func(conn tnet.Conn) error {
+				return conn.SetOnRequest(func(conn tnet.Conn) error {
+					data, err := conn.ReadN(len(helloWorld))
+					if err != nil {
+						return err
+					}
+					received <- data
+					return nil
+				})
+			}),
+			tnet.WithOnTCPClosed(func(conn tnet.Conn) error {
+				closed <- struct{}{}
+				return nil
+			}),
+		},
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	require.Nil(t, err)
+	peer := <-peers
+	defer peer.Close()
+	assert.Equal(t, "tcp4", controlNetwork)
+	assert.Equal(t, ln.Addr().String(), controlAddress)
+	assert.Equal(t, peer.RemoteAddr().String(), conn.LocalAddr().String())
+	select {
+	case data := <-received:
+		assert.Equal(t, helloWorld, data)
+	case <-time.After(time.Second):
+		t.Fatal("the data sent before the handler is set is missed")
+	}
+	require.Nil(t, conn.Close())
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnTCPClosed is not called")
+	}
+
+	// The error of OnTCPOpened fails the dialing.
+	d = &tnet.Dialer{Options: []tnet.Option{tnet.WithOnTCPOpened(func(tnet.Conn) error {
+		return errors.New("opened error")
+	})}}
+	_, err = d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	assert.NotNil(t, err)
+	if peer := <-peers; peer != nil {
+		peer.Close()
+	}
+
+	d = &tnet.Dialer{LocalAddr: &net.UDPAddr{}}
+	_, err = d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	assert.NotNil(t, err)
+
+	d = &tnet.Dialer{Control: func(string, string, syscall.RawConn) error {
+		return errors.New("control error")
+	}}
+	_, err = d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	assert.NotNil(t, err)
+}
+
+func TestDialer_OnTCPOpenedWrite(t *testing.T) {
+	ln, err := net.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	defer ln.Close()
+	// The write in OnTCPOpened is too large to be sent at once, so the conn waits for writable.
+	data := make([]byte, 8<<20)
+	received := make(chan int, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		time.Sleep(50 * time.Millisecond)
+		n, _ := io.ReadFull(c, make([]byte, len(data)))
+		received <- n
+	}()
+
+	d := tnet.Dialer{Timeout: time.Second, Options: []tnet.Option{
+		tnet.WithOnTCPOpened(func(conn tnet.Conn) error {
+			_, err := conn.Write(data)
+			return err
+		}),
+	}}
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	select {
+	case n := <-received:
+		assert.Equal(t, len(data), n)
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait data timeout")
+	}
+}
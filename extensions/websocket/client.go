@@ -15,6 +15,7 @@ package websocket
 
 import (
 	"bytes"
+	"context"
 	stdtls "crypto/tls"
 	"fmt"
 	"io"
@@ -95,7 +96,8 @@ func dial(u *neturl.URL, options *clientOptions) (rawConnection, error) {
 	switch u.Scheme {
 	case "ws":
 		_, addr := createHostPortAddress(u.Host, httpPort)
-		c, err := tnet.DialTCP("tcp", addr, options.timeout)
+		d := tnet.Dialer{Timeout: options.timeout, Proxy: options.proxy}
+		c, err := d.DialContext(context.Background(), "tcp", addr)
 		if err != nil {
 			return nil, err
 		}
@@ -112,6 +114,7 @@ func dial(u *neturl.URL, options *clientOptions) (rawConnection, error) {
 		c, err := tls.Dial("tcp", addr,
 			tls.WithClientTLSConfig(options.tlsConfig),
 			tls.WithTimeout(options.timeout),
+			tls.WithProxy(options.proxy),
 		)
 		if err != nil {
 			return nil, err
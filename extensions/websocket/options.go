@@ -18,7 +18,10 @@ import (
 	"crypto/tls"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
+
+	"trpc.group/trpc-go/tnet"
 )
 
 const defaultTimeout = 10 * time.Second
@@ -44,6 +47,8 @@ type serverOptions struct {
 	onClosed            func(Conn) error
 	combineWrites       bool
 	outboundBufferLimit int
+	proxyProtocol       tnet.ProxyProtocolMode
+	proxyHeaderTimeout  time.Duration
 }
 
 // ServerOption is the type for a single server option.
@@ -160,6 +165,22 @@ func WithServerOutboundBufferLimit(limit int) ServerOption {
 	}
 }
 
+// WithServerProxyProtocol sets whether the server reads the PROXY protocol header before
+// the websocket handshake, see tnet.WithProxyProtocol.
+func WithServerProxyProtocol(mode tnet.ProxyProtocolMode) ServerOption {
+	return func(o *serverOptions) {
+		o.proxyProtocol = mode
+	}
+}
+
+// WithServerProxyHeaderTimeout sets the timeout of reading the PROXY protocol header.
+// If d is 0, the default of tnet is used, if d < 0, there is no timeout.
+func WithServerProxyHeaderTimeout(d time.Duration) ServerOption {
+	return func(o *serverOptions) {
+		o.proxyHeaderTimeout = d
+	}
+}
+
 type clientOptions struct {
 	timeout                time.Duration
 	subprotocols           []string
@@ -169,6 +190,7 @@ type clientOptions struct {
 	messageType            MessageType
 	tlsConfig              *tls.Config
 	combineWrites          bool
+	proxy                  func(address string) (*url.URL, error)
 }
 
 func (o *clientOptions) setDefaults() {
@@ -270,3 +292,10 @@ func WithClientCombinedWrites(enabled bool) ClientOption {
 		o.combineWrites = enabled
 	}
 }
+
+// WithClientProxy sets the proxy to connect through, see tnet.Dialer.Proxy.
+func WithClientProxy(proxy func(address string) (*url.URL, error)) ClientOption {
+	return func(o *clientOptions) {
+		o.proxy = proxy
+	}
+}
@@ -45,8 +45,32 @@ func NewService(ln net.Listener, handler Handler, opts ...ServerOption) (tnet.Se
 	}, tcpServiceOptions(&options)...)
 }
 
+// NewHandler creates the handler of the websocket route of a protomux service, so that
+// websocket is served on the same listener as other protocols. If WithServerTLSConfig is
+// set, the route is wss and is matched by protomux.TLS. The options about the tcp
+// connection, such as WithTCPKeepAlive, are ignored, set them by protomux.WithTCPOptions.
+func NewHandler(handler Handler, opts ...ServerOption) tnet.TCPHandlers {
+	options := defaultServerOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.tlsConfig != nil {
+		return tls.NewHandler(func(c tls.Conn) error {
+			return handleWithOptions(&rawConn{Conn: c}, handler, &options)
+		}, tls.WithServerTLSConfig(options.tlsConfig), tls.WithOnClosed(onClosedTLS(options.onClosed)))
+	}
+	return tnet.TCPHandlers{
+		OnRequest: func(c tnet.Conn) error {
+			return handleWithOptions(c, handler, &options)
+		},
+		OnClosed: onClosed(options.onClosed),
+	}
+}
+
 func tlsServiceOptions(options *serverOptions) []tls.ServerOption {
 	return []tls.ServerOption{
+		tls.WithServerProxyProtocol(options.proxyProtocol),
+		tls.WithServerProxyHeaderTimeout(options.proxyHeaderTimeout),
 		tls.WithTCPKeepAlive(options.keepAlive),
 		tls.WithServerIdleTimeout(options.idleTimeout),
 		tls.WithServerTLSConfig(options.tlsConfig),
@@ -57,13 +81,20 @@ func tlsServiceOptions(options *serverOptions) []tls.ServerOption {
 }
 
 func tcpServiceOptions(options *serverOptions) []tnet.Option {
-	return []tnet.Option{
+	opts := []tnet.Option{
 		tnet.WithTCPKeepAlive(options.keepAlive),
 		tnet.WithTCPIdleTimeout(options.idleTimeout),
 		tnet.WithOnTCPClosed(onClosed(options.onClosed)),
 		tnet.WithFlushWrite(true), // Enable flushwrite for websocket.
 		tnet.WithTCPOutboundBufferLimit(options.outboundBufferLimit),
 	}
+	if options.proxyProtocol != tnet.ProxyProtocolDisabled {
+		opts = append(opts, tnet.WithProxyProtocol(options.proxyProtocol))
+	}
+	if options.proxyHeaderTimeout != 0 {
+		opts = append(opts, tnet.WithProxyHeaderTimeout(options.proxyHeaderTimeout))
+	}
+	return opts
 }
 
 type graderKey struct{}
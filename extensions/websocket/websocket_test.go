@@ -20,14 +20,19 @@ import (
 	"errors"
 	"io"
 	"log"
+	"net"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"trpc.group/trpc-go/tnet"
 	"trpc.group/trpc-go/tnet/extensions/websocket"
+	"trpc.group/trpc-go/tnet/protomux"
+	"trpc.group/trpc-go/tnet/proxytest"
 )
 
 var (
@@ -145,6 +150,114 @@ func TestServer(t *testing.T) {
 	}, nil)
 }
 
+func TestDialProxy(t *testing.T) {
+	proxy := proxytest.NewServerWithAuth("user", "pass")
+	defer proxy.Close()
+	for _, scheme := range []string{"socks5", "http"} {
+		runTestWithHandles(t, func(conn websocket.Conn) error {
+			tp, buf, err := conn.ReadMessage()
+			if err != nil {
+				return err
+			}
+			return conn.WriteMessage(tp, buf)
+		}, func(conn websocket.Conn) error {
+			require.Nil(t, conn.WriteMessage(websocket.Text, hello))
+			_, data, err := conn.ReadMessage()
+			require.Nil(t, err)
+			require.Equal(t, string(hello), string(data))
+			return nil
+		}, []websocket.ClientOption{websocket.WithClientProxy(tnet.ProxyURL(proxy.URL(scheme)))})
+	}
+	// Both ws and wss are dialed through the proxy.
+	require.Len(t, proxy.Targets(), 4)
+}
+
+func TestServerProxyProtocol(t *testing.T) {
+	done := make(chan struct{})
+	addr, cancel := runServer(t, func(conn websocket.Conn) error {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return err
+		}
+		return conn.WriteMessage(websocket.Text, []byte(conn.RemoteAddr().String()))
+	}, done, websocket.WithServerProxyProtocol(tnet.ProxyProtocolRequired))
+	d := ws.Dialer{NetDial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := net.Dial(network, address)
+		if err != nil {
+			return nil, err
+		}
+		h := &tnet.ProxyHeader{
+			Version:    2,
+			SourceAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5000},
+			DestAddr:   conn.RemoteAddr(),
+		}
+		b, err := h.Encode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = conn.Write(b)
+		return conn, err
+	}}
+	conn, _, _, err := d.Dial(context.Background(), wsURLPrefix+addr)
+	require.Nil(t, err)
+	require.Nil(t, wsutil.WriteClientText(conn, hello))
+	data, err := wsutil.ReadServerText(conn)
+	require.Nil(t, err)
+	require.Equal(t, "192.0.2.1:5000", string(data))
+	require.Nil(t, conn.Close())
+	cancel()
+	<-done
+}
+
+func TestNewHandler(t *testing.T) {
+	ln, err := tnet.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	echo := func(conn websocket.Conn) error {
+		tp, buf, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		return conn.WriteMessage(tp, buf)
+	}
+	s, err := protomux.NewService(ln,
+		protomux.WithRoute(protomux.TLS(), websocket.NewHandler(echo, websocket.WithServerTLSConfig(getTLSCfg()))),
+		protomux.WithRoute(protomux.WebSocket(), websocket.NewHandler(echo)),
+		protomux.WithFallback(protomux.Handler{OnRequest: func(conn tnet.Conn) error {
+			b, err := conn.Next(conn.Len())
+			if err != nil {
+				return err
+			}
+			_, err = conn.Write(b)
+			return err
+		}}),
+	)
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx)
+	addr := ln.Addr().String()
+
+	for _, url := range []string{wsURLPrefix + addr, wssURLPrefix + addr} {
+		conn, err := websocket.Dial(url, websocket.WithClientTLSConfig(&stdtls.Config{InsecureSkipVerify: true}))
+		require.Nil(t, err)
+		require.Nil(t, conn.WriteMessage(websocket.Text, hello))
+		_, data, err := conn.ReadMessage()
+		require.Nil(t, err)
+		require.Equal(t, string(hello), string(data))
+		require.Nil(t, conn.Close())
+	}
+
+	// Other protocols on the same port.
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(world)
+	require.Nil(t, err)
+	buf := make([]byte, len(world))
+	_, err = io.ReadFull(conn, buf)
+	require.Nil(t, err)
+	require.Equal(t, world, buf)
+}
+
 func TestServerBinary(t *testing.T) {
 	runTestWithHandles(t, func(conn websocket.Conn) error {
 		tp, buf, err := conn.ReadMessage()
@@ -0,0 +1,107 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"errors"
+
+	"trpc.group/trpc-go/tnet/internal/buffer"
+	"trpc.group/trpc-go/tnet/metrics"
+)
+
+// Forward moves the next n bytes from the inbound buffer of src to the outbound buffer of dst
+// and sends them. It waits until src has read at least n bytes like Next. Both src and dst must
+// be created by tnet.
+//
+// The memory blocks of src are handed over to dst without copying if all of their data is
+// forwarded. Only the last block is copied if n ends in the middle of it, or src is still
+// receiving data into it. The handed over blocks are recycled by dst once they are sent, so
+// the bytes got from src by Peek or PeekBlocks before Forward must not be used after Forward,
+// even before the Release of src.
+func Forward(dst, src Conn, n int) (int, error) {
+	from, ok := src.(*tcpconn)
+	if !ok || from == nil {
+		return 0, errors.New("forward source is not tnet tcp connection")
+	}
+	to, ok := dst.(*tcpconn)
+	if !ok || to == nil {
+		return 0, errors.New("forward destination is not tnet tcp connection")
+	}
+	d, err := from.detach(n)
+	if err != nil {
+		return 0, err
+	}
+	return to.writeBuffer(d)
+}
+
+// detach detaches the next n bytes from inBuffer, the returned buffer owns the data.
+func (tc *tcpconn) detach(n int) (d *buffer.Buffer, err error) {
+	isLocked := false
+	defer func() {
+		// release apiRead lock to avoid deadlock with closedFinished
+		if isLocked {
+			tc.endJobSafely(apiRead)
+		}
+		// if conn is closed,  wait storeReadBuffer finished and read from closedReadBuf
+		if tc.needReadFromClosedReadBuf(err) {
+			tc.waitReadFromClosedReadBuf()
+			var p []byte
+			if p, err = tc.closedReadBuf.Next(n); err == nil {
+				d = buffer.New()
+				d.Write(false, p)
+			}
+		}
+	}()
+
+	if isLocked = tc.beginJobSafely(apiRead); !isLocked {
+		return nil, ErrConnClosed
+	}
+
+	if err := tc.waitRead(n); err != nil {
+		return nil, err
+	}
+	return tc.inBuffer.Detach(n)
+}
+
+// writeBuffer joins d to outBuffer and sends it like Writev, d is recycled in any case.
+func (tc *tcpconn) writeBuffer(d *buffer.Buffer) (int, error) {
+	if tc.wtimer != nil && tc.wtimer.Expired() {
+		buffer.Free(d)
+		return 0, tc.writeTimeoutErr()
+	}
+	if !tc.beginJobSafely(apiWrite) {
+		buffer.Free(d)
+		return 0, ErrConnClosed
+	}
+	n, err := tc.outBuffer.JoinLimited(d, tc.outboundBufferLimit)
+	if err != nil {
+		buffer.Free(d)
+		tc.endJobSafely(apiWrite)
+		metrics.Add(metrics.TCPOutboundBufferLimitExceeded, 1)
+		tc.closeWithReason(CloseReasonLimitExceeded)
+		return 0, ErrOutboundBufferLimitExceeded
+	}
+	// The data is kept in outBuffer until the last Uncork.
+	if tc.corked.Load() > 0 {
+		tc.endJobSafely(apiWrite)
+		return n, nil
+	}
+	if err := tc.send(); err != nil {
+		tc.endJobSafely(apiWrite)
+		tc.closeWithReason(closeReasonOf(err))
+		return n, err
+	}
+	tc.endJobSafely(apiWrite)
+	return n, nil
+}
@@ -0,0 +1,78 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+)
+
+func TestForward(t *testing.T) {
+	// Backend echoes everything back.
+	backendLn, err := net.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	defer backendLn.Close()
+	go func() {
+		c, err := backendLn.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+	backend, err := tnet.DialTCP("tcp", backendLn.Addr().String(), time.Second)
+	require.Nil(t, err)
+	defer backend.Close()
+
+	// The proxy forwards the data between client and backend.
+	ln, err := tnet.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	s, err := tnet.NewTCPService(ln, func(conn tnet.Conn) error {
+		_, err := tnet.Forward(backend, conn, conn.Len())
+		return err
+	}, tnet.WithOnTCPOpened(func(conn tnet.Conn) error {
+		return backend.SetOnRequest(func(c tnet.Conn) error {
+			_, err := tnet.Forward(conn, c, c.Len())
+			return err
+		})
+	}))
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx)
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+	defer client.Close()
+	data := bytes.Repeat(helloWorld, 10000)
+	go client.Write(data)
+	buf := make([]byte, len(data))
+	require.Nil(t, client.SetReadDeadline(time.Now().Add(3*time.Second)))
+	_, err = io.ReadFull(client, buf)
+	require.Nil(t, err)
+	assert.Equal(t, data, buf)
+
+	_, err = tnet.Forward(nil, backend, 1)
+	assert.NotNil(t, err)
+	_, err = tnet.Forward(backend, nil, 1)
+	assert.NotNil(t, err)
+}
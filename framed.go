@@ -0,0 +1,172 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"errors"
+	"net"
+
+	"trpc.group/trpc-go/tnet/log"
+)
+
+// FrameReader is the read view of a connection handed to FrameDecoder.
+// Its methods never block, they return EAGAIN when fewer than n bytes are buffered.
+type FrameReader interface {
+	// Len returns the total length of the readable data in the reader.
+	Len() int
+
+	// Peek returns the next n bytes without advancing the reader.
+	// Zero-Copy API.
+	Peek(n int) ([]byte, error)
+
+	// Next returns the next n bytes with advancing the reader.
+	// Zero-Copy API.
+	Next(n int) ([]byte, error)
+
+	// Skip the next n bytes and advance the reader.
+	Skip(n int) error
+}
+
+// FrameDecoder decodes the next complete frame from r. It returns EAGAIN when the
+// frame is not complete yet, tnet calls it again once more data arrives.
+// A decoder must not consume bytes by Next or Skip before returning EAGAIN, it should
+// Peek until the whole frame is buffered. Any other error closes the connection.
+type FrameDecoder func(r FrameReader) ([]byte, error)
+
+// OnMessage fires when a complete frame is decoded from the tcp connection.
+// In FrameInOrder mode, frame stops being valid after OnMessage returns.
+// In FrameParallel mode, frame is owned by OnMessage.
+type OnMessage func(conn Conn, frame []byte) error
+
+// FrameConcurrency decides how the frames of one connection are dispatched to OnMessage.
+type FrameConcurrency int
+
+const (
+	// FrameInOrder calls OnMessage one frame after another in the order they are received.
+	FrameInOrder FrameConcurrency = iota
+	// FrameParallel calls OnMessage for each frame in its own task of the user goroutine pool,
+	// so frames of the same connection may be processed concurrently.
+	FrameParallel
+)
+
+// NewFramedService creates a tcp Service which decodes frames by decoder and calls onMessage
+// for each complete frame. The partial frame and EAGAIN handling is done by tnet.
+// Use WithFrameConcurrency to decide how the frames of one connection are dispatched.
+func NewFramedService(listener net.Listener, decoder FrameDecoder, onMessage OnMessage, opt ...Option) (Service, error) {
+	if decoder == nil {
+		return nil, errors.New("frame decoder is nil")
+	}
+	if onMessage == nil {
+		return nil, errors.New("onMessage is nil")
+	}
+	opts := options{}
+	opts.setDefault()
+	for _, o := range opt {
+		o.f(&opts)
+	}
+	return NewTCPService(listener, NewFramedHandler(decoder, onMessage, opts.frameConcurrency), opt...)
+}
+
+// errFrameNotConsumed is returned if the decoder returns a frame without consuming any data,
+// which would decode the same frame forever.
+var errFrameNotConsumed = errors.New("frame decoder returned a frame without consuming any data")
+
+// NewFramedHandler creates the TCPHandler used by NewFramedService, which decodes frames by decoder
+// and calls onMessage for each complete frame. It can be set to a client connection by
+// Conn.SetOnRequest, so that the responses are decoded in the same way.
+func NewFramedHandler(decoder FrameDecoder, onMessage OnMessage, concurrency FrameConcurrency) TCPHandler {
+	return func(conn Conn) error {
+		r := &frameReader{conn: conn}
+		for conn.IsActive() {
+			r.consumed = 0
+			frame, err := decoder(r)
+			if err != nil {
+				// EAGAIN is passed through, so the caller waits for more data.
+				return err
+			}
+			if r.consumed == 0 {
+				return errFrameNotConsumed
+			}
+			if concurrency == FrameParallel {
+				if err := dispatchFrame(conn, frame, onMessage); err != nil {
+					return err
+				}
+				continue
+			}
+			err = onMessage(conn, frame)
+			conn.Release()
+			if err != nil {
+				return err
+			}
+		}
+		return ErrConnClosed
+	}
+}
+
+func dispatchFrame(conn Conn, frame []byte, onMessage OnMessage) error {
+	// The frame refers to the connection buffer, which is released before
+	// the task runs, copy it to hand the ownership over to onMessage.
+	owned := make([]byte, len(frame))
+	copy(owned, frame)
+	conn.Release()
+	return Submit(func() {
+		if err := onMessage(conn, owned); err != nil {
+			log.Debugf("framed service onMessage err: %v\n", err)
+			conn.Close()
+		}
+	})
+}
+
+type frameReader struct {
+	conn Conn
+	// consumed is the number of bytes consumed by the decoder in the current call.
+	consumed int
+}
+
+// Len returns the total length of the readable data in the reader.
+func (r *frameReader) Len() int {
+	return r.conn.Len()
+}
+
+// Peek returns the next n bytes without advancing the reader.
+func (r *frameReader) Peek(n int) ([]byte, error) {
+	if r.conn.Len() < n {
+		return nil, EAGAIN
+	}
+	return r.conn.Peek(n)
+}
+
+// Next returns the next n bytes with advancing the reader.
+func (r *frameReader) Next(n int) ([]byte, error) {
+	if r.conn.Len() < n {
+		return nil, EAGAIN
+	}
+	b, err := r.conn.Next(n)
+	if err == nil {
+		r.consumed += n
+	}
+	return b, err
+}
+
+// Skip the next n bytes and advance the reader.
+func (r *frameReader) Skip(n int) error {
+	if r.conn.Len() < n {
+		return EAGAIN
+	}
+	err := r.conn.Skip(n)
+	if err == nil {
+		r.consumed += n
+	}
+	return err
+}
@@ -0,0 +1,198 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+)
+
+// lengthFieldDecoder decodes frames with a 2 bytes big endian length header.
+func lengthFieldDecoder(r tnet.FrameReader) ([]byte, error) {
+	head, err := r.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(head))
+	if r.Len() < 2+n {
+		return nil, tnet.EAGAIN
+	}
+	if err := r.Skip(2); err != nil {
+		return nil, err
+	}
+	return r.Next(n)
+}
+
+func encodeFrame(p []byte) []byte {
+	b := make([]byte, 2+len(p))
+	binary.BigEndian.PutUint16(b, uint16(len(p)))
+	copy(b[2:], p)
+	return b
+}
+
+func TestFramedService(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []tnet.Option
+	}{
+		{"default", nil},
+		{"in order", []tnet.Option{tnet.WithFrameConcurrency(tnet.FrameInOrder)}},
+		{"parallel", []tnet.Option{tnet.WithFrameConcurrency(tnet.FrameParallel)}},
+		{"nonblocking", []tnet.Option{tnet.WithNonBlocking(true)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				frames []string
+			)
+			done := make(chan struct{}, 3)
+			ln, err := tnet.Listen("tcp", getTestAddr())
+			require.Nil(t, err)
+			s, err := tnet.NewFramedService(ln, lengthFieldDecoder, func(conn tnet.Conn, frame []byte) error {
+				mu.Lock()
+				frames = append(frames, string(frame))
+				mu.Unlock()
+				done <- struct{}{}
+				return nil
+			}, tt.opts...)
+			require.Nil(t, err)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go s.Serve(ctx)
+
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			require.Nil(t, err)
+			defer conn.Close()
+
+			// Send the first frame in two parts, then two frames at once.
+			first := encodeFrame(hello)
+			_, err = conn.Write(first[:3])
+			require.Nil(t, err)
+			time.Sleep(10 * time.Millisecond)
+			_, err = conn.Write(append(first[3:], append(encodeFrame(world), encodeFrame(helloWorld)...)...))
+			require.Nil(t, err)
+			for i := 0; i < 3; i++ {
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+					t.Fatal("wait frame timeout")
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			sort.Strings(frames)
+			assert.Equal(t, []string{string(hello), string(helloWorld), string(world)}, frames)
+		})
+	}
+}
+
+func TestFramedServiceInvalidParams(t *testing.T) {
+	ln, err := tnet.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	defer ln.Close()
+	_, err = tnet.NewFramedService(ln, nil, func(tnet.Conn, []byte) error { return nil })
+	assert.NotNil(t, err)
+	_, err = tnet.NewFramedService(ln, lengthFieldDecoder, nil)
+	assert.NotNil(t, err)
+}
+
+func TestFramedServiceDecoderNotConsuming(t *testing.T) {
+	ln, err := tnet.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	var calls int32
+	s, err := tnet.NewFramedService(ln, func(r tnet.FrameReader) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, nil
+	}, func(tnet.Conn, []byte) error { return nil })
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(hello)
+	require.Nil(t, err)
+	// The connection is closed instead of calling the decoder forever.
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestFramedServiceDataArrivesBeforeEAGAIN(t *testing.T) {
+	first := encodeFrame(make([]byte, 100))
+	second := encodeFrame(helloWorld)
+	var delivered int32
+	stalled := make(chan struct{})
+	var once sync.Once
+	received := make(chan []byte, 2)
+	ln, err := tnet.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	s, err := tnet.NewFramedService(ln, func(r tnet.FrameReader) ([]byte, error) {
+		frame, err := lengthFieldDecoder(r)
+		if err == tnet.EAGAIN && atomic.LoadInt32(&delivered) == 1 && r.Len() > 0 {
+			// The rest of the second frame arrives after the decoder gives up, but before
+			// the handler returns EAGAIN.
+			once.Do(func() {
+				l := r.Len()
+				close(stalled)
+				for i := 0; i < 100 && r.Len() == l; i++ {
+					time.Sleep(time.Millisecond)
+				}
+			})
+		}
+		return frame, err
+	}, func(conn tnet.Conn, frame []byte) error {
+		atomic.AddInt32(&delivered, 1)
+		received <- append([]byte(nil), frame...)
+		return nil
+	})
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(append(append([]byte(nil), first...), second[:3]...))
+	require.Nil(t, err)
+	select {
+	case <-stalled:
+	case <-time.After(time.Second):
+		t.Fatal("wait decoder timeout")
+	}
+	_, err = conn.Write(second[3:])
+	require.Nil(t, err)
+	for _, want := range [][]byte{make([]byte, 100), helloWorld} {
+		select {
+		case frame := <-received:
+			assert.Equal(t, want, frame)
+		case <-time.After(time.Second):
+			t.Fatal("wait frame timeout")
+		}
+	}
+}
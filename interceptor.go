@@ -0,0 +1,196 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"trpc.group/trpc-go/tnet/log"
+)
+
+// HookKind denotes which hook of a connection is intercepted.
+type HookKind int
+
+const (
+	// HookRequest is the TCPHandler or UDPHandler which fires when data is received.
+	HookRequest HookKind = iota
+	// HookOpened is the OnTCPOpened hook.
+	HookOpened
+	// HookClosed is the OnTCPClosed or OnUDPClosed hook.
+	HookClosed
+)
+
+// String implements fmt.Stringer.
+func (k HookKind) String() string {
+	switch k {
+	case HookRequest:
+		return "Request"
+	case HookOpened:
+		return "Opened"
+	case HookClosed:
+		return "Closed"
+	default:
+		return fmt.Sprintf("HookKind(%d)", k)
+	}
+}
+
+// TCPInterceptor intercepts the execution of a tcp connection hook of the given kind.
+// It must call next to continue the chain.
+type TCPInterceptor func(conn Conn, kind HookKind, next TCPHandler) error
+
+// UDPInterceptor intercepts the execution of a udp connection hook of the given kind.
+// It must call next to continue the chain.
+type UDPInterceptor func(conn PacketConn, kind HookKind, next UDPHandler) error
+
+// chainTCPInterceptors composes interceptors around handler, the first interceptor is the outermost one.
+func chainTCPInterceptors(interceptors []TCPInterceptor, kind HookKind, handler TCPHandler) TCPHandler {
+	if len(interceptors) == 0 || handler == nil {
+		return handler
+	}
+	chained := handler
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], chained
+		chained = func(conn Conn) error {
+			return interceptor(conn, kind, next)
+		}
+	}
+	return chained
+}
+
+// chainUDPInterceptors composes interceptors around handler, the first interceptor is the outermost one.
+func chainUDPInterceptors(interceptors []UDPInterceptor, kind HookKind, handler UDPHandler) UDPHandler {
+	if len(interceptors) == 0 || handler == nil {
+		return handler
+	}
+	chained := handler
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], chained
+		chained = func(conn PacketConn) error {
+			return interceptor(conn, kind, next)
+		}
+	}
+	return chained
+}
+
+// applyTCPInterceptors wraps the request handler and the open/close hooks with the tcp interceptors.
+// The open/close hooks are intercepted even if the user doesn't set them.
+func (o *options) applyTCPInterceptors(handler TCPHandler) TCPHandler {
+	if len(o.tcpInterceptors) == 0 {
+		return handler
+	}
+	onOpened, onClosed := o.onTCPOpened, o.onTCPClosed
+	if onOpened == nil {
+		onOpened = func(Conn) error { return nil }
+	}
+	if onClosed == nil {
+		onClosed = func(Conn) error { return nil }
+	}
+	o.onTCPOpened = OnTCPOpened(chainTCPInterceptors(o.tcpInterceptors, HookOpened, TCPHandler(onOpened)))
+	o.onTCPClosed = OnTCPClosed(chainTCPInterceptors(o.tcpInterceptors, HookClosed, TCPHandler(onClosed)))
+	return chainTCPInterceptors(o.tcpInterceptors, HookRequest, handler)
+}
+
+// applyUDPInterceptors wraps the request handler and the close hook with the udp interceptors.
+// The close hook is intercepted even if the user doesn't set it.
+func (o *options) applyUDPInterceptors(handler UDPHandler) UDPHandler {
+	if len(o.udpInterceptors) == 0 {
+		return handler
+	}
+	onClosed := o.onUDPClosed
+	if onClosed == nil {
+		onClosed = func(PacketConn) error { return nil }
+	}
+	o.onUDPClosed = OnUDPClosed(chainUDPInterceptors(o.udpInterceptors, HookClosed, UDPHandler(onClosed)))
+	return chainUDPInterceptors(o.udpInterceptors, HookRequest, handler)
+}
+
+// RecoveryTCPInterceptor recovers the panic of the tcp hooks, logs it with the stack,
+// and turns it into an error so that the connection is closed.
+func RecoveryTCPInterceptor() TCPInterceptor {
+	return func(conn Conn, kind HookKind, next TCPHandler) error {
+		return callWithRecovery(kind, func() error { return next(conn) })
+	}
+}
+
+// RecoveryUDPInterceptor recovers the panic of the udp hooks and logs it with the stack.
+// The udp conn is shared by all the peers, so it is kept open, and the packet that the
+// request handler panics on is dropped if the handler hasn't read it.
+func RecoveryUDPInterceptor() UDPInterceptor {
+	return func(conn PacketConn, kind HookKind, next UDPHandler) (err error) {
+		uc, ok := conn.(*udpconn)
+		if kind != HookRequest || !ok {
+			return callWithRecovery(kind, func() error { return next(conn) })
+		}
+		read := uc.packetsRead.Load()
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("tnet %s hook panic: %v\n%s", kind, r, debug.Stack())
+				uc.dropUnreadPacket(read)
+				err = nil
+			}
+		}()
+		return next(conn)
+	}
+}
+
+func callWithRecovery(kind HookKind, f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("tnet %s hook panic: %v\n%s", kind, r, debug.Stack())
+			err = fmt.Errorf("tnet %s hook panic: %v", kind, r)
+		}
+	}()
+	return f()
+}
+
+// LatencyTCPInterceptor measures the time cost of the tcp hooks and reports it by report.
+func LatencyTCPInterceptor(report func(conn Conn, kind HookKind, cost time.Duration)) TCPInterceptor {
+	return func(conn Conn, kind HookKind, next TCPHandler) error {
+		begin := time.Now()
+		err := next(conn)
+		report(conn, kind, time.Since(begin))
+		return err
+	}
+}
+
+// LatencyUDPInterceptor measures the time cost of the udp hooks and reports it by report.
+func LatencyUDPInterceptor(report func(conn PacketConn, kind HookKind, cost time.Duration)) UDPInterceptor {
+	return func(conn PacketConn, kind HookKind, next UDPHandler) error {
+		begin := time.Now()
+		err := next(conn)
+		report(conn, kind, time.Since(begin))
+		return err
+	}
+}
+
+// SlowTCPInterceptor logs the tcp hooks whose time cost exceeds threshold.
+func SlowTCPInterceptor(threshold time.Duration) TCPInterceptor {
+	return LatencyTCPInterceptor(func(conn Conn, kind HookKind, cost time.Duration) {
+		if cost > threshold {
+			log.Warnf("tnet slow tcp %s hook, local: %s, remote: %s, cost: %s",
+				kind, conn.LocalAddr(), conn.RemoteAddr(), cost)
+		}
+	})
+}
+
+// SlowUDPInterceptor logs the udp hooks whose time cost exceeds threshold.
+func SlowUDPInterceptor(threshold time.Duration) UDPInterceptor {
+	return LatencyUDPInterceptor(func(conn PacketConn, kind HookKind, cost time.Duration) {
+		if cost > threshold {
+			log.Warnf("tnet slow udp %s hook, local: %s, cost: %s", kind, conn.LocalAddr(), cost)
+		}
+	})
+}
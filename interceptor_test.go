@@ -0,0 +1,211 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+)
+
+func TestTCPInterceptors(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		trace []string
+	)
+	record := func(name string) tnet.TCPInterceptor {
+		return func(conn tnet.Conn, kind tnet.HookKind, next tnet.TCPHandler) error {
+			mu.Lock()
+			trace = append(trace, name+kind.String())
+			mu.Unlock()
+			return next(conn)
+		}
+	}
+	closed := make(chan struct{})
+	var latency time.Duration
+	ln, err := tnet.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	s, err := tnet.NewTCPService(ln, func(conn tnet.Conn) error {
+		_, err := conn.Next(conn.Len())
+		if err != nil {
+			return err
+		}
+		panic("handler panic")
+	},
+		tnet.WithTCPInterceptors(record("a"), record("b")),
+		tnet.WithTCPInterceptors(
+			tnet.LatencyTCPInterceptor(func(conn tnet.Conn, kind tnet.HookKind, cost time.Duration) {
+				mu.Lock()
+				latency += cost
+				mu.Unlock()
+				if kind == tnet.HookClosed {
+					close(closed)
+				}
+			}),
+			tnet.SlowTCPInterceptor(time.Nanosecond),
+			tnet.RecoveryTCPInterceptor(),
+		),
+	)
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(helloWorld)
+	require.Nil(t, err)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("wait conn closed timeout")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"aOpened", "bOpened", "aRequest", "bRequest", "aClosed", "bClosed"}, trace)
+	assert.NotZero(t, latency)
+}
+
+func TestTCPInterceptors_Dialed(t *testing.T) {
+	ln, err := net.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Write(helloWorld)
+		time.Sleep(100 * time.Millisecond)
+	}()
+
+	kinds := make(chan tnet.HookKind, 3)
+	d := &tnet.Dialer{Options: []tnet.Option{tnet.WithTCPInterceptors(
+		func(conn tnet.Conn, kind tnet.HookKind, next tnet.TCPHandler) error {
+			kinds <- kind
+			return next(conn)
+		},
+	)}}
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	done := make(chan struct{})
+	require.Nil(t, conn.SetOnRequest(func(conn tnet.Conn) error {
+		_, err := conn.ReadN(len(helloWorld))
+		close(done)
+		return err
+	}))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("wait request timeout")
+	}
+	assert.Equal(t, tnet.HookOpened, <-kinds)
+	assert.Equal(t, tnet.HookRequest, <-kinds)
+}
+
+func TestUDPInterceptors(t *testing.T) {
+	var calls []tnet.HookKind
+	lns, err := tnet.ListenPackets("udp", getTestAddr(), false)
+	require.Nil(t, err)
+	done := make(chan struct{})
+	s, err := tnet.NewUDPService(lns, func(conn tnet.PacketConn) error {
+		p, _, err := conn.ReadPacket()
+		if err != nil {
+			return err
+		}
+		p.Free()
+		close(done)
+		return nil
+	}, tnet.WithUDPInterceptors(
+		func(conn tnet.PacketConn, kind tnet.HookKind, next tnet.UDPHandler) error {
+			calls = append(calls, kind)
+			return next(conn)
+		},
+		tnet.SlowUDPInterceptor(time.Hour),
+		tnet.RecoveryUDPInterceptor(),
+	))
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Serve(ctx)
+
+	conn, err := net.Dial("udp", lns[0].LocalAddr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(helloWorld)
+	require.Nil(t, err)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("wait udp packet timeout")
+	}
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, []tnet.HookKind{tnet.HookRequest, tnet.HookClosed}, calls)
+}
+
+func TestRecoveryUDPInterceptor(t *testing.T) {
+	lns, err := tnet.ListenPackets("udp", getTestAddr(), false)
+	require.Nil(t, err)
+	var panicked bool
+	s, err := tnet.NewUDPService(lns, func(conn tnet.PacketConn) error {
+		if !panicked {
+			panicked = true
+			panic("handler")
+		}
+		p, addr, err := conn.ReadPacket()
+		if err != nil {
+			return err
+		}
+		defer p.Free()
+		data, err := p.Data()
+		if err != nil {
+			return err
+		}
+		_, err = conn.WriteTo(data, addr)
+		return err
+	}, tnet.WithUDPInterceptors(tnet.RecoveryUDPInterceptor()))
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx)
+
+	conn, err := net.Dial("udp", lns[0].LocalAddr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(helloWorld)
+	require.Nil(t, err)
+	// The panicking packet is dropped, and the udp conn is kept open for the next packet.
+	time.Sleep(10 * time.Millisecond)
+	_, err = conn.Write(hello)
+	require.Nil(t, err)
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	b := make([]byte, 64)
+	n, err := conn.Read(b)
+	require.Nil(t, err)
+	assert.Equal(t, hello, b[:n])
+}
+
+func TestHookKindString(t *testing.T) {
+	assert.Equal(t, "Request", tnet.HookRequest.String())
+	assert.Equal(t, "HookKind(10)", tnet.HookKind(10).String())
+}
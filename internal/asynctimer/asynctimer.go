@@ -59,6 +59,13 @@ func Del(timer *Timer) {
 	defaultTimeWheel.Del(timer)
 }
 
+// NowUnixNano returns the wall time cached by the default time wheel in unix nanoseconds,
+// which is refreshed on each tick. It is much cheaper than time.Now, and its precision
+// is 1 second.
+func NowUnixNano() int64 {
+	return defaultTimeWheel.NowUnixNano()
+}
+
 // Stop stops the default time wheel.
 func Stop() {
 	defaultTimeWheel.Stop()
@@ -92,7 +99,8 @@ func NewTimer(data interface{}, expiredHandle Callback, timeout time.Duration) *
 
 // TimeWheel manages all async timers.
 type TimeWheel struct {
-	now         time.Time
+	now         atomic.Time
+	wallNow     atomic.Int64
 	timersToAdd chan *Timer
 	timersToDel chan *Timer
 	quit        chan struct{}
@@ -114,7 +122,6 @@ func NewTimeWheel(interval time.Duration, slotNum int) (*TimeWheel, error) {
 	}
 
 	t := &TimeWheel{
-		now:         time.Now(),
 		interval:    interval,
 		slotNum:     slotNum,
 		currSlot:    0,
@@ -122,6 +129,8 @@ func NewTimeWheel(interval time.Duration, slotNum int) (*TimeWheel, error) {
 		timersToDel: make(chan *Timer, defaultChanSize),
 		quit:        make(chan struct{}),
 	}
+	t.now.Store(time.Now())
+	t.wallNow.Store(time.Now().UnixNano())
 	t.timerToSlot = make(map[*Timer]*slot)
 	t.slots = make([]*slot, t.slotNum)
 	for i := 0; i < t.slotNum; i++ {
@@ -136,11 +145,17 @@ func (t *TimeWheel) Start() {
 	go t.run()
 }
 
+// NowUnixNano returns the wall time cached by the time wheel in unix nanoseconds,
+// which is refreshed on each tick.
+func (t *TimeWheel) NowUnixNano() int64 {
+	return t.wallNow.Load()
+}
+
 // Add adds timer to time wheel. Timer becomes effective after having been added
 // to time wheel.
 func (t *TimeWheel) Add(timer *Timer) error {
 	if timer.isActive {
-		timer.begin.Store(t.now)
+		timer.begin.Store(t.now.Load())
 		// Reset timer delay to timer timeout.
 		timer.delay = timer.timeout
 		return nil
@@ -152,7 +167,7 @@ func (t *TimeWheel) Add(timer *Timer) error {
 	// Reset timer delay to timer timeout.
 	timer.delay = timer.timeout
 	timer.delay = timer.delay.Round(t.interval)
-	timer.begin.Store(t.now)
+	timer.begin.Store(t.now.Load())
 	t.timersToAdd <- timer
 	return nil
 }
@@ -201,7 +216,8 @@ func (t *TimeWheel) quitHandle() {
 }
 
 func (t *TimeWheel) tickHandle() {
-	t.now = t.now.Add(t.interval)
+	t.now.Store(t.now.Load().Add(t.interval))
+	t.wallNow.Store(time.Now().UnixNano())
 	t.currSlot = (t.currSlot + 1) % t.slotNum
 	s := t.slots[t.currSlot]
 	for timer := range s.timers {
@@ -220,7 +236,7 @@ func (t *TimeWheel) checkExpire(timer *Timer) {
 	if timer.expiredHandle == nil {
 		return
 	}
-	actual := t.now.Sub(timer.begin.Load())
+	actual := t.now.Load().Sub(timer.begin.Load())
 	// Expired.
 	if actual >= timer.timeout {
 		timer.expiredHandle(timer.data)
@@ -124,3 +124,18 @@ func TestTimerFunction(t *testing.T) {
 	require.NoError(t, asynctimer.Add(timer))
 	require.False(t, data.isHandled)
 }
+
+func TestTimeWheelNowUnixNano(t *testing.T) {
+	timeUnit := 10 * time.Millisecond
+	tw, err := asynctimer.NewTimeWheel(timeUnit, 3)
+	require.Nil(t, err)
+	begin := tw.NowUnixNano()
+	assert.NotZero(t, begin)
+	tw.Start()
+	defer tw.Stop()
+	assert.Eventually(t, func() bool {
+		return tw.NowUnixNano() > begin
+	}, time.Second, timeUnit)
+	assert.LessOrEqual(t, tw.NowUnixNano(), time.Now().UnixNano())
+	assert.NotZero(t, asynctimer.NowUnixNano())
+}
@@ -87,6 +87,9 @@ type Buffer struct {
 	// wlen denotes the number of bytes that can be written to the buffer.
 	// It increases when new nodes are added and decreases when data is written.
 	wlen atomic.Uint32
+	// written denotes the total number of bytes ever written by Write/Writev/Join,
+	// it is protected by wlock.
+	written uint64
 
 	// nodeBlockSize denotes the size of the block when adding
 	// a new node to the buffer.
@@ -358,6 +361,191 @@ func (b *Buffer) PeekBlocks(data [][]byte) int {
 	return blocks
 }
 
+// PeekBlocksN returns the next n bytes as blocks without advancing the buffer.
+// If the data length is less than n, return ErrNoEnoughData error.
+// Zero-Copy API.
+func (b *Buffer) PeekBlocksN(n int) ([][]byte, error) {
+	if n < 0 {
+		return nil, ErrInvalidParam
+	}
+	b.rlock.Lock()
+	defer b.rlock.Unlock()
+	if b.LenRead() < n {
+		return nil, ErrNoEnoughData
+	}
+	var blocks [][]byte
+	for rest, rnode := n, b.rnode; rest > 0; rnode = rnode.next {
+		l := rnode.len()
+		if l == 0 {
+			continue
+		}
+		if l > rest {
+			l = rest
+		}
+		blocks = append(blocks, rnode.block[rnode.r:int(rnode.r)+l])
+		rest -= l
+	}
+	return blocks, nil
+}
+
+// NextBlocksN returns the next n bytes as blocks and advances the buffer.
+// If the data length is less than n, return ErrNoEnoughData error.
+// Zero-Copy API.
+func (b *Buffer) NextBlocksN(n int) ([][]byte, error) {
+	if n < 0 {
+		return nil, ErrInvalidParam
+	}
+	b.rlock.Lock()
+	defer b.rlock.Unlock()
+	if b.LenRead() < n {
+		return nil, ErrNoEnoughData
+	}
+	var blocks [][]byte
+	rnode := b.rnode
+	for rest := n; rest > 0; {
+		l := rnode.len()
+		if l == 0 {
+			rnode = rnode.next
+			continue
+		}
+		if l > rest {
+			l = rest
+		}
+		s, err := rnode.readn(l)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, s)
+		rest -= l
+	}
+	b.rnode = rnode
+	b.rlen.Sub(uint32(n))
+	return blocks, nil
+}
+
+// Detach advances the buffer by n bytes and returns them in a new buffer which owns the data.
+// A block is handed over without copying only if all of its unread data is detached and it won't
+// be written anymore, that is, it is not the block being written unless it is full. Otherwise the
+// data is copied, which happens at most for the last block, e.g. when n ends in the middle of it.
+// The handed over blocks are recycled by the returned buffer instead of b, so the slices got by
+// Peek or PeekBlocksN before Detach must not be used once the returned buffer is freed, even if
+// b is not released yet.
+// The returned buffer should be recycled by Free(b) when it is no longer in use.
+func (b *Buffer) Detach(n int) (*Buffer, error) {
+	if n < 0 {
+		return nil, ErrInvalidParam
+	}
+	b.rwLock()
+	defer b.rwUnlock()
+	if b.LenRead() < n {
+		return nil, ErrNoEnoughData
+	}
+	var c chain
+	var prev *node
+	rnode := b.rnode
+	for rest := n; rest > 0; {
+		l := rnode.len()
+		if l == 0 {
+			rnode = rnode.next
+			continue
+		}
+		if l > rest {
+			l = rest
+		}
+		p := rnode.block[rnode.r : int(rnode.r)+l]
+		dn := allocNode()
+		// A block can be handed over only if no more data will be read from
+		// or written to it by this buffer.
+		if l == rnode.len() && (rnode != b.wnode || rnode.isFull()) {
+			dn.block, dn.recycle = p, rnode.recycle
+			rnode.recycle = false
+		} else {
+			dn.allocBlockN(l)
+			copy(dn.block, p)
+		}
+		dn.w = uint32(l)
+		if err := rnode.skip(l); err != nil {
+			freeNode(dn)
+			return nil, err
+		}
+		if prev == nil {
+			c.head = dn
+		} else {
+			prev.next = dn
+		}
+		prev, c.tail = dn, dn
+		c.dataLen += l
+		rest -= l
+	}
+	b.rnode = rnode
+	b.rlen.Sub(uint32(n))
+
+	d := New()
+	if c.dataLen > 0 {
+		d.addChain(&c)
+	}
+	return d, nil
+}
+
+// Join appends the unread data of d to the buffer without copying, and the memory owned
+// by d is handed over to the buffer. d is recycled and must not be used anymore.
+func (b *Buffer) Join(d *Buffer) int {
+	b.wlock.Lock()
+	defer b.wlock.Unlock()
+	return b.joinLocked(d)
+}
+
+// JoinLimited joins d to the buffer unless the joined data would exceed limit.
+// If limit is less than or equal to 0, the limit check is disabled.
+// d is left untouched if ErrBufferFull is returned.
+func (b *Buffer) JoinLimited(d *Buffer, limit int) (int, error) {
+	b.wlock.Lock()
+	defer b.wlock.Unlock()
+	if limit > 0 && d.LenRead() > limit-b.LenRead() {
+		return 0, ErrBufferFull
+	}
+	return b.joinLocked(d), nil
+}
+
+func (b *Buffer) joinLocked(d *Buffer) int {
+	d.rwLock()
+	// Free the nodes before rnode, which have been read.
+	for pNode := d.head; pNode != d.rnode; {
+		next := pNode.next
+		freeNode(pNode)
+		pNode = next
+	}
+	var c chain
+	var prev *node
+	for pNode := d.rnode; pNode != nil; {
+		next := pNode.next
+		pNode.next = nil
+		if pNode.len() == 0 {
+			freeNode(pNode)
+			pNode = next
+			continue
+		}
+		if prev == nil {
+			c.head = pNode
+		} else {
+			prev.next = pNode
+		}
+		prev, c.tail = pNode, pNode
+		c.dataLen += pNode.len()
+		pNode = next
+	}
+	d.reset()
+	d.rwUnlock()
+	bufferPool.Put(d)
+
+	if c.dataLen == 0 {
+		return 0
+	}
+	b.addChain(&c)
+	b.written += uint64(c.dataLen)
+	return c.dataLen
+}
+
 // ReadBlock reads one block from buffer(reject 0 len block), and advances the buffer.
 func (b *Buffer) ReadBlock() ([]byte, error) {
 	b.rlock.Lock()
@@ -515,6 +703,31 @@ func (b *Buffer) WritevLimited(safeWrite bool, limit int, bs ...[]byte) (int, er
 	return b.linkFromLocked(bs...), nil
 }
 
+// WritevLimitedWithOffset is the same as WritevLimited, and it also returns the offset of
+// the end of the written data, which is the total number of bytes ever written by Write/Writev/Join.
+func (b *Buffer) WritevLimitedWithOffset(safeWrite bool, limit int, bs ...[]byte) (int, uint64, error) {
+	if b == nil {
+		return 0, 0, ErrInvalidParam
+	}
+	length := byteSlicesLen(bs...)
+	b.wlock.Lock()
+	defer b.wlock.Unlock()
+	if limit > 0 && length > limit-b.LenRead() {
+		return 0, b.written, ErrBufferFull
+	}
+	if length == 0 {
+		return 0, b.written, nil
+	}
+	var n int
+	if safeWrite {
+		n = b.copyFromLocked(length, bs...)
+	} else {
+		n = b.linkFromLocked(bs...)
+	}
+	// b.written is read only after the write updates it.
+	return n, b.written, nil
+}
+
 func (b *Buffer) copyFrom(bs ...[]byte) int {
 	b.wlock.Lock()
 	defer b.wlock.Unlock()
@@ -528,6 +741,7 @@ func (b *Buffer) copyFromLocked(length int, bs ...[]byte) int {
 	for i := range bs {
 		copied += b.copyFromSingleByteSlice(bs[i])
 	}
+	b.written += uint64(copied)
 	return copied
 }
 
@@ -556,6 +770,7 @@ func (b *Buffer) linkFromLocked(bs ...[]byte) int {
 	if linked < total {
 		length += b.linkWithNewNodes(bs[linked:]...)
 	}
+	b.written += uint64(length)
 	return length
 }
 
@@ -759,6 +974,7 @@ func (b *Buffer) addNode() {
 func (b *Buffer) reset() {
 	b.rlen.Store(0)
 	b.wlen.Store(0)
+	b.written = 0
 	b.head, b.tail, b.rnode, b.wnode = nil, nil, nil, nil
 }
 
@@ -133,6 +133,36 @@ func TestBuffer_WritevLimited(t *testing.T) {
 	})
 }
 
+func TestBuffer_WritevLimitedWithOffset(t *testing.T) {
+	b := New()
+	defer Free(b)
+
+	n, offset, err := b.WritevLimitedWithOffset(true, 0, []byte("abc"), []byte("def"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, uint64(6), offset)
+
+	n, offset, err = b.WritevLimitedWithOffset(false, 8, []byte("ghi"))
+	assert.True(t, errors.Is(err, ErrBufferFull))
+	assert.Zero(t, n)
+	assert.Equal(t, uint64(6), offset)
+
+	// The offset keeps growing after the data is read.
+	assert.NoError(t, b.Skip(6))
+	b.Release()
+	d := New()
+	d.Write(true, []byte("gh"))
+	assert.Equal(t, 2, b.Join(d))
+	n, offset, err = b.WritevLimitedWithOffset(false, 8, []byte("ijk"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, uint64(11), offset)
+
+	var nb *Buffer
+	_, _, err = nb.WritevLimitedWithOffset(true, 0, []byte("a"))
+	assert.True(t, errors.Is(err, ErrInvalidParam))
+}
+
 func TestBuffer_Write(t *testing.T) {
 	// 无参数
 	b := New()
@@ -356,6 +386,133 @@ func TestBuffer_PeekBlocks(t *testing.T) {
 	assert.Equal(t, s3, data[2])
 }
 
+func TestBuffer_PeekBlocksN(t *testing.T) {
+	b := New()
+	defer Free(b)
+	b.Writev(false, []byte{1, 2, 3}, []byte{4, 5, 6})
+	blocks, err := b.PeekBlocksN(4)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{{1, 2, 3}, {4}}, blocks)
+	assert.Equal(t, 6, b.LenRead())
+	_, err = b.PeekBlocksN(7)
+	assert.Equal(t, ErrNoEnoughData, err)
+	_, err = b.PeekBlocksN(-1)
+	assert.Equal(t, ErrInvalidParam, err)
+}
+
+func TestBuffer_NextBlocksN(t *testing.T) {
+	b := New()
+	defer Free(b)
+	b.Writev(false, []byte{1, 2, 3}, []byte{4, 5, 6})
+	blocks, err := b.NextBlocksN(4)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{{1, 2, 3}, {4}}, blocks)
+	assert.Equal(t, 2, b.LenRead())
+	blocks, err = b.NextBlocksN(2)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{{5, 6}}, blocks)
+	_, err = b.NextBlocksN(1)
+	assert.Equal(t, ErrNoEnoughData, err)
+	_, err = b.NextBlocksN(-1)
+	assert.Equal(t, ErrInvalidParam, err)
+}
+
+func TestBuffer_DetachSharesBlocks(t *testing.T) {
+	s := "0123456789abcdefghij0123"
+	saveNodeSize := blockSize
+	defer func() {
+		blockSize = saveNodeSize
+	}()
+	blockSize = 10
+	b := New()
+	defer Free(b)
+	b.SetAutoNodeBlockSize(false)
+	ioData := iovec.NewIOData()
+	ioData.Reset()
+	assert.Nil(t, b.Fill(newReader(s), 24, &ioData))
+	peeked, err := b.PeekBlocksN(24)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(peeked))
+
+	// The first two blocks are handed over, the third one is being written, so it is copied.
+	d, err := b.Detach(22)
+	assert.Nil(t, err)
+	detached, err := d.PeekBlocksN(22)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(detached))
+	assert.True(t, &peeked[0][0] == &detached[0][0])
+	assert.True(t, &peeked[1][0] == &detached[1][0])
+	assert.False(t, &peeked[2][0] == &detached[2][0])
+	// The handed over blocks are owned by d, the earlier peeked slices of them
+	// must not be used once d is freed, even if b is not released.
+	Free(d)
+	b.Release()
+	p, err := b.Next(2)
+	assert.Nil(t, err)
+	assert.Equal(t, s[22:], string(p))
+}
+
+func TestBuffer_DetachAndJoin(t *testing.T) {
+	s := "0123456789a1b2c3d4e5f6g7h8i9j1k2l3m4n5o6p7q8"
+	saveNodeSize := blockSize
+	defer func() {
+		blockSize = saveNodeSize
+	}()
+	blockSize = 10
+	b := New()
+	defer Free(b)
+	b.SetAutoNodeBlockSize(false)
+	ioData := iovec.NewIOData()
+	ioData.Reset()
+	// Three nodes of 10 bytes are filled.
+	assert.Nil(t, b.Fill(newReader(s), 25, &ioData))
+	assert.Equal(t, 30, b.LenRead())
+
+	assert.Nil(t, b.Skip(2))
+	// The rest of the first node is handed over, the part of the second one is copied.
+	d, err := b.Detach(15)
+	assert.Nil(t, err)
+	assert.Equal(t, 15, d.LenRead())
+	assert.Equal(t, 13, b.LenRead())
+	p, err := d.Peek(15)
+	assert.Nil(t, err)
+	assert.Equal(t, s[2:17], string(p))
+	// The rest of the buffer is not affected by the detached data.
+	b.Release()
+	p, err = b.Next(13)
+	assert.Nil(t, err)
+	assert.Equal(t, s[17:30], string(p))
+	b.Release()
+
+	out := New()
+	defer Free(out)
+	out.Write(false, []byte("head"))
+	assert.Equal(t, 15, out.Join(d))
+	assert.Equal(t, 19, out.LenRead())
+	p, err = out.Peek(19)
+	assert.Nil(t, err)
+	assert.Equal(t, "head"+s[2:17], string(p))
+
+	limited := New()
+	defer Free(limited)
+	d, err = b.Detach(0)
+	assert.Nil(t, err)
+	d.Write(false, []byte("tail"))
+	_, err = limited.JoinLimited(d, 3)
+	assert.Equal(t, ErrBufferFull, err)
+	n, err := limited.JoinLimited(d, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+
+	_, err = b.Detach(1)
+	assert.Equal(t, ErrNoEnoughData, err)
+	_, err = b.Detach(-1)
+	assert.Equal(t, ErrInvalidParam, err)
+	empty, err := b.Detach(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, out.Join(empty))
+}
+
 func TestBuffer_ReadBlock(t *testing.T) {
 	b := New()
 	defer Free(b)
@@ -137,6 +137,28 @@ func BuildMMsg(m *MMsghdr, name, buf []byte) {
 	}
 	m.Hdr.Name = (*byte)(unsafe.Pointer(&name[0]))
 	m.Hdr.Namelen = uint32(len(name))
+	SetMMsgControl(m, nil)
+}
+
+// BuildMMsgv fills MMsghdr with name and the buffers described by iovs.
+func BuildMMsgv(m *MMsghdr, name []byte, iovs []unix.Iovec) {
+	m.Hdr.Iov = &iovs[0]
+	m.Hdr.SetIovlen(len(iovs))
+	m.Hdr.Name = (*byte)(unsafe.Pointer(&name[0]))
+	m.Hdr.Namelen = uint32(len(name))
+	SetMMsgControl(m, nil)
+}
+
+// SetMMsgControl sets the ancillary data buffer of MMsghdr, nil control clears it.
+func SetMMsgControl(m *MMsghdr, control []byte) {
+	m.Hdr.Flags = 0
+	if len(control) == 0 {
+		m.Hdr.Control = nil
+		m.Hdr.SetControllen(0)
+		return
+	}
+	m.Hdr.Control = (*byte)(unsafe.Pointer(&control[0]))
+	m.Hdr.SetControllen(len(control))
 }
 
 // PutMMsghdrs release a []mmsghdr.
@@ -174,6 +196,19 @@ func BuildMsg(m *Msghdr, name, buf []byte) {
 	}
 	m.Name = (*byte)(unsafe.Pointer(&name[0]))
 	m.Namelen = uint32(len(name))
+	SetMsgControl(m, nil)
+}
+
+// SetMsgControl sets the ancillary data buffer of Msghdr, nil control clears it.
+func SetMsgControl(m *Msghdr, control []byte) {
+	m.Flags = 0
+	if len(control) == 0 {
+		m.Control = nil
+		(*unix.Msghdr)(m).SetControllen(0)
+		return
+	}
+	m.Control = (*byte)(unsafe.Pointer(&control[0]))
+	(*unix.Msghdr)(m).SetControllen(len(control))
 }
 
 // PutMsghdr release a Msghdr.
@@ -17,6 +17,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 	"trpc.group/trpc-go/tnet/internal/cache/systype"
 )
 
@@ -58,6 +59,21 @@ func TestBuildMMsg(t *testing.T) {
 	assert.NotNil(t, m.Hdr.Iov)
 }
 
+func TestBuildMMsgv(t *testing.T) {
+	m := systype.MMsghdr{}
+	systype.SetMMsgControl(&m, []byte("control"))
+	assert.NotNil(t, m.Hdr.Control)
+	assert.EqualValues(t, len("control"), m.Hdr.Controllen)
+
+	buf := []byte("buffer")
+	iovs := []unix.Iovec{{Base: &buf[0]}, {Base: &buf[3]}}
+	systype.BuildMMsgv(&m, []byte("name"), iovs)
+	assert.Equal(t, &iovs[0], m.Hdr.Iov)
+	assert.EqualValues(t, 2, m.Hdr.Iovlen)
+	assert.Nil(t, m.Hdr.Control)
+	assert.EqualValues(t, 0, m.Hdr.Controllen)
+}
+
 func TestBuildMsg(t *testing.T) {
 	m := systype.Msghdr{}
 	systype.BuildMsg(&m, []byte("name"), []byte("buffer"))
@@ -300,6 +300,22 @@ func AddrToSockAddr(laddr net.Addr, raddr net.Addr) (unix.Sockaddr, error) {
 	}
 }
 
+// TCPAddrToSockaddr converts tcp addr to sockaddr, and returns its address family.
+func TCPAddrToSockaddr(addr *net.TCPAddr) (int, unix.Sockaddr, error) {
+	family := getFamily(addr.IP)
+	sa, err := ipToSockaddr(family, addr.IP, addr.Port, addr.Zone)
+	if err != nil {
+		return 0, nil, err
+	}
+	return family, sa, nil
+}
+
+// TCPAddrToSockaddrOfFamily converts addr to unix.Sockaddr of the given family,
+// a nil or unspecified IP is converted to the wildcard address of the family.
+func TCPAddrToSockaddrOfFamily(family int, addr *net.TCPAddr) (unix.Sockaddr, error) {
+	return ipToSockaddr(family, addr.IP, addr.Port, addr.Zone)
+}
+
 func tcpAddrToSockAddr(laddr net.Addr, raddr net.Addr) (unix.Sockaddr, error) {
 	lTCPAddr, lok := laddr.(*net.TCPAddr)
 	rTCPAddr, rok := raddr.(*net.TCPAddr)
@@ -17,6 +17,7 @@
 package netutil
 
 import (
+	"os"
 	"syscall"
 
 	"golang.org/x/sys/unix"
@@ -57,3 +58,14 @@ func Accept(fd int) (int, unix.Sockaddr, error) {
 	}
 	return ns, sa, nil
 }
+
+// Socket wrapper around the socket system call that marks the returned file
+// descriptor as nonblocking and close-on-exec.
+// Copy from golang source code: net/sock_cloexec.go
+func Socket(family, sotype, proto int) (int, error) {
+	s, err := unix.Socket(family, sotype|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	return s, nil
+}
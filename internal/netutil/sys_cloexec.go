@@ -17,6 +17,7 @@
 package netutil
 
 import (
+	"os"
 	"syscall"
 
 	"golang.org/x/sys/unix"
@@ -40,3 +41,24 @@ func Accept(fd int) (int, unix.Sockaddr, error) {
 	}
 	return ns, sa, nil
 }
+
+// Socket wrapper around the socket system call that marks the returned file
+// descriptor as nonblocking and close-on-exec.
+// Copy from golang source code: net/sys_cloexec.go
+func Socket(family, sotype, proto int) (int, error) {
+	// See ../syscall/exec_unix.go for description of ForkLock.
+	syscall.ForkLock.RLock()
+	s, err := unix.Socket(family, sotype, proto)
+	if err == nil {
+		syscall.CloseOnExec(s)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	if err = syscall.SetNonblock(s, true); err != nil {
+		unix.Close(s)
+		return -1, os.NewSyscallError("setnonblock", err)
+	}
+	return s, nil
+}
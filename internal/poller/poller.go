@@ -41,6 +41,8 @@ func (e Event) String() string {
 		return "ModReadWriteable"
 	case Detach:
 		return "Detach"
+	case WritableOnce:
+		return "WritableOnce"
 	default:
 		return fmt.Sprintf("Event(%d)", e)
 	}
@@ -58,6 +60,9 @@ const (
 	ReadWriteable
 	ModReadWriteable
 	Detach
+	// WritableOnce is the same as Writable, except that the event is reported only once,
+	// and the fd is not monitored any more until it is detached and registered again.
+	WritableOnce
 )
 
 // Poller monitors file descriptor, calls Desc callbacks according to specific events.
@@ -283,6 +283,9 @@ func (ep *epoll) Control(desc *Desc, e Event) (err error) {
 		return ep.interest(fd, evt)
 	case Detach:
 		return ep.remove(fd)
+	case WritableOnce:
+		evt.Events = wflags | unix.EPOLLONESHOT
+		return ep.insert(fd, evt)
 	default:
 		return errors.New("Event not support")
 	}
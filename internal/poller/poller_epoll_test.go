@@ -18,7 +18,9 @@ package poller_test
 
 import (
 	"errors"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -121,6 +123,26 @@ func TestPollDescEvent(t *testing.T) {
 		assert.Nil(t, desc.Control(poller.Writable))
 		assert.Nil(t, desc.Close())
 	})
+	t.Run("WritableOnce", func(t *testing.T) {
+		eventFD, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+		require.Nil(t, err)
+		defer unix.Close(eventFD)
+		var onWrite int32
+		desc := poller.NewDesc()
+		desc.FD = eventFD
+		desc.Data = 1
+		desc.OnWrite = func(interface{}) error {
+			atomic.AddInt32(&onWrite, 1)
+			return nil
+		}
+		assert.Nil(t, desc.PickPoller())
+		assert.Nil(t, desc.Control(poller.WritableOnce))
+		// The eventfd keeps writable, but it is reported only once.
+		time.Sleep(10 * time.Millisecond)
+		assert.EqualValues(t, 1, atomic.LoadInt32(&onWrite))
+		assert.Nil(t, desc.Close())
+		assert.Equal(t, "WritableOnce", poller.WritableOnce.String())
+	})
 	t.Run("ReadWriteable", func(t *testing.T) {
 		eventFD, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
 		require.Nil(t, err)
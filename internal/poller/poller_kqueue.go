@@ -284,6 +284,8 @@ func (k *kqueue) Control(desc *Desc, event Event) (err error) {
 		return k.addReadWrite(desc, 0)
 	case Detach:
 		return k.delete(desc)
+	case WritableOnce:
+		return k.addWrite(desc, unix.EV_ONESHOT)
 	default:
 		return errors.New("Event not support")
 	}
@@ -70,8 +70,10 @@ const (
 const (
 	// TCPOutboundBufferLimitExceeded is the number of times outbound buffer limit was exceeded.
 	TCPOutboundBufferLimitExceeded = Max
+	// HandlerPanics is the number of panics recovered from user handlers and hooks.
+	HandlerPanics = TCPOutboundBufferLimitExceeded + 1
 
-	metricNum = TCPOutboundBufferLimitExceeded + 1
+	metricNum = HandlerPanics + 1
 )
 
 var (
@@ -103,33 +105,46 @@ func GetAll() [Max]uint64 {
 	return m
 }
 
+// extraMetrics holds the metrics defined after Max.
+type extraMetrics [metricNum - Max]uint64
+
+func getExtra() extraMetrics {
+	var e extraMetrics
+	for i := range e {
+		e[i] = metrics[Max+i].Load()
+	}
+	return e
+}
+
 // ShowMetricsOfPeriod shows metric info of duration d from now on.
 // It will block d duration, and then prints metrics info.
 func ShowMetricsOfPeriod(d time.Duration) {
-	old := GetAll()
-	oldOutboundBufferLimitExceeded := Get(TCPOutboundBufferLimitExceeded)
+	old, oldExtra := GetAll(), getExtra()
 	<-time.After(d)
-	new := GetAll()
-	outboundBufferLimitExceeded := Get(TCPOutboundBufferLimitExceeded) - oldOutboundBufferLimitExceeded
+	new, newExtra := GetAll(), getExtra()
 	var m [Max]uint64
 	for i := range m {
 		m[i] = new[i] - old[i]
 	}
-	showAll(m, outboundBufferLimitExceeded)
+	var e extraMetrics
+	for i := range e {
+		e[i] = newExtra[i] - oldExtra[i]
+	}
+	showAll(m, e)
 }
 
 // ShowMetrics shows metric info in console.
 func ShowMetrics() {
-	m := GetAll()
-	showAll(m, Get(TCPOutboundBufferLimitExceeded))
+	showAll(GetAll(), getExtra())
 }
 
-func showAll(m [Max]uint64, outboundBufferLimitExceeded uint64) {
+func showAll(m [Max]uint64, e extraMetrics) {
 	log.Debug("######### tnet metrics (", time.Now().Format("2006-01-02 15:04:05"), ") ###########")
-	showTCPMetrics(m, outboundBufferLimitExceeded)
+	showTCPMetrics(m, e[TCPOutboundBufferLimitExceeded-Max])
 	showUDPMetrics(m)
 	showEpollMetrics(m)
 	log.Debugf("%-59s: %d", "# number of task assigned (doTask)", m[TaskAssigned])
+	log.Debugf("%-59s: %d", "# number of handler panics recovered", e[HandlerPanics-Max])
 }
 
 func showTCPMetrics(m [Max]uint64, outboundBufferLimitExceeded uint64) {
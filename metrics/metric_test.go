@@ -26,7 +26,7 @@ func TestMetrics(t *testing.T) {
 	assert.Equal(t, uint64(1), metrics.Get(metrics.TCPReadvCalls))
 	metrics.Add(metrics.TCPReadvCalls, 1)
 	assert.Equal(t, uint64(2), metrics.Get(metrics.TCPReadvCalls))
-	metrics.Add(metrics.Max+1, 1)
+	metrics.Add(metrics.HandlerPanics+1, 1)
 	metrics.Add(metrics.EpollNoWait, 8)
 	metrics.Add(metrics.EpollWait, 9)
 	metrics.Add(metrics.EpollEvents, 99)
@@ -38,7 +38,7 @@ func TestMetrics(t *testing.T) {
 	metrics.Add(metrics.UDPRecvMMsgCalls, 191)
 	metrics.Add(metrics.UDPSendMMsgCalls, 191)
 	metrics.Add(metrics.UDPRecvMsgCalls, 191)
-	assert.Equal(t, uint64(0), metrics.Get(metrics.Max+1))
+	assert.Equal(t, uint64(0), metrics.Get(metrics.HandlerPanics+1))
 	metrics.ShowMetrics()
 	metrics.ShowMetricsOfPeriod(time.Millisecond)
 }
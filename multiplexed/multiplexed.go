@@ -0,0 +1,341 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+// Package multiplexed provides the client which multiplexes concurrent requests over
+// one tnet.Conn, and dispatches the responses to the requests by request id.
+package multiplexed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+	"trpc.group/trpc-go/tnet"
+	"trpc.group/trpc-go/tnet/internal/asynctimer"
+	"trpc.group/trpc-go/tnet/log"
+)
+
+var (
+	// ErrRequestTimeout is returned when the response isn't received within the request timeout.
+	ErrRequestTimeout = errors.New("multiplexed: request timeout")
+	// ErrDuplicateID is returned when sending a request of which the id is still pending.
+	ErrDuplicateID = errors.New("multiplexed: duplicate request id")
+)
+
+// sweepInterval is the interval of checking the timed out requests, which is the precision of asynctimer.
+const sweepInterval = time.Second
+
+// Codec decodes the responses received by the connection.
+type Codec interface {
+	// Decode decodes the next complete response frame from r, the same as tnet.FrameDecoder.
+	// It returns tnet.EAGAIN when the frame is not complete yet, any other error closes the connection.
+	Decode(r tnet.FrameReader) ([]byte, error)
+
+	// RequestID extracts the request id from the response frame.
+	RequestID(frame []byte) (uint64, error)
+}
+
+type options struct {
+	requestTimeout time.Duration
+	onUnmatched    func(id uint64, rsp []byte)
+}
+
+// Option is the option of Client.
+type Option func(*options)
+
+// WithRequestTimeout sets the default timeout of the requests which are sent with a ctx without
+// deadline. The timed out requests are checked every second, so the precision is 1 second.
+// If d <= 0, which is the default, such requests wait until the response arrives or the connection is closed.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.requestTimeout = d
+	}
+}
+
+// WithOnUnmatched sets the function called with the responses which match no pending request,
+// such as the ones arrive after the request is timed out or canceled, or the messages pushed
+// by the server. rsp is owned by the function. By default, such responses are dropped.
+func WithOnUnmatched(onUnmatched func(id uint64, rsp []byte)) Option {
+	return func(o *options) {
+		o.onUnmatched = onUnmatched
+	}
+}
+
+// Client multiplexes the requests over one tnet.Conn.
+type Client struct {
+	conn   tnet.Conn
+	codec  Codec
+	opts   options
+	lastID atomic.Uint64
+	timer  *asynctimer.Timer
+
+	mu      sync.Mutex
+	pending map[uint64]*Future
+	err     error
+}
+
+// New creates a Client over conn. The TCPHandler and the OnTCPClosed of conn are replaced by
+// the Client to decode the responses, and all the pending requests fail when conn is closed.
+func New(conn tnet.Conn, codec Codec, opt ...Option) (*Client, error) {
+	if conn == nil {
+		return nil, errors.New("multiplexed: conn is nil")
+	}
+	if codec == nil {
+		return nil, errors.New("multiplexed: codec is nil")
+	}
+	c := &Client{
+		conn:    conn,
+		codec:   codec,
+		pending: make(map[uint64]*Future),
+	}
+	for _, o := range opt {
+		o(&c.opts)
+	}
+	if err := conn.SetOnRequest(tnet.NewFramedHandler(codec.Decode, c.onMessage, tnet.FrameInOrder)); err != nil {
+		return nil, err
+	}
+	if err := conn.SetOnClosed(c.onClosed); err != nil {
+		return nil, err
+	}
+	// The close hook is missed if conn is closed before it is set.
+	if !conn.IsActive() {
+		c.onClosed(conn)
+	}
+	return c, nil
+}
+
+// Conn returns the underlying connection.
+func (c *Client) Conn() tnet.Conn {
+	return c.conn
+}
+
+// NewRequestID returns a request id which is unique in the Client.
+func (c *Client) NewRequestID() uint64 {
+	return c.lastID.Inc()
+}
+
+// Pending returns the number of the requests waiting for responses.
+func (c *Client) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// Send registers the request id and then writes the encoded request req, which must carry id
+// so that the response can be matched. The deadline of ctx, or the request timeout if ctx has
+// no deadline, bounds the waiting of the response.
+func (c *Client) Send(ctx context.Context, id uint64, req ...[]byte) (*Future, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f := &Future{id: id, client: c, done: make(chan struct{})}
+	if deadline, ok := ctx.Deadline(); ok {
+		f.deadline = deadline
+	} else if c.opts.requestTimeout > 0 {
+		f.deadline = time.Now().Add(c.opts.requestTimeout)
+	}
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	if _, ok := c.pending[id]; ok {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("%w: %d", ErrDuplicateID, id)
+	}
+	c.pending[id] = f
+	// The timed out requests are swept only if there is any request with deadline.
+	if !f.deadline.IsZero() && c.timer == nil {
+		c.timer = asynctimer.NewTimer(c, onSweepTimer, sweepInterval)
+		if err := asynctimer.Add(c.timer); err != nil {
+			c.timer = nil
+			delete(c.pending, id)
+			c.mu.Unlock()
+			return nil, err
+		}
+	}
+	c.mu.Unlock()
+	// The request is registered before writing, so the response can't arrive before it.
+	if _, err := c.conn.Writev(req...); err != nil {
+		c.remove(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+// Call sends the request and waits for the response.
+func (c *Client) Call(ctx context.Context, id uint64, req ...[]byte) ([]byte, error) {
+	f, err := c.Send(ctx, id, req...)
+	if err != nil {
+		return nil, err
+	}
+	return f.Wait(ctx)
+}
+
+// Close closes the underlying connection, all the pending requests fail.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) onMessage(conn tnet.Conn, frame []byte) error {
+	id, err := c.codec.RequestID(frame)
+	if err != nil {
+		return err
+	}
+	// The frame refers to the connection buffer which is released after onMessage returns.
+	rsp := make([]byte, len(frame))
+	copy(rsp, frame)
+	c.mu.Lock()
+	f, ok := c.pending[id]
+	if ok {
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	if !ok {
+		if c.opts.onUnmatched != nil {
+			c.opts.onUnmatched(id, rsp)
+		} else {
+			log.Debugf("multiplexed: drop the response of unknown request id %d\n", id)
+		}
+		return nil
+	}
+	f.finish(rsp, nil)
+	return nil
+}
+
+func (c *Client) remove(f *Future) {
+	c.mu.Lock()
+	if c.pending[f.id] == f {
+		delete(c.pending, f.id)
+	}
+	c.mu.Unlock()
+}
+
+// onClosed fails all the pending requests when conn is closed.
+func (c *Client) onClosed(conn tnet.Conn) error {
+	var err error = tnet.ErrConnClosed
+	if cc, ok := conn.(tnet.ContextConn); ok {
+		err = fmt.Errorf("%w: %s", tnet.ErrConnClosed, cc.CloseReason())
+	}
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	c.err = err
+	pending := c.pending
+	c.pending = make(map[uint64]*Future)
+	// Stop the timer in the lock, so that it doesn't race with the sweeping.
+	if c.timer != nil {
+		asynctimer.Del(c.timer)
+		c.timer = nil
+	}
+	c.mu.Unlock()
+	for _, f := range pending {
+		f.finish(nil, err)
+	}
+	return nil
+}
+
+func onSweepTimer(data interface{}) {
+	c, ok := data.(*Client)
+	if !ok || c == nil {
+		return
+	}
+	c.sweep()
+}
+
+// sweep fails the timed out requests, the timer is stopped once no request has deadline.
+func (c *Client) sweep() {
+	var expired []*Future
+	now := time.Now()
+	c.mu.Lock()
+	if c.timer == nil {
+		c.mu.Unlock()
+		return
+	}
+	var waiting bool
+	for id, f := range c.pending {
+		if f.deadline.IsZero() {
+			continue
+		}
+		if now.After(f.deadline) {
+			delete(c.pending, id)
+			expired = append(expired, f)
+		} else {
+			waiting = true
+		}
+	}
+	if !waiting || asynctimer.Add(c.timer) != nil {
+		c.timer = nil
+	}
+	c.mu.Unlock()
+	for _, f := range expired {
+		f.finish(nil, ErrRequestTimeout)
+	}
+}
+
+// Future is the pending response of a request.
+type Future struct {
+	id       uint64
+	client   *Client
+	deadline time.Time
+	done     chan struct{}
+	rsp      []byte
+	err      error
+	once     sync.Once
+}
+
+// ID returns the request id.
+func (f *Future) ID() uint64 {
+	return f.id
+}
+
+// Done returns a channel which is closed when the response is received or the request fails.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result returns the response or the error of the request, call it after Done is closed.
+func (f *Future) Result() ([]byte, error) {
+	return f.rsp, f.err
+}
+
+// Wait waits for the response. If ctx is done first, the request is canceled and ctx.Err()
+// is returned, the response arrives later is passed to the unmatched handler.
+func (f *Future) Wait(ctx context.Context) ([]byte, error) {
+	select {
+	case <-f.done:
+		return f.rsp, f.err
+	case <-ctx.Done():
+		f.Cancel()
+		return nil, ctx.Err()
+	}
+}
+
+// Cancel cancels the request, so its id can be reused. The Future fails with context.Canceled
+// if it is not done yet.
+func (f *Future) Cancel() {
+	f.client.remove(f)
+	f.finish(nil, context.Canceled)
+}
+
+func (f *Future) finish(rsp []byte, err error) {
+	f.once.Do(func() {
+		f.rsp, f.err = rsp, err
+		close(f.done)
+	})
+}
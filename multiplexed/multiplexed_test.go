@@ -0,0 +1,205 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package multiplexed_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+	"trpc.group/trpc-go/tnet/multiplexed"
+)
+
+// The frame is a 4 bytes length of the body, and the body is an 8 bytes request id and the payload.
+const headLen = 4
+
+var (
+	noReply = []byte("noreply")
+	slow    = []byte("slow")
+)
+
+func encode(id uint64, payload []byte) []byte {
+	buf := make([]byte, headLen+8+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(8+len(payload)))
+	binary.BigEndian.PutUint64(buf[headLen:], id)
+	copy(buf[headLen+8:], payload)
+	return buf
+}
+
+func decode(r tnet.FrameReader) ([]byte, error) {
+	head, err := r.Peek(headLen)
+	if err != nil {
+		return nil, err
+	}
+	frame, err := r.Next(headLen + int(binary.BigEndian.Uint32(head)))
+	if err != nil {
+		return nil, err
+	}
+	return frame[headLen:], nil
+}
+
+type codec struct{}
+
+func (codec) Decode(r tnet.FrameReader) ([]byte, error) {
+	return decode(r)
+}
+
+func (codec) RequestID(frame []byte) (uint64, error) {
+	if len(frame) < 8 {
+		return 0, errors.New("frame too short")
+	}
+	return binary.BigEndian.Uint64(frame), nil
+}
+
+func payloadOf(rsp []byte) []byte {
+	return rsp[8:]
+}
+
+// startServer starts a server which processes the requests concurrently, and echoes the payload back.
+func startServer(t *testing.T) string {
+	ln, err := tnet.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	s, err := tnet.NewFramedService(ln, decode, func(conn tnet.Conn, frame []byte) error {
+		id, payload := binary.BigEndian.Uint64(frame), frame[8:]
+		switch {
+		case bytes.Equal(payload, noReply):
+			return nil
+		case bytes.Equal(payload, slow):
+			time.Sleep(100 * time.Millisecond)
+		}
+		_, err := conn.Write(encode(id, payload))
+		return err
+	}, tnet.WithFrameConcurrency(tnet.FrameParallel))
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Serve(ctx)
+	return ln.Addr().String()
+}
+
+func newClient(t *testing.T, addr string, opt ...multiplexed.Option) *multiplexed.Client {
+	conn, err := tnet.DialTCP("tcp", addr, time.Second)
+	require.Nil(t, err)
+	c, err := multiplexed.New(conn, codec{}, opt...)
+	require.Nil(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClient_Call(t *testing.T) {
+	c := newClient(t, startServer(t))
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := c.NewRequestID()
+			payload := []byte(fmt.Sprintf("request %d", id))
+			rsp, err := c.Call(context.Background(), id, encode(id, payload))
+			assert.Nil(t, err)
+			assert.Equal(t, payload, payloadOf(rsp))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 0, c.Pending())
+
+	_, err := multiplexed.New(nil, codec{})
+	assert.NotNil(t, err)
+}
+
+func TestClient_Timeout(t *testing.T) {
+	t.Parallel()
+	unmatched := make(chan uint64, 1)
+	c := newClient(t, startServer(t),
+		multiplexed.WithRequestTimeout(time.Second),
+		multiplexed.WithOnUnmatched(func(id uint64, rsp []byte) {
+			assert.Equal(t, slow, payloadOf(rsp))
+			unmatched <- id
+		}))
+
+	// The deadline of ctx is earlier than the response.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	id := c.NewRequestID()
+	_, err := c.Call(ctx, id, encode(id, slow))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	select {
+	case got := <-unmatched:
+		assert.Equal(t, id, got)
+	case <-time.After(time.Second):
+		t.Fatal("the late response is not passed to the unmatched handler")
+	}
+
+	// The orphaned request is cleaned up by the request timeout.
+	id = c.NewRequestID()
+	f, err := c.Send(context.Background(), id, encode(id, noReply))
+	require.Nil(t, err)
+	assert.Equal(t, id, f.ID())
+	assert.Equal(t, 1, c.Pending())
+	select {
+	case <-f.Done():
+		_, err := f.Result()
+		assert.Equal(t, multiplexed.ErrRequestTimeout, err)
+	case <-time.After(4 * time.Second):
+		t.Fatal("the request is not timed out")
+	}
+	assert.Equal(t, 0, c.Pending())
+}
+
+func TestClient_Close(t *testing.T) {
+	c := newClient(t, startServer(t))
+	id := c.NewRequestID()
+	f, err := c.Send(context.Background(), id, encode(id, noReply))
+	require.Nil(t, err)
+	_, err = c.Send(context.Background(), id, encode(id, noReply))
+	assert.True(t, errors.Is(err, multiplexed.ErrDuplicateID))
+
+	// The id can be reused after the request is canceled.
+	otherID := c.NewRequestID()
+	other, err := c.Send(context.Background(), otherID, encode(otherID, noReply))
+	require.Nil(t, err)
+	other.Cancel()
+	_, err = other.Result()
+	assert.Equal(t, context.Canceled, err)
+	other, err = c.Send(context.Background(), otherID, encode(otherID, noReply))
+	require.Nil(t, err)
+
+	require.Nil(t, c.Close())
+	select {
+	case <-f.Done():
+		_, err := f.Result()
+		assert.True(t, errors.Is(err, tnet.ErrConnClosed))
+	case <-time.After(time.Second):
+		t.Fatal("the pending request doesn't fail when the connection is closed")
+	}
+	_, err = c.Send(context.Background(), c.NewRequestID(), encode(id, noReply))
+	assert.True(t, errors.Is(err, tnet.ErrConnClosed))
+	assert.Equal(t, 0, c.Pending())
+}
+
+func TestClient_ClosedConn(t *testing.T) {
+	conn, err := tnet.DialTCP("tcp", startServer(t), time.Second)
+	require.Nil(t, err)
+	require.Nil(t, conn.Close())
+	_, err = multiplexed.New(conn, codec{})
+	assert.True(t, errors.Is(err, tnet.ErrConnClosed))
+}
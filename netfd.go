@@ -57,6 +57,17 @@ type netFD struct {
 	locker                    sync.Mutex
 	udpBufferSize             int
 	exactUDPBufferSizeEnabled bool
+	// udpGSO and udpGRO mark whether UDP generic segmentation offload and generic receive
+	// offload are in use, udpGSO is turned off at runtime if the kernel rejects it.
+	udpGSO atomic.Bool
+	udpGRO atomic.Bool
+	// udpPktInfo, udpTimestamp and udpRecvTOS mark whether the local address and interface,
+	// the kernel receive timestamp and the TOS byte of received packets are reported.
+	// They may be changed by SetUDPOptions while receiving.
+	udpPktInfo   atomic.Bool
+	udpTimestamp atomic.Bool
+	udpRecvTOS   atomic.Bool
+	stats        connStats
 }
 
 var listenerPollMgr *poller.PollMgr
@@ -177,6 +188,10 @@ func (nfd *netFD) Readv(ivs []unix.Iovec) (int, error) {
 		return int(r), unix.Errno(e)
 	}
 	metrics.Add(metrics.TCPReadvBytes, uint64(r))
+	// The read of 0 bytes is EOF, which is not counted.
+	if r > 0 {
+		nfd.stats.onRead(int(r))
+	}
 	return int(r), nil
 }
 
@@ -192,6 +207,7 @@ func (nfd *netFD) Writev(ivs []unix.Iovec) (int, error) {
 		return int(r), unix.Errno(e)
 	}
 	metrics.Add(metrics.TCPWritevBlocks, uint64(len(ivs)))
+	nfd.stats.onWrite(int(r))
 	return int(r), nil
 }
 
@@ -206,6 +222,11 @@ var (
 
 // WriteTo writes a packet with payload p to addr.
 func (nfd *netFD) WriteTo(data []byte, addr net.Addr) (int, error) {
+	return nfd.WriteToFrom(data, addr, nil)
+}
+
+// WriteToFrom writes a packet with payload p to addr, using src as the source address if not nil.
+func (nfd *netFD) WriteToFrom(data []byte, addr net.Addr, src net.IP) (int, error) {
 	if len(data) == 0 {
 		return 0, nil
 	}
@@ -220,5 +241,18 @@ func (nfd *netFD) WriteTo(data []byte, addr net.Addr) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	return len(data), unix.Sendto(nfd.FD(), data, 0, sa)
+	if src == nil {
+		err = unix.Sendto(nfd.FD(), data, 0, sa)
+	} else {
+		var oob []byte
+		if oob, err = appendPktInfo(nil, src); err != nil {
+			return 0, err
+		}
+		_, err = unix.SendmsgN(nfd.FD(), data, oob, sa, 0)
+	}
+	if err != nil {
+		return len(data), err
+	}
+	nfd.stats.onWrite(len(data))
+	return len(data), nil
 }
@@ -19,6 +19,7 @@ package tnet
 import (
 	"errors"
 	"fmt"
+	"net"
 
 	"golang.org/x/sys/unix"
 	"trpc.group/trpc-go/tnet/internal/buffer"
@@ -33,8 +34,9 @@ func (nfd *netFD) FillToBuffer(b *buffer.Buffer) error {
 	if err != nil {
 		return fmt.Errorf("get udp buffer size: %w", err)
 	}
-	block := mcache.Malloc(udpBufferSize + netutil.SockaddrSize)
-	n, sa, err := unix.Recvfrom(nfd.fd, block[netutil.SockaddrSize:], 0)
+	block := mcache.Malloc(udpBufferSize + udpHeaderSize)
+	initUDPHeader(block, false)
+	n, sa, err := unix.Recvfrom(nfd.fd, block[udpHeaderSize:], 0)
 	if err != nil {
 		if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
 			return nil
@@ -44,7 +46,8 @@ func (nfd *netFD) FillToBuffer(b *buffer.Buffer) error {
 	if err := netutil.UnixSockaddrToSockaddrSlice(sa, block[:netutil.SockaddrSize]); err != nil {
 		return err
 	}
-	b.Write(false, block[:netutil.SockaddrSize+n])
+	nfd.stats.onRead(n)
+	b.Write(false, block[:udpHeaderSize+n])
 	return nil
 }
 
@@ -76,7 +79,8 @@ func (nfd *netFD) SendPackets(b *buffer.Buffer) error {
 	if err != nil {
 		return err
 	}
-	nfd.WriteTo(buf, addr)
+	src, _ := getUDPMetaAddr(block[0])
+	nfd.WriteToFrom(buf, addr, src)
 	// Skip the n (here n == 1) block to prevent the same data from being written multiple times.
 	if err := b.SkipBlocks(n); err != nil {
 		return err
@@ -84,3 +88,53 @@ func (nfd *netFD) SendPackets(b *buffer.Buffer) error {
 	b.Release()
 	return nil
 }
+
+// setUDPGSO returns an error if enabled, as UDP generic segmentation offload is linux only.
+func (nfd *netFD) setUDPGSO(enabled bool) error {
+	if enabled {
+		return errors.New("udp gso is not supported on this platform")
+	}
+	return nil
+}
+
+// setUDPGRO returns an error if enabled, as UDP generic receive offload is linux only.
+func (nfd *netFD) setUDPGRO(enabled bool) error {
+	if enabled {
+		return errors.New("udp gro is not supported on this platform")
+	}
+	return nil
+}
+
+// setUDPPktInfo returns an error if enabled, as reporting the packet info is linux only.
+func (nfd *netFD) setUDPPktInfo(enabled bool) error {
+	if enabled {
+		return errors.New("udp packet info is not supported on this platform")
+	}
+	return nil
+}
+
+// setUDPTimestamp returns an error if enabled, as reporting the receive timestamp is linux only.
+func (nfd *netFD) setUDPTimestamp(enabled bool) error {
+	if enabled {
+		return errors.New("udp timestamp is not supported on this platform")
+	}
+	return nil
+}
+
+// setUDPRecvTOS returns an error if enabled, as reporting the TOS byte is linux only.
+func (nfd *netFD) setUDPRecvTOS(enabled bool) error {
+	if enabled {
+		return errors.New("udp receiving tos is not supported on this platform")
+	}
+	return nil
+}
+
+// setUDPTOS returns an error, as setting the TOS byte of UDP packets is linux only.
+func (nfd *netFD) setUDPTOS(tos int) error {
+	return errors.New("udp tos is not supported on this platform")
+}
+
+// appendPktInfo returns an error, as setting the source address is linux only.
+func appendPktInfo(control []byte, src net.IP) ([]byte, error) {
+	return nil, errors.New("udp source address is not supported on this platform")
+}
@@ -17,8 +17,12 @@
 package tnet
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"net"
+	"os"
+	"sync"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -46,11 +50,30 @@ func (nfd *netFD) FillToBuffer(b *buffer.Buffer) error {
 	if w != nil {
 		defer systype.PutIOData(w)
 	}
-	n := nfd.udpBufferSize + netutil.SockaddrSize
+	meta := nfd.recvMetaEnabled()
+	header := udpHeaderSize
+	if meta {
+		header = udpMetaHeaderSize
+	}
+	gro := nfd.udpGRO.Load()
+	n := nfd.udpBufferSize + header
+	if gro && nfd.udpBufferSize < defaultUDPBufferSize {
+		// The coalesced datagrams are truncated if the buffer is not large enough.
+		n = defaultUDPBufferSize + header
+	}
 	for i := 0; i < udpPacketNum; i++ {
 		bufs[i] = mcache.Malloc(n)
+		initUDPHeader(bufs[i], meta)
 	}
 	buildMMsgs(mmsgs, bufs)
+	var control []byte
+	if gro || meta {
+		control = mcache.Malloc(udpPacketNum * recvControlSize)
+		defer mcache.Free(control)
+		for i := range mmsgs {
+			systype.SetMMsgControl(&mmsgs[i], control[i*recvControlSize:(i+1)*recvControlSize])
+		}
+	}
 
 	// Call SYS_RECVMMSG to receive data from fd.
 	r, err := nfd.syscallMMsg(unix.SYS_RECVMMSG, mmsgs)
@@ -59,19 +82,34 @@ func (nfd *netFD) FillToBuffer(b *buffer.Buffer) error {
 		metrics.Add(metrics.UDPRecvMMsgFails, 1)
 		return err
 	}
-	metrics.Add(metrics.UDPRecvMMsgPackets, uint64(r))
 
 	// The actual received data may be less than the pre-allocated
 	// space, adjust the length of the bufs to the actual received
 	// data, and then write to the buffer.
+	var received int
 	for i := 0; i < r; i++ {
 		l := mmsgs[i].Len
-		bufs[i] = bufs[i][:netutil.SockaddrSize+l]
+		bufs[i] = bufs[i][:header+int(l)]
+		received += int(l)
 	}
+	nfd.stats.onRead(received)
 	for i := r; i < udpPacketNum; i++ {
 		mcache.Free(bufs[i])
 	}
 	bufs = bufs[:r]
+	if control != nil {
+		packets, pw := systype.GetIOData(systype.MaxLen)
+		if pw != nil {
+			defer systype.PutIOData(pw)
+		}
+		packets = packets[:0]
+		for i := 0; i < r; i++ {
+			c := control[i*recvControlSize : i*recvControlSize+int(mmsgs[i].Hdr.Controllen)]
+			packets = appendRecvBlock(packets, bufs[i], c, mmsgs[i].Hdr.Flags, gro)
+		}
+		bufs = packets
+	}
+	metrics.Add(metrics.UDPRecvMMsgPackets, uint64(len(bufs)))
 	b.Writev(false, bufs...)
 	return nil
 }
@@ -91,8 +129,21 @@ func (nfd *netFD) fillToExactBuffer(b *buffer.Buffer) error {
 	}
 
 	// Allocate a buffer of the exact size needed for the UDP packet.
-	buf := mcache.Malloc(udpBufferSize + netutil.SockaddrSize)
+	meta := nfd.recvMetaEnabled()
+	header := udpHeaderSize
+	if meta {
+		header = udpMetaHeaderSize
+	}
+	gro := nfd.udpGRO.Load()
+	buf := mcache.Malloc(udpBufferSize + header)
+	initUDPHeader(buf, meta)
 	buildMsg(msg, buf)
+	var control []byte
+	if gro || meta {
+		control = mcache.Malloc(recvControlSize)
+		defer mcache.Free(control)
+		systype.SetMsgControl(msg, control)
+	}
 
 	// Call SYS_RECVMSG to read the UDP packet.
 	_, err = nfd.syscallMsg(unix.SYS_RECVMSG, msg, 0)
@@ -100,11 +151,22 @@ func (nfd *netFD) fillToExactBuffer(b *buffer.Buffer) error {
 		metrics.Add(metrics.UDPRecvMsgFails, 1)
 		return err
 	}
-	metrics.Add(metrics.UDPRecvMsgPackets, 1)
+	nfd.stats.onRead(udpBufferSize)
 
 	// Write the received data into the buffer.
-	buf = buf[:netutil.SockaddrSize+udpBufferSize]
-	b.Writev(false, buf)
+	buf = buf[:header+udpBufferSize]
+	if control == nil {
+		metrics.Add(metrics.UDPRecvMsgPackets, 1)
+		b.Writev(false, buf)
+		return nil
+	}
+	packets, w := systype.GetIOData(systype.MaxLen)
+	if w != nil {
+		defer systype.PutIOData(w)
+	}
+	packets = appendRecvBlock(packets[:0], buf, control[:msg.Controllen], msg.Flags, gro)
+	metrics.Add(metrics.UDPRecvMsgPackets, uint64(len(packets)))
+	b.Writev(false, packets...)
 	return nil
 }
 
@@ -123,13 +185,39 @@ func (nfd *netFD) sendMMsg(b *buffer.Buffer) error {
 	}
 	mmsgs, bufs = mmsgs[:l], bufs[:l]
 
-	buildMMsgs(mmsgs, bufs)
+	batch := sendBatchPool.Get().(*sendBatch)
+	defer batch.release()
+	return nfd.sendMMsgBatch(b, batch, mmsgs, bufs, nfd.udpGSO.Load())
+}
+
+// sendMMsgBatch sends the packets bufs peeked from b by one sendmmsg, and skips the sent ones in b.
+func (nfd *netFD) sendMMsgBatch(
+	b *buffer.Buffer,
+	batch *sendBatch,
+	mmsgs []systype.MMsghdr,
+	bufs [][]byte,
+	gso bool,
+) error {
+	// segs records the number of packets carried by each message.
+	msgs, segs, err := batch.build(mmsgs, bufs, gso)
+	if err != nil {
+		return err
+	}
 
-	n, err := nfd.syscallMMsg(unix.SYS_SENDMMSG, mmsgs)
+	n, err := nfd.syscallMMsg(unix.SYS_SENDMMSG, msgs)
 	metrics.Add(metrics.UDPSendMMsgCalls, 1)
 	if n > 0 {
-		metrics.Add(metrics.UDPSendMMsgPackets, uint64(n))
-		if skipErr := b.SkipBlocks(n); skipErr != nil {
+		var packets int
+		for _, seg := range segs[:n] {
+			packets += seg
+		}
+		metrics.Add(metrics.UDPSendMMsgPackets, uint64(packets))
+		var sent int
+		for i := 0; i < packets; i++ {
+			sent += len(bufs[i]) - udpHeaderLen(bufs[i])
+		}
+		nfd.stats.onWrite(sent)
+		if skipErr := b.SkipBlocks(packets); skipErr != nil {
 			return skipErr
 		}
 		b.Release()
@@ -139,10 +227,26 @@ func (nfd *netFD) sendMMsg(b *buffer.Buffer) error {
 		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EWOULDBLOCK) {
 			return err
 		}
+		// An error is only reported for the first message of the batch.
+		skip := segs[0]
+		if skip > 1 {
+			if isGSOUnsupported(err) {
+				// The kernel or the device can't segment the message, fall back to
+				// sending the packets one by one, they are retried in the next round.
+				nfd.udpGSO.Store(false)
+				return nil
+			}
+			if errors.Is(err, unix.EINVAL) {
+				// EINVAL is also returned for an oversized segment or a bad destination, which is
+				// scoped to the packets of the message, so they are sent again without segmentation
+				// and GSO is kept for the others.
+				return nfd.sendMMsgBatch(b, batch, mmsgs[:skip], bufs[:skip], false)
+			}
+		}
 		// UDP send errors are scoped to the current datagram. Returning the
 		// error would make the poller detach the shared UDP listener fd.
 		if b.LenRead() != 0 {
-			if skipErr := b.SkipBlocks(1); skipErr != nil {
+			if skipErr := b.SkipBlocks(skip); skipErr != nil {
 				return skipErr
 			}
 			b.Release()
@@ -156,11 +260,12 @@ func buildMMsgs(mmsgs []systype.MMsghdr, bufs [][]byte) error {
 		return errors.New("buffers length is not equal to MMsghdrs length")
 	}
 	for i := range mmsgs {
-		if len(bufs[i]) < netutil.SockaddrSize {
+		header := udpHeaderLen(bufs[i])
+		if len(bufs[i]) < header {
 			return fmt.Errorf("invalid buffer size: buffer length is %d, required minimum is %d",
-				len(bufs[i]), netutil.SockaddrSize)
+				len(bufs[i]), header)
 		}
-		buf := bufs[i][netutil.SockaddrSize:]
+		buf := bufs[i][header:]
 		name := bufs[i][:netutil.SockaddrSize]
 		systype.BuildMMsg(&mmsgs[i], name, buf)
 	}
@@ -168,16 +273,349 @@ func buildMMsgs(mmsgs []systype.MMsghdr, bufs [][]byte) error {
 }
 
 func buildMsg(msg *systype.Msghdr, buf []byte) error {
-	if len(buf) < netutil.SockaddrSize {
+	header := udpHeaderLen(buf)
+	if len(buf) < header {
 		return fmt.Errorf("invalid buffer size: buffer length is %d, required minimum is %d",
-			len(buf), netutil.SockaddrSize)
+			len(buf), header)
 	}
 	name := buf[:netutil.SockaddrSize]
-	buf = buf[netutil.SockaddrSize:]
+	buf = buf[header:]
 	systype.BuildMsg(msg, name, buf)
 	return nil
 }
 
+const (
+	// udpGSOMaxSegments is the maximum number of segments in one GSO message, UDP_MAX_SEGMENTS
+	// of the kernel.
+	udpGSOMaxSegments = 64
+	// udpGSOMaxPayload is the maximum payload of one GSO message, which must fit into
+	// one IPv4 datagram before segmentation.
+	udpGSOMaxPayload = 65507
+)
+
+var (
+	// sendControlSize is the size of control messages of a sent message, which may carry
+	// UDP_SEGMENT with a uint16 and IP_PKTINFO or IPV6_PKTINFO.
+	sendControlSize = unix.CmsgSpace(2) + unix.CmsgSpace(unix.SizeofInet6Pktinfo)
+	// recvControlSize is the size of control messages of a received message, which may carry
+	// UDP_GRO with an int, SO_TIMESTAMPNS with a timespec, IP_PKTINFO, IPV6_PKTINFO, IP_TOS
+	// with a byte and IPV6_TCLASS with an int. Both the IPv4 and the IPv6 ones are reported
+	// for the IPv4 packets received by a dual stack socket.
+	recvControlSize = unix.CmsgSpace(4) + unix.CmsgSpace(int(unsafe.Sizeof(unix.Timespec{}))) +
+		unix.CmsgSpace(unix.SizeofInet4Pktinfo) + unix.CmsgSpace(unix.SizeofInet6Pktinfo) +
+		unix.CmsgSpace(1) + unix.CmsgSpace(4)
+)
+
+// setUDPGSO enables UDP generic segmentation offload when sending batches of packets.
+// An error is returned if the kernel doesn't support it.
+func (nfd *netFD) setUDPGSO(enabled bool) error {
+	if enabled {
+		// UDP_SEGMENT can be read since it was introduced (linux 4.18), use it to probe the support.
+		if _, err := unix.GetsockoptInt(nfd.fd, unix.IPPROTO_UDP, unix.UDP_SEGMENT); err != nil {
+			nfd.udpGSO.Store(false)
+			return os.NewSyscallError("getsockopt", err)
+		}
+	}
+	nfd.udpGSO.Store(enabled)
+	return nil
+}
+
+// setUDPGRO enables UDP generic receive offload, the coalesced datagrams are split into
+// separate packets when filling to buffer. An error is returned if the kernel doesn't support it.
+func (nfd *netFD) setUDPGRO(enabled bool) error {
+	if err := unix.SetsockoptInt(nfd.fd, unix.IPPROTO_UDP, unix.UDP_GRO, boolToInt(enabled)); err != nil {
+		nfd.udpGRO.Store(false)
+		if !enabled {
+			return nil
+		}
+		return os.NewSyscallError("setsockopt", err)
+	}
+	nfd.udpGRO.Store(enabled)
+	return nil
+}
+
+// setUDPPktInfo enables IP_PKTINFO and IPV6_RECVPKTINFO, so that the local destination address
+// and the interface index of received packets are reported.
+func (nfd *netFD) setUDPPktInfo(enabled bool) error {
+	if err := nfd.setIPSockoptInt(unix.IP_PKTINFO, unix.IPV6_RECVPKTINFO, boolToInt(enabled)); err != nil {
+		return err
+	}
+	nfd.udpPktInfo.Store(enabled)
+	return nil
+}
+
+// setUDPTimestamp enables SO_TIMESTAMPNS, so that the kernel receive timestamps of packets
+// are reported.
+func (nfd *netFD) setUDPTimestamp(enabled bool) error {
+	if err := unix.SetsockoptInt(nfd.fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, boolToInt(enabled)); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+	nfd.udpTimestamp.Store(enabled)
+	return nil
+}
+
+// setUDPRecvTOS enables IP_RECVTOS and IPV6_RECVTCLASS, so that the TOS byte (traffic class
+// for IPv6) of received packets is reported.
+func (nfd *netFD) setUDPRecvTOS(enabled bool) error {
+	if err := nfd.setIPSockoptInt(unix.IP_RECVTOS, unix.IPV6_RECVTCLASS, boolToInt(enabled)); err != nil {
+		return err
+	}
+	nfd.udpRecvTOS.Store(enabled)
+	return nil
+}
+
+// setUDPTOS sets the TOS byte (traffic class for IPv6) of sent packets, which includes the ECN bits.
+func (nfd *netFD) setUDPTOS(tos int) error {
+	return nfd.setIPSockoptInt(unix.IP_TOS, unix.IPV6_TCLASS, tos)
+}
+
+// setIPSockoptInt sets the IPv4 option opt4 on an IPv4 socket, or sets the IPv6 option opt6
+// on an IPv6 socket, where opt4 is set as well for the IPv4 packets of dual stack.
+func (nfd *netFD) setIPSockoptInt(opt4, opt6, v int) error {
+	sa, err := unix.Getsockname(nfd.fd)
+	if err != nil {
+		return os.NewSyscallError("getsockname", err)
+	}
+	if _, ok := sa.(*unix.SockaddrInet6); ok {
+		if err := unix.SetsockoptInt(nfd.fd, unix.IPPROTO_IPV6, opt6, v); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+		// Ignore the error for IPv6 only socket.
+		_ = unix.SetsockoptInt(nfd.fd, unix.IPPROTO_IP, opt4, v)
+		return nil
+	}
+	if err := unix.SetsockoptInt(nfd.fd, unix.IPPROTO_IP, opt4, v); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+	return nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// recvMetaEnabled reports whether any packet info saved in the meta is reported with received packets.
+func (nfd *netFD) recvMetaEnabled() bool {
+	return nfd.udpPktInfo.Load() || nfd.udpTimestamp.Load() || nfd.udpRecvTOS.Load()
+}
+
+// isGSOUnsupported reports whether err means that GSO can't be used for the socket.
+func isGSOUnsupported(err error) bool {
+	// EIO is returned if the device doesn't support checksum offload. EINVAL isn't taken as
+	// unsupported, as it is also returned for the errors of a single message.
+	return errors.Is(err, unix.EIO) || errors.Is(err, unix.ENOPROTOOPT)
+}
+
+// putCmsg writes the header of a control message with dataLen bytes of data to c,
+// and returns the data of the control message.
+func putCmsg(c []byte, level, typ int32, dataLen int) []byte {
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&c[0]))
+	h.Level = level
+	h.Type = typ
+	h.SetLen(unix.CmsgLen(dataLen))
+	data := c[unix.CmsgLen(0):unix.CmsgLen(dataLen)]
+	for i := range data {
+		data[i] = 0
+	}
+	return data
+}
+
+// appendPktInfo appends the IP_PKTINFO or IPV6_PKTINFO control message which sets the source
+// address to src, and returns the extended control.
+func appendPktInfo(control []byte, src net.IP) ([]byte, error) {
+	n := len(control)
+	if ip4 := src.To4(); ip4 != nil {
+		control = growControl(control, unix.CmsgSpace(unix.SizeofInet4Pktinfo))
+		data := putCmsg(control[n:], unix.IPPROTO_IP, unix.IP_PKTINFO, unix.SizeofInet4Pktinfo)
+		info := (*unix.Inet4Pktinfo)(unsafe.Pointer(&data[0]))
+		copy(info.Spec_dst[:], ip4)
+		return control, nil
+	}
+	if ip16 := src.To16(); ip16 != nil {
+		control = growControl(control, unix.CmsgSpace(unix.SizeofInet6Pktinfo))
+		data := putCmsg(control[n:], unix.IPPROTO_IPV6, unix.IPV6_PKTINFO, unix.SizeofInet6Pktinfo)
+		info := (*unix.Inet6Pktinfo)(unsafe.Pointer(&data[0]))
+		copy(info.Addr[:], ip16)
+		return control, nil
+	}
+	return nil, fmt.Errorf("invalid source address %v", src)
+}
+
+// growControl extends the length of control by n, it reallocates control if the capacity
+// is not enough.
+func growControl(control []byte, n int) []byte {
+	if cap(control)-len(control) >= n {
+		return control[:len(control)+n]
+	}
+	c := make([]byte, len(control)+n)
+	copy(c, control)
+	return c
+}
+
+// sendBatch holds the iovecs and control messages used by a sendmmsg.
+type sendBatch struct {
+	iovs    []unix.Iovec
+	segs    []int
+	control []byte
+}
+
+var sendBatchPool = sync.Pool{
+	New: func() interface{} {
+		return &sendBatch{
+			iovs:    make([]unix.Iovec, systype.MaxLen),
+			segs:    make([]int, systype.MaxLen),
+			control: make([]byte, systype.MaxLen*sendControlSize),
+		}
+	},
+}
+
+// build fills mmsgs with bufs. The source address in the meta of block is set by a control
+// message. If gso is true, the consecutive packets with the same header and equal size are
+// coalesced into one message which is segmented by the kernel, the last packet of a message
+// may be shorter. It returns the used mmsgs and the number of packets carried by each message.
+func (g *sendBatch) build(mmsgs []systype.MMsghdr, bufs [][]byte, gso bool) ([]systype.MMsghdr, []int, error) {
+	if len(mmsgs) != len(bufs) {
+		return nil, nil, errors.New("buffers length is not equal to MMsghdrs length")
+	}
+	if len(bufs) > len(g.iovs) {
+		g.iovs = make([]unix.Iovec, len(bufs))
+		g.segs = make([]int, len(bufs))
+		g.control = make([]byte, len(bufs)*sendControlSize)
+	}
+	for i, buf := range bufs {
+		header := udpHeaderLen(buf)
+		if len(buf) <= header {
+			return nil, nil, fmt.Errorf("invalid buffer size: buffer length is %d, required minimum is %d",
+				len(buf), header+1)
+		}
+		data := buf[header:]
+		g.iovs[i] = unix.Iovec{Base: &data[0]}
+		g.iovs[i].SetLen(len(data))
+	}
+	var (
+		n   int
+		err error
+	)
+	for i := 0; i < len(bufs); n++ {
+		header := bufs[i][:udpHeaderLen(bufs[i])]
+		size := len(bufs[i]) - len(header)
+		j, total := i+1, size
+		for gso && j < len(bufs) && j-i < udpGSOMaxSegments {
+			l := len(bufs[j]) - len(header)
+			if l > size || total+l > udpGSOMaxPayload || !bytes.Equal(bufs[j][:udpHeaderLen(bufs[j])], header) {
+				break
+			}
+			total += l
+			j++
+			if l < size {
+				break
+			}
+		}
+		systype.BuildMMsgv(&mmsgs[n], header[:netutil.SockaddrSize], g.iovs[i:j])
+		c := g.control[n*sendControlSize : n*sendControlSize : (n+1)*sendControlSize]
+		if j-i > 1 {
+			c = c[:unix.CmsgSpace(2)]
+			*(*uint16)(unsafe.Pointer(&putCmsg(c, unix.IPPROTO_UDP, unix.UDP_SEGMENT, 2)[0])) = uint16(size)
+		}
+		if src, _ := getUDPMetaAddr(header); src != nil {
+			if c, err = appendPktInfo(c, src); err != nil {
+				return nil, nil, err
+			}
+		}
+		systype.SetMMsgControl(&mmsgs[n], c)
+		g.segs[n] = j - i
+		i = j
+	}
+	return mmsgs[:n], g.segs[:n], nil
+}
+
+// release clears the references to the packets and puts g back to the pool.
+func (g *sendBatch) release() {
+	for i := range g.iovs {
+		g.iovs[i] = unix.Iovec{}
+	}
+	sendBatchPool.Put(g)
+}
+
+// parseRecvControl parses the control messages received with the block, and saves the packet
+// info into the meta of the block. It returns the segment size of UDP GRO, 0 is returned if
+// the datagram is not coalesced.
+func parseRecvControl(control []byte, block []byte) int {
+	var segSize int
+	for len(control) >= unix.CmsgLen(0) {
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&control[0]))
+		l := int(h.Len)
+		if l < unix.CmsgLen(0) || l > len(control) {
+			break
+		}
+		data := control[unix.CmsgLen(0):l]
+		switch {
+		case h.Level == unix.IPPROTO_UDP && h.Type == unix.UDP_GRO && len(data) >= 4:
+			segSize = int(*(*int32)(unsafe.Pointer(&data[0])))
+		case h.Level == unix.IPPROTO_IP && h.Type == unix.IP_PKTINFO && len(data) >= unix.SizeofInet4Pktinfo:
+			info := (*unix.Inet4Pktinfo)(unsafe.Pointer(&data[0]))
+			setUDPMetaAddr(block, info.Addr[:], int(info.Ifindex))
+		case h.Level == unix.IPPROTO_IPV6 && h.Type == unix.IPV6_PKTINFO && len(data) >= unix.SizeofInet6Pktinfo:
+			info := (*unix.Inet6Pktinfo)(unsafe.Pointer(&data[0]))
+			setUDPMetaAddr(block, info.Addr[:], int(info.Ifindex))
+		case h.Level == unix.SOL_SOCKET && h.Type == unix.SCM_TIMESTAMPNS &&
+			len(data) >= int(unsafe.Sizeof(unix.Timespec{})):
+			ts := (*unix.Timespec)(unsafe.Pointer(&data[0]))
+			setUDPMetaTimestamp(block, ts.Nano())
+		case h.Level == unix.IPPROTO_IP && h.Type == unix.IP_TOS && len(data) >= 1:
+			setUDPMetaTOS(block, data[0])
+		case h.Level == unix.IPPROTO_IPV6 && h.Type == unix.IPV6_TCLASS && len(data) >= 4:
+			setUDPMetaTOS(block, uint8(*(*int32)(unsafe.Pointer(&data[0]))))
+		}
+		space := unix.CmsgSpace(len(data))
+		if space >= len(control) {
+			break
+		}
+		control = control[space:]
+	}
+	return segSize
+}
+
+// appendRecvBlock parses the control messages received with the block, and appends the
+// packets of the block to packets. flags is the flags of the received message, and gro
+// reports whether UDP GRO is enabled.
+func appendRecvBlock(packets [][]byte, block, control []byte, flags int32, gro bool) [][]byte {
+	segSize := parseRecvControl(control, block)
+	if flags&unix.MSG_CTRUNC != 0 && gro && segSize == 0 {
+		// The segment size of UDP GRO may be lost with the truncated control messages,
+		// the coalesced datagrams can't be told apart, so drop the block.
+		mcache.Free(block)
+		return packets
+	}
+	return splitGROBlock(block, segSize, packets)
+}
+
+// splitGROBlock splits the block holding datagrams coalesced by UDP GRO into blocks of
+// one datagram each, and appends them to packets. The first datagram reuses the block,
+// the others are copied together with the header.
+func splitGROBlock(block []byte, segSize int, packets [][]byte) [][]byte {
+	header := udpHeaderLen(block)
+	data := block[header:]
+	if segSize <= 0 || len(data) <= segSize {
+		return append(packets, block)
+	}
+	packets = append(packets, block[:header+segSize])
+	for off := segSize; off < len(data); off += segSize {
+		end := off + segSize
+		if end > len(data) {
+			end = len(data)
+		}
+		p := mcache.Malloc(header + end - off)
+		copy(p, block[:header])
+		copy(p[header:], data[off:end])
+		packets = append(packets, p)
+	}
+	return packets
+}
+
 func (nfd *netFD) syscallMMsg(trap int, mmsgs []systype.MMsghdr) (int, error) {
 	switch trap {
 	case unix.SYS_SENDMMSG:
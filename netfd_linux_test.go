@@ -17,10 +17,14 @@
 package tnet
 
 import (
+	"bytes"
 	"net"
 	"testing"
+	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 	"trpc.group/trpc-go/tnet/internal/buffer"
 	"trpc.group/trpc-go/tnet/internal/cache/systype"
 )
@@ -118,3 +122,423 @@ func Test_buildMsg_err(t *testing.T) {
 	err := buildMsg(&systype.Msghdr{}, make([]byte, 0))
 	assert.NotNil(t, err)
 }
+
+func Test_netFD_UDPGSO(t *testing.T) {
+	sender, err := rawListenUDP("udp4")
+	assert.Nil(t, err)
+	defer sender.Close()
+	nfd, err := rawToNetFD(sender)
+	assert.Nil(t, err)
+	if err := nfd.setUDPGSO(true); err != nil {
+		t.Skipf("udp gso is not supported: %v", err)
+	}
+	receiver, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer receiver.Close()
+
+	sizes := []int{100, 100, 100, 40}
+	b := buffer.New()
+	for i, size := range sizes {
+		block, err := parcel(bytes.Repeat([]byte{byte(i)}, size), receiver.LocalAddr())
+		assert.Nil(t, err)
+		b.Write(false, block)
+	}
+	assert.Nil(t, nfd.SendPackets(b))
+	assert.Equal(t, 0, b.LenRead())
+	assert.True(t, nfd.udpGSO.Load())
+
+	got := readUDPPayloads(t, receiver, len(sizes))
+	for i, size := range sizes {
+		assert.Equal(t, bytes.Repeat([]byte{byte(i)}, size), got[i])
+	}
+}
+
+func Test_netFD_UDPGSOInvalidMessage(t *testing.T) {
+	sender, err := rawListenUDP("udp4")
+	assert.Nil(t, err)
+	defer sender.Close()
+	nfd, err := rawToNetFD(sender)
+	assert.Nil(t, err)
+	if err := nfd.setUDPGSO(true); err != nil {
+		t.Skipf("udp gso is not supported: %v", err)
+	}
+	receiver, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer receiver.Close()
+
+	// The packets sent to port 0 are coalesced into one message which is rejected with EINVAL.
+	b := buffer.New()
+	for _, addr := range []net.Addr{
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0},
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0},
+		receiver.LocalAddr(),
+		receiver.LocalAddr(),
+	} {
+		block, err := parcel(bytes.Repeat([]byte{1}, 100), addr)
+		assert.Nil(t, err)
+		b.Write(false, block)
+	}
+	for i := 0; i < 4 && b.LenRead() != 0; i++ {
+		assert.Nil(t, nfd.SendPackets(b))
+	}
+	assert.Equal(t, 0, b.LenRead())
+	assert.True(t, nfd.udpGSO.Load(), "gso is kept on EINVAL")
+
+	got := readUDPPayloads(t, receiver, 2)
+	for _, p := range got {
+		assert.Equal(t, bytes.Repeat([]byte{1}, 100), p)
+	}
+}
+
+func Test_sendBatch_build(t *testing.T) {
+	addr1 := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	addr2 := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+	src := net.IPv4(127, 0, 0, 2)
+	var bufs [][]byte
+	for _, p := range []struct {
+		addr *net.UDPAddr
+		src  net.IP
+		size int
+	}{
+		{addr1, nil, 10}, {addr1, nil, 10}, {addr1, nil, 5}, // Coalesced, the last one is shorter.
+		{addr1, nil, 10}, {addr1, nil, 20}, // Not coalesced, the latter is longer.
+		{addr2, nil, 20}, {addr1, nil, 20}, // Not coalesced, different addresses.
+		{addr1, src, 20}, {addr1, src, 20}, // Coalesced with the source address.
+	} {
+		block, err := parcelFrom(make([]byte, p.size), p.addr, p.src)
+		assert.Nil(t, err)
+		bufs = append(bufs, block)
+	}
+	batch := sendBatchPool.Get().(*sendBatch)
+	defer batch.release()
+	mmsgs, segs, err := batch.build(make([]systype.MMsghdr, len(bufs)), bufs, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3, 1, 1, 1, 1, 2}, segs)
+	assert.Len(t, mmsgs, 6)
+	assert.EqualValues(t, 3, mmsgs[0].Hdr.Iovlen)
+	assert.EqualValues(t, unix.CmsgSpace(2), mmsgs[0].Hdr.Controllen)
+	assert.Nil(t, mmsgs[1].Hdr.Control)
+	assert.EqualValues(t, unix.CmsgSpace(2)+unix.CmsgSpace(unix.SizeofInet4Pktinfo), mmsgs[5].Hdr.Controllen)
+
+	mmsgs, segs, err = batch.build(make([]systype.MMsghdr, len(bufs)), bufs, false)
+	assert.Nil(t, err)
+	assert.Len(t, mmsgs, len(bufs))
+	for i, seg := range segs {
+		assert.Equal(t, 1, seg)
+		if i < 7 {
+			assert.Nil(t, mmsgs[i].Hdr.Control)
+		} else {
+			assert.EqualValues(t, unix.CmsgSpace(unix.SizeofInet4Pktinfo), mmsgs[i].Hdr.Controllen)
+		}
+	}
+
+	_, _, err = batch.build(make([]systype.MMsghdr, 1), [][]byte{make([]byte, udpHeaderSize)}, true)
+	assert.NotNil(t, err)
+}
+
+func Test_netFD_UDPGRO(t *testing.T) {
+	receiver, err := rawListenUDP("udp4")
+	assert.Nil(t, err)
+	defer receiver.Close()
+	rnfd, err := rawToNetFD(receiver)
+	assert.Nil(t, err)
+	if err := rnfd.setUDPGRO(true); err != nil {
+		t.Skipf("udp gro is not supported: %v", err)
+	}
+	sender, err := rawListenUDP("udp4")
+	assert.Nil(t, err)
+	defer sender.Close()
+	snfd, err := rawToNetFD(sender)
+	assert.Nil(t, err)
+	if err := snfd.setUDPGSO(true); err != nil {
+		t.Skipf("udp gso is not supported: %v", err)
+	}
+
+	for _, exact := range []bool{false, true} {
+		rnfd.exactUDPBufferSizeEnabled = exact
+		sizes := []int{1000, 1000, 1000, 300}
+		b := buffer.New()
+		for i, size := range sizes {
+			block, err := parcel(bytes.Repeat([]byte{byte(i)}, size), receiver.LocalAddr())
+			assert.Nil(t, err)
+			b.Write(false, block)
+		}
+		assert.Nil(t, snfd.SendPackets(b))
+
+		in := buffer.New()
+		for i, size := range sizes {
+			for in.LenRead() == 0 {
+				assert.Nil(t, rnfd.FillToBuffer(in))
+			}
+			block, err := in.ReadBlock()
+			assert.Nil(t, err)
+			data, addr, err := getUDPDataAndAddr(block)
+			assert.Nil(t, err)
+			assert.Equal(t, bytes.Repeat([]byte{byte(i)}, size), data)
+			assert.Equal(t, sender.LocalAddr().String(), addr.String())
+		}
+	}
+}
+
+func Test_splitGROBlock(t *testing.T) {
+	block, err := parcelFrom([]byte{1, 1, 2, 2, 3}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1},
+		net.IPv4(127, 0, 0, 2))
+	assert.Nil(t, err)
+
+	packets := splitGROBlock(block, 0, nil)
+	assert.Equal(t, [][]byte{block}, packets)
+
+	packets = splitGROBlock(block, 2, nil)
+	assert.Len(t, packets, 3)
+	for i, data := range [][]byte{{1, 1}, {2, 2}, {3}} {
+		assert.Equal(t, block[:udpMetaHeaderSize], packets[i][:udpMetaHeaderSize])
+		assert.Equal(t, data, packets[i][udpMetaHeaderSize:])
+	}
+
+	block, err = parcel([]byte{1, 1, 2}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	assert.Nil(t, err)
+	packets = splitGROBlock(block, 2, nil)
+	assert.Len(t, packets, 2)
+	assert.Equal(t, block[:udpHeaderSize], packets[1][:udpHeaderSize])
+	assert.Equal(t, []byte{2}, packets[1][udpHeaderSize:])
+}
+
+func Test_parseRecvControl(t *testing.T) {
+	block, err := parcelFrom([]byte{1}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, net.IPv4zero)
+	assert.Nil(t, err)
+	resetUDPMeta(block)
+	assert.Equal(t, 0, parseRecvControl(nil, block))
+	ip, _ := getUDPMetaAddr(block)
+	assert.Nil(t, ip)
+
+	control := make([]byte, unix.CmsgSpace(4)*2+unix.CmsgSpace(unix.SizeofInet4Pktinfo))
+	c := control
+	putCmsg(c, unix.SOL_SOCKET, unix.SO_TIMESTAMP, 4)
+	c = c[unix.CmsgSpace(4):]
+	*(*int32)(unsafe.Pointer(&putCmsg(c, unix.IPPROTO_UDP, unix.UDP_GRO, 4)[0])) = 1200
+	c = c[unix.CmsgSpace(4):]
+	info := (*unix.Inet4Pktinfo)(unsafe.Pointer(&putCmsg(c, unix.IPPROTO_IP, unix.IP_PKTINFO,
+		unix.SizeofInet4Pktinfo)[0]))
+	info.Ifindex = 3
+	copy(info.Addr[:], net.IPv4(127, 0, 0, 2).To4())
+	assert.Equal(t, 1200, parseRecvControl(control, block))
+	ip, ifIndex := getUDPMetaAddr(block)
+	assert.Equal(t, net.IPv4(127, 0, 0, 2).To4(), ip)
+	assert.Equal(t, 3, ifIndex)
+
+	resetUDPMeta(block)
+	assert.Equal(t, 0, parseRecvControl(control[:unix.CmsgSpace(4)], block))
+	ip, _ = getUDPMetaAddr(block)
+	assert.Nil(t, ip)
+
+	now := time.Now()
+	tsSize := int(unsafe.Sizeof(unix.Timespec{}))
+	control = make([]byte, unix.CmsgSpace(tsSize)+unix.CmsgSpace(1))
+	ts := (*unix.Timespec)(unsafe.Pointer(&putCmsg(control, unix.SOL_SOCKET, unix.SCM_TIMESTAMPNS, tsSize)[0]))
+	*ts = unix.NsecToTimespec(now.UnixNano())
+	putCmsg(control[unix.CmsgSpace(tsSize):], unix.IPPROTO_IP, unix.IP_TOS, 1)[0] = 0xb9
+	assert.Equal(t, 0, parseRecvControl(control, block))
+	assert.True(t, now.Equal(getUDPMetaTimestamp(block)))
+	tos, ok := getUDPMetaTOS(block)
+	assert.True(t, ok)
+	assert.EqualValues(t, 0xb9, tos)
+
+	resetUDPMeta(block)
+	assert.True(t, getUDPMetaTimestamp(block).IsZero())
+	_, ok = getUDPMetaTOS(block)
+	assert.False(t, ok)
+}
+
+func Test_netFD_appendRecvBlock(t *testing.T) {
+	block, err := parcel([]byte{1, 1, 2}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	assert.Nil(t, err)
+	control := make([]byte, unix.CmsgSpace(4))
+	*(*int32)(unsafe.Pointer(&putCmsg(control, unix.IPPROTO_UDP, unix.UDP_GRO, 4)[0])) = 2
+	packets := appendRecvBlock(nil, block, control, unix.MSG_CTRUNC, true)
+	assert.Len(t, packets, 2)
+
+	// The block is dropped if the segment size may be lost.
+	block, err = parcel([]byte{1, 1, 2}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	assert.Nil(t, err)
+	assert.Empty(t, appendRecvBlock(nil, block, nil, unix.MSG_CTRUNC, true))
+
+	block, err = parcel([]byte{1, 1, 2}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{block}, appendRecvBlock(nil, block, nil, unix.MSG_CTRUNC, false))
+}
+
+func Test_netFD_UDPTimestampTOS(t *testing.T) {
+	for _, network := range []string{"udp4", "udp6"} {
+		receiver, err := rawListenUDP(network)
+		if err != nil {
+			t.Skipf("listen %s: %v", network, err)
+		}
+		rnfd, err := rawToNetFD(receiver)
+		assert.Nil(t, err)
+		assert.Nil(t, rnfd.setUDPTimestamp(true))
+		assert.Nil(t, rnfd.setUDPRecvTOS(true))
+		sender, err := rawListenUDP(network)
+		assert.Nil(t, err)
+		snfd, err := rawToNetFD(sender)
+		assert.Nil(t, err)
+		const tos = 0xb8 | 0x02 // DSCP EF with ECT(0).
+		assert.Nil(t, snfd.setUDPTOS(tos))
+
+		for _, exact := range []bool{false, true} {
+			rnfd.exactUDPBufferSizeEnabled = exact
+			start := time.Now()
+			_, err = snfd.WriteTo([]byte("ping"), receiver.LocalAddr())
+			assert.Nil(t, err)
+			in := buffer.New()
+			for in.LenRead() == 0 {
+				assert.Nil(t, rnfd.FillToBuffer(in))
+			}
+			block, err := in.ReadBlock()
+			assert.Nil(t, err)
+			p := &packet{block: block}
+			got, ok := p.TOS()
+			assert.True(t, ok, network)
+			assert.EqualValues(t, tos, got, network)
+			ts := p.Timestamp()
+			assert.False(t, ts.Before(start.Add(-time.Second)), network)
+			assert.False(t, ts.After(time.Now().Add(time.Second)), network)
+			p.Free()
+		}
+		sender.Close()
+		receiver.Close()
+	}
+}
+
+func Test_netFD_UDPPktInfo(t *testing.T) {
+	for _, network := range []string{"udp4", "udp6"} {
+		loopback := net.IPv4(127, 0, 0, 1).To4()
+		if network == "udp6" {
+			loopback = net.IPv6loopback
+		}
+		receiver, err := net.ListenPacket(network, ":0")
+		if err != nil {
+			t.Skipf("listen %s: %v", network, err)
+		}
+		rnfd, err := rawToNetFD(receiver)
+		assert.Nil(t, err)
+		assert.Nil(t, rnfd.setUDPPktInfo(true))
+		client, err := rawListenUDP(network)
+		assert.Nil(t, err)
+		port := receiver.LocalAddr().(*net.UDPAddr).Port
+		_, err = client.WriteTo([]byte("ping"), &net.UDPAddr{IP: loopback, Port: port})
+		assert.Nil(t, err)
+
+		for _, exact := range []bool{false, true} {
+			rnfd.exactUDPBufferSizeEnabled = exact
+			in := buffer.New()
+			for in.LenRead() == 0 {
+				assert.Nil(t, rnfd.FillToBuffer(in))
+			}
+			block, err := in.ReadBlock()
+			assert.Nil(t, err)
+			dst, ifIndex := getUDPMetaAddr(block)
+			assert.True(t, loopback.Equal(dst), "%s: dst %v", network, dst)
+			assert.NotZero(t, ifIndex)
+
+			// Reply from the destination address, both directly and in batch.
+			_, err = rnfd.WriteToFrom([]byte("pong"), client.LocalAddr(), dst)
+			assert.Nil(t, err)
+			p := make([]byte, 8)
+			n, from, err := client.ReadFrom(p)
+			assert.Nil(t, err)
+			assert.Equal(t, "pong", string(p[:n]))
+			assert.True(t, loopback.Equal(from.(*net.UDPAddr).IP))
+
+			b := buffer.New()
+			block, err = parcelFrom([]byte("pong"), client.LocalAddr(), dst)
+			assert.Nil(t, err)
+			b.Write(false, block)
+			assert.Nil(t, rnfd.SendPackets(b))
+			n, from, err = client.ReadFrom(p)
+			assert.Nil(t, err)
+			assert.Equal(t, "pong", string(p[:n]))
+			assert.True(t, loopback.Equal(from.(*net.UDPAddr).IP))
+			// Send the next packet to the receiver for the exact buffer case.
+			_, err = client.WriteTo([]byte("ping"), &net.UDPAddr{IP: loopback, Port: port})
+			assert.Nil(t, err)
+		}
+		client.Close()
+		receiver.Close()
+	}
+}
+
+func Test_netFD_UDPDualStackControl(t *testing.T) {
+	receiver, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		t.Skipf("listen udp: %v", err)
+	}
+	defer receiver.Close()
+	if receiver.LocalAddr().(*net.UDPAddr).IP.To4() != nil {
+		t.Skip("dual stack is not supported")
+	}
+	rnfd, err := rawToNetFD(receiver)
+	assert.Nil(t, err)
+	// All the control messages of both families fit in the buffer.
+	_ = rnfd.setUDPGRO(true)
+	assert.Nil(t, rnfd.setUDPPktInfo(true))
+	assert.Nil(t, rnfd.setUDPTimestamp(true))
+	assert.Nil(t, rnfd.setUDPRecvTOS(true))
+	sender, err := rawListenUDP("udp4")
+	assert.Nil(t, err)
+	defer sender.Close()
+	snfd, err := rawToNetFD(sender)
+	assert.Nil(t, err)
+	const tos = 0xb8 | 0x02
+	assert.Nil(t, snfd.setUDPTOS(tos))
+
+	loopback := net.IPv4(127, 0, 0, 1)
+	port := receiver.LocalAddr().(*net.UDPAddr).Port
+	for _, exact := range []bool{false, true} {
+		rnfd.exactUDPBufferSizeEnabled = exact
+		_, err = sender.WriteTo([]byte("ping"), &net.UDPAddr{IP: loopback, Port: port})
+		assert.Nil(t, err)
+		// The packet is dropped if the control messages are truncated.
+		in := buffer.New()
+		assert.Eventually(t, func() bool {
+			return rnfd.FillToBuffer(in) == nil && in.LenRead() > 0
+		}, time.Second, time.Millisecond)
+		block, err := in.ReadBlock()
+		if !assert.Nil(t, err) {
+			return
+		}
+		p := &packet{block: block}
+		data, err := p.Data()
+		assert.Nil(t, err)
+		assert.Equal(t, "ping", string(data))
+		assert.True(t, loopback.Equal(p.DstIP()), "dst %v", p.DstIP())
+		assert.NotZero(t, p.IfIndex())
+		got, ok := p.TOS()
+		assert.True(t, ok)
+		assert.EqualValues(t, tos, got)
+		assert.False(t, p.Timestamp().IsZero())
+		p.Free()
+	}
+}
+
+func Test_newUDPService_UDPOptions(t *testing.T) {
+	lns, err := listenUDP("udp", "127.0.0.1:", false)
+	assert.Nil(t, err)
+	conn := lns[0].(*udpconn)
+	defer conn.Close()
+	gsoErr := conn.nfd.setUDPGSO(true)
+	groErr := conn.nfd.setUDPGRO(true)
+	assert.Nil(t, conn.nfd.setUDPGSO(false))
+	assert.Nil(t, conn.nfd.setUDPGRO(false))
+
+	_, err = newUDPService(lns, func(PacketConn) error { return nil }, WithUDPGSO(true), WithUDPGRO(true),
+		WithUDPPacketInfo(true), WithUDPTimestamp(true), WithUDPRecvTOS(true), WithUDPTOS(0x02))
+	assert.Nil(t, err)
+	assert.Equal(t, gsoErr == nil, conn.nfd.udpGSO.Load())
+	assert.Equal(t, groErr == nil, conn.nfd.udpGRO.Load())
+	assert.True(t, conn.nfd.udpPktInfo.Load())
+	assert.True(t, conn.nfd.udpTimestamp.Load())
+	assert.True(t, conn.nfd.udpRecvTOS.Load())
+	tos, err := unix.GetsockoptInt(conn.nfd.fd, unix.IPPROTO_IP, unix.IP_TOS)
+	assert.Nil(t, err)
+	assert.Equal(t, 0x02, tos)
+}
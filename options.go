@@ -55,6 +55,17 @@ type OnUDPClosed func(conn PacketConn) error
 // TCPHandler fires when the tcp connection receives data.
 type TCPHandler func(conn Conn) error
 
+// TCPHandlers groups the hooks serving a tcp connection, so that a protocol implementation
+// can be plugged into a service which dispatches the connections, such as protomux.
+type TCPHandlers struct {
+	// OnOpened is called once the connection is dispatched, which replaces WithOnTCPOpened.
+	OnOpened OnTCPOpened
+	// OnRequest is the request handler of the connection, it must not be nil.
+	OnRequest TCPHandler
+	// OnClosed is called when a dispatched connection is closed, which replaces WithOnTCPClosed.
+	OnClosed OnTCPClosed
+}
+
 // UDPHandler fires when the udp connection receives data.
 type UDPHandler func(conn PacketConn) error
 
@@ -76,7 +87,20 @@ type options struct {
 	safeWrite                 bool
 	maxUDPPacketSize          int
 	exactUDPBufferSizeEnabled bool
+	udpGSO                    bool
+	udpGRO                    bool
+	udpPktInfo                bool
+	udpTimestamp              bool
+	udpRecvTOS                bool
+	udpTOS                    int
+	udpTOSSet                 bool
 	gracefulRestartTimeout    time.Duration
+	frameConcurrency          FrameConcurrency
+	tcpInterceptors           []TCPInterceptor
+	udpInterceptors           []UDPInterceptor
+	panicHandler              PanicHandler
+	proxyProtocol             ProxyProtocolMode
+	proxyHeaderTimeout        time.Duration
 }
 
 func (o *options) setDefault() {
@@ -84,6 +108,7 @@ func (o *options) setDefault() {
 	o.maxUDPPacketSize = defaultUDPBufferSize
 	o.exactUDPBufferSizeEnabled = defaultExactUDPBufferSizeEnabled
 	o.gracefulRestartTimeout = defaultGracefulRestartTimeout
+	o.proxyHeaderTimeout = defaultProxyHeaderTimeout
 }
 
 // WithTCPKeepAlive sets the tcp keep alive interval.
@@ -200,6 +225,63 @@ func WithExactUDPBufferSizeEnabled(exactUDPBufferSizeEnabled bool) Option {
 	}}
 }
 
+// WithUDPGSO sets whether to enable UDP generic segmentation offload on linux, false in default.
+// If enabled, the consecutive packets written by WriteTo to the same address with equal size
+// that are batched in the outbound buffer are sent as one message and segmented by the kernel,
+// which cuts the cost of syscall and network stack. It falls back to normal sending if the
+// kernel or the device doesn't support it.
+func WithUDPGSO(enabled bool) Option {
+	return Option{func(op *options) {
+		op.udpGSO = enabled
+	}}
+}
+
+// WithUDPGRO sets whether to enable UDP generic receive offload on linux, false in default.
+// If enabled, the kernel may coalesce the datagrams of the same flow into one buffer, which
+// is split into separate packets again when reading, so ReadPacket still returns one datagram
+// at a time. It falls back to normal receiving if the kernel doesn't support it.
+func WithUDPGRO(enabled bool) Option {
+	return Option{func(op *options) {
+		op.udpGRO = enabled
+	}}
+}
+
+// WithUDPPacketInfo sets whether to report the local destination address and the interface
+// index of received UDP packets on linux, false in default. If enabled, they are returned by
+// PacketInfo.DstIP and PacketInfo.IfIndex, so that a service bound to a wildcard address can
+// reply from the address the request arrived on by WriterToFrom.WriteToFrom.
+func WithUDPPacketInfo(enabled bool) Option {
+	return Option{func(op *options) {
+		op.udpPktInfo = enabled
+	}}
+}
+
+// WithUDPTimestamp sets whether to report the kernel receive timestamp of UDP packets by
+// SO_TIMESTAMPNS on linux, false in default. If enabled, it is returned by PacketInfo.Timestamp.
+func WithUDPTimestamp(enabled bool) Option {
+	return Option{func(op *options) {
+		op.udpTimestamp = enabled
+	}}
+}
+
+// WithUDPRecvTOS sets whether to report the TOS byte (traffic class for IPv6) of received
+// UDP packets on linux, false in default. If enabled, it is returned by PacketInfo.TOS, whose
+// lowest two bits are the ECN field.
+func WithUDPRecvTOS(enabled bool) Option {
+	return Option{func(op *options) {
+		op.udpRecvTOS = enabled
+	}}
+}
+
+// WithUDPTOS sets the TOS byte (traffic class for IPv6) of sent UDP packets on linux, which
+// includes the DSCP and the ECN bits. The system default is used if it is not set.
+func WithUDPTOS(tos uint8) Option {
+	return Option{func(op *options) {
+		op.udpTOS = int(tos)
+		op.udpTOSSet = true
+	}}
+}
+
 // WithGracefulRestartTimeout sets the timeout for graceful restart.
 // The parent process waits this long after starting the child process before closing the listener.
 func WithGracefulRestartTimeout(timeout time.Duration) Option {
@@ -207,3 +289,59 @@ func WithGracefulRestartTimeout(timeout time.Duration) Option {
 		op.gracefulRestartTimeout = timeout
 	}}
 }
+
+// WithFrameConcurrency sets how the frames of one connection are dispatched to OnMessage
+// by the service created by NewFramedService. Default value is FrameInOrder.
+func WithFrameConcurrency(concurrency FrameConcurrency) Option {
+	return Option{func(op *options) {
+		op.frameConcurrency = concurrency
+	}}
+}
+
+// WithTCPInterceptors appends interceptors which wrap the TCPHandler, OnTCPOpened and OnTCPClosed
+// of the service. The first interceptor is the outermost one.
+func WithTCPInterceptors(interceptors ...TCPInterceptor) Option {
+	return Option{func(op *options) {
+		op.tcpInterceptors = append(op.tcpInterceptors, interceptors...)
+	}}
+}
+
+// WithUDPInterceptors appends interceptors which wrap the UDPHandler and OnUDPClosed
+// of the service. The first interceptor is the outermost one.
+func WithUDPInterceptors(interceptors ...UDPInterceptor) Option {
+	return Option{func(op *options) {
+		op.udpInterceptors = append(op.udpInterceptors, interceptors...)
+	}}
+}
+
+// WithPanicHandler registers the PanicHandler which fires when the handler or the
+// open/close hooks of a connection panic. The tcp connection is closed after the panic, and
+// the peer gets RST instead of FIN if there is data left unread in the socket, e.g. when
+// OnTCPOpened panics. The udp connection is shared by all the peers, so only the packet
+// being handled is dropped.
+func WithPanicHandler(panicHandler PanicHandler) Option {
+	return Option{func(op *options) {
+		op.panicHandler = panicHandler
+	}}
+}
+
+// WithProxyProtocol sets whether the tcp service reads the PROXY protocol v1/v2 header sent by
+// the load balancer at the beginning of each connection. Once the header is read, RemoteAddr and
+// LocalAddr report the addresses of the client and the balancer in the header. The OnTCPOpened
+// hook is delayed until the header is read, see ProxyProtocolMode for details.
+func WithProxyProtocol(mode ProxyProtocolMode) Option {
+	return Option{func(op *options) {
+		op.proxyProtocol = mode
+	}}
+}
+
+// WithProxyHeaderTimeout sets the timeout of reading the PROXY protocol header, default is 10s.
+// If d <= 0, there is no timeout. The precision is 1 second, a shorter d is taken as 1 second.
+func WithProxyHeaderTimeout(d time.Duration) Option {
+	return Option{func(op *options) {
+		if d > 0 && d < time.Second {
+			d = time.Second
+		}
+		op.proxyHeaderTimeout = d
+	}}
+}
@@ -0,0 +1,87 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"trpc.group/trpc-go/tnet/internal/buffer"
+)
+
+// OwnedBytes holds the bytes detached from a connection, created by OwnedReader.NextOwned.
+// It is not safe for concurrent use.
+type OwnedBytes interface {
+	// Len returns the length of the bytes.
+	Len() int
+
+	// Blocks returns the bytes as memory blocks without copying.
+	// Zero-Copy API.
+	Blocks() [][]byte
+
+	// Bytes returns the bytes as one slice, a copy is made only if the bytes span several blocks.
+	Bytes() []byte
+
+	// Free will release the underlying memory blocks.
+	// It will recycle the underlying memory blocks for better performance.
+	// The bytes will be invalid after free, so free it only when it is no longer in use.
+	Free()
+}
+
+type ownedBytes struct {
+	buf *buffer.Buffer
+}
+
+// NextOwned returns the next n bytes with advancing the reader. It waits like Next.
+// The underlying memory blocks are detached from the connection, so the bytes
+// stay valid after Release until OwnedBytes.Free is called.
+func (tc *tcpconn) NextOwned(n int) (OwnedBytes, error) {
+	d, err := tc.detach(n)
+	if err != nil {
+		return nil, err
+	}
+	return &ownedBytes{buf: d}, nil
+}
+
+// Len returns the length of the bytes.
+func (o *ownedBytes) Len() int {
+	if o.buf == nil {
+		return 0
+	}
+	return o.buf.LenRead()
+}
+
+// Blocks returns the bytes as memory blocks without copying.
+func (o *ownedBytes) Blocks() [][]byte {
+	if o.buf == nil {
+		return nil
+	}
+	blocks, _ := o.buf.PeekBlocksN(o.buf.LenRead())
+	return blocks
+}
+
+// Bytes returns the bytes as one slice.
+func (o *ownedBytes) Bytes() []byte {
+	if o.buf == nil {
+		return nil
+	}
+	p, _ := o.buf.Peek(o.buf.LenRead())
+	return p
+}
+
+// Free will release the underlying memory blocks.
+func (o *ownedBytes) Free() {
+	if o.buf == nil {
+		return
+	}
+	buffer.Free(o.buf)
+	o.buf = nil
+}
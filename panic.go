@@ -0,0 +1,86 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"trpc.group/trpc-go/tnet/log"
+	"trpc.group/trpc-go/tnet/metrics"
+)
+
+// ErrHandlerPanic means that the user handler or hook panics, the tcp connection is closed.
+var ErrHandlerPanic = errors.New("handler panic")
+
+// PanicHandler fires when the user handler or hook of the connection panics.
+// Recovered is the value returned by recover() and stack is the stack trace of the panicking goroutine.
+// By default, the panic is logged.
+type PanicHandler func(conn BaseConn, recovered interface{}, stack []byte)
+
+// handlePanic reports the recovered panic and returns the error which makes the connection closed.
+func handlePanic(conn BaseConn, panicHandler PanicHandler, recovered interface{}) error {
+	metrics.Add(metrics.HandlerPanics, 1)
+	stack := debug.Stack()
+	if panicHandler != nil {
+		panicHandler(conn, recovered, stack)
+	} else {
+		log.Errorf("tnet handler panic: %v\n%s", recovered, stack)
+	}
+	return fmt.Errorf("%w: %v", ErrHandlerPanic, recovered)
+}
+
+// callTCPHandler calls handler with conn and turns its panic into an error.
+func (tc *tcpconn) callTCPHandler(handler TCPHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = handlePanic(tc, tc.panicHandler, r)
+		}
+	}()
+	return handler(tc)
+}
+
+// callUDPHandler calls handler with conn and turns its panic into an error.
+func (uc *udpconn) callUDPHandler(handler UDPHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = handlePanic(uc, uc.panicHandler, r)
+		}
+	}()
+	return handler(uc)
+}
+
+// callUDPRequestHandler calls the request handler with conn and recovers its panic per packet.
+// The udp conn is shared by all the peers, so it is kept open and only the packet that the handler
+// hasn't read is dropped, which would otherwise make the handler panic again and again.
+func (uc *udpconn) callUDPRequestHandler(handler UDPHandler) (err error) {
+	read := uc.packetsRead.Load()
+	defer func() {
+		if r := recover(); r != nil {
+			handlePanic(uc, uc.panicHandler, r)
+			uc.dropUnreadPacket(read)
+			err = nil
+		}
+	}()
+	return handler(uc)
+}
+
+// dropUnreadPacket drops the head packet if no packet is read since packetsRead was read.
+// The length of the inbound buffer can't tell it, as the poller may fill the buffer meanwhile.
+func (uc *udpconn) dropUnreadPacket(read uint64) {
+	if uc.packetsRead.Load() == read {
+		uc.dropPacket()
+	}
+}
@@ -0,0 +1,159 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+	"trpc.group/trpc-go/tnet/metrics"
+)
+
+func TestTCPHandlerPanic(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []tnet.Option
+		// send is whether to send data to trigger the panic.
+		send bool
+	}{
+		{"async handler", nil, true},
+		{"sync handler", []tnet.Option{tnet.WithNonBlocking(true)}, true},
+		// Nothing is sent, otherwise the unread data makes the peer get RST instead of FIN.
+		{"on opened", []tnet.Option{tnet.WithOnTCPOpened(func(tnet.Conn) error { panic("opened") })}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			before := metrics.Get(metrics.HandlerPanics)
+			recovered := make(chan interface{}, 1)
+			ln, err := tnet.Listen("tcp", getTestAddr())
+			require.Nil(t, err)
+			opts := append([]tnet.Option{tnet.WithPanicHandler(func(conn tnet.BaseConn, r interface{}, stack []byte) {
+				assert.NotEmpty(t, stack)
+				recovered <- r
+			})}, tt.opts...)
+			s, err := tnet.NewTCPService(ln, func(conn tnet.Conn) error {
+				panic("handler")
+			}, opts...)
+			require.Nil(t, err)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go s.Serve(ctx)
+
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			require.Nil(t, err)
+			defer conn.Close()
+			if tt.send {
+				_, err = conn.Write(helloWorld)
+				require.Nil(t, err)
+			}
+			select {
+			case r := <-recovered:
+				assert.NotNil(t, r)
+			case <-time.After(time.Second):
+				t.Fatal("wait panic handler timeout")
+			}
+			// The connection is closed after panic.
+			require.Nil(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+			_, err = conn.Read(make([]byte, 1))
+			assert.Equal(t, io.EOF, err)
+			assert.Equal(t, before+1, metrics.Get(metrics.HandlerPanics))
+		})
+	}
+}
+
+func TestUDPHandlerPanic(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []tnet.Option
+		// read is whether the handler reads the packet before panic.
+		read bool
+		// wait is whether the handler waits for the next packet before panic.
+		wait bool
+	}{
+		{"async handler", nil, true, false},
+		{"async handler without reading", nil, false, false},
+		{"async handler without reading while packets arrive", nil, false, true},
+		{"sync handler", []tnet.Option{tnet.WithNonBlocking(true)}, true, false},
+		{"sync handler without reading", []tnet.Option{tnet.WithNonBlocking(true)}, false, false},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			lns, err := tnet.ListenPackets("udp", getTestAddr(), false)
+			require.Nil(t, err)
+			var panics int32
+			s, err := tnet.NewUDPService(lns, func(conn tnet.PacketConn) error {
+				if tt.read {
+					p, addr, err := conn.ReadPacket()
+					if err != nil {
+						return err
+					}
+					defer p.Free()
+					data, err := p.Data()
+					if err != nil {
+						return err
+					}
+					if string(data) != string(helloWorld) {
+						_, err := conn.WriteTo(data, addr)
+						return err
+					}
+				} else if atomic.LoadInt32(&panics) > 0 {
+					p, addr, err := conn.ReadPacket()
+					if err != nil {
+						return err
+					}
+					defer p.Free()
+					data, err := p.Data()
+					if err != nil {
+						return err
+					}
+					_, err = conn.WriteTo(data, addr)
+					return err
+				}
+				if tt.wait {
+					for l := conn.Len(); conn.Len() == l; {
+						time.Sleep(time.Millisecond)
+					}
+				}
+				atomic.AddInt32(&panics, 1)
+				panic("handler")
+			}, append([]tnet.Option{tnet.WithPanicHandler(func(tnet.BaseConn, interface{}, []byte) {})}, tt.opts...)...)
+			require.Nil(t, err)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go s.Serve(ctx)
+
+			conn, err := net.Dial("udp", lns[0].LocalAddr().String())
+			require.Nil(t, err)
+			defer conn.Close()
+			_, err = conn.Write(helloWorld)
+			require.Nil(t, err)
+			// The panicking packet is dropped, and the udp conn still serves the next packet.
+			time.Sleep(10 * time.Millisecond)
+			_, err = conn.Write(hello)
+			require.Nil(t, err)
+			require.Nil(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+			b := make([]byte, 64)
+			n, err := conn.Read(b)
+			require.Nil(t, err)
+			assert.Equal(t, hello, b[:n])
+			assert.Equal(t, int32(1), atomic.LoadInt32(&panics))
+		})
+	}
+}
@@ -0,0 +1,94 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package pool
+
+import (
+	"time"
+
+	"trpc.group/trpc-go/tnet"
+)
+
+const (
+	defaultMaxIdle     = 8
+	defaultIdleTimeout = 50 * time.Second
+)
+
+type options struct {
+	dialer      tnet.Dialer
+	maxIdle     int
+	maxActive   int
+	idleTimeout time.Duration
+	fifo        bool
+	wait        bool
+}
+
+func (o *options) setDefault() {
+	o.maxIdle = defaultMaxIdle
+	o.idleTimeout = defaultIdleTimeout
+	o.wait = true
+}
+
+// Option is the option of Pool.
+type Option func(*options)
+
+// WithDialer sets the Dialer used to create the connections. The Options of the Dialer are
+// applied to every connection, and the pool appends its own interceptor to them to be
+// notified when a connection is closed.
+func WithDialer(d tnet.Dialer) Option {
+	return func(o *options) {
+		o.dialer = d
+	}
+}
+
+// WithMaxIdle sets the max number of idle connections of each address, default is 8.
+// If n <= 0, no connection is kept idle.
+func WithMaxIdle(n int) Option {
+	return func(o *options) {
+		o.maxIdle = n
+	}
+}
+
+// WithMaxActive sets the max number of connections of each address, including the idle
+// and the in-use ones. If n <= 0, there is no limit, which is the default.
+func WithMaxActive(n int) Option {
+	return func(o *options) {
+		o.maxActive = n
+	}
+}
+
+// WithIdleTimeout sets how long a connection may stay idle before it is closed, default is 50s.
+// The idle connections are checked by asynctimer, so the precision is 1 second.
+// If d <= 0, the idle connections are never closed by the pool.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.idleTimeout = d
+	}
+}
+
+// WithFIFO sets whether the idle connections are reused in FIFO order. Default is LIFO, which
+// reuses the most recently used connection, so the rarely used ones can be evicted by idle timeout.
+// FIFO spreads the requests across all the idle connections.
+func WithFIFO(fifo bool) Option {
+	return func(o *options) {
+		o.fifo = fifo
+	}
+}
+
+// WithWait sets whether Get waits for a connection to be put back when the max active
+// limit is reached, default is true. If false, Get returns ErrPoolExhausted at once.
+func WithWait(wait bool) Option {
+	return func(o *options) {
+		o.wait = wait
+	}
+}
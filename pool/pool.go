@@ -0,0 +1,472 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+// Package pool provides the client connection pool of tnet.Conn, which keeps
+// a pool of connections for each address.
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+	"trpc.group/trpc-go/tnet"
+	"trpc.group/trpc-go/tnet/internal/asynctimer"
+)
+
+var (
+	// ErrPoolClosed is returned when getting connection from a closed pool.
+	ErrPoolClosed = errors.New("pool: pool is closed")
+	// ErrPoolExhausted is returned when the max active limit is reached and WithWait(false) is set.
+	ErrPoolExhausted = errors.New("pool: connection pool exhausted")
+)
+
+// Pool manages the connections of multiple addresses, each address has its own pool.
+type Pool struct {
+	opts   options
+	mu     sync.Mutex
+	pools  map[poolKey]*addrPool
+	closed bool
+}
+
+type poolKey struct {
+	network string
+	address string
+}
+
+// New creates a Pool.
+func New(opt ...Option) *Pool {
+	opts := options{}
+	opts.setDefault()
+	for _, o := range opt {
+		o(&opts)
+	}
+	return &Pool{
+		opts:  opts,
+		pools: make(map[poolKey]*addrPool),
+	}
+}
+
+// Get gets an idle connection of the address from the pool, or dials a new one if there is
+// no idle connection. If the max active limit is reached, it waits until a connection is
+// put back or ctx is done. Close the returned Conn to put it back to the pool.
+func (p *Pool) Get(ctx context.Context, network, address string) (*Conn, error) {
+	ap, err := p.getAddrPool(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return ap.get(ctx)
+}
+
+// Stats returns the statistics of the pools of all the addresses.
+func (p *Pool) Stats() []Stats {
+	p.mu.Lock()
+	pools := make([]*addrPool, 0, len(p.pools))
+	for _, ap := range p.pools {
+		pools = append(pools, ap)
+	}
+	p.mu.Unlock()
+	stats := make([]Stats, 0, len(pools))
+	for _, ap := range pools {
+		stats = append(stats, ap.stats())
+	}
+	return stats
+}
+
+// Close closes the pool and all its idle connections. The in-use connections are closed
+// when they are put back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	pools := p.pools
+	p.pools = nil
+	p.mu.Unlock()
+	for _, ap := range pools {
+		ap.close()
+	}
+	return nil
+}
+
+func (p *Pool) getAddrPool(network, address string) (*addrPool, error) {
+	key := poolKey{network: network, address: address}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	if ap, ok := p.pools[key]; ok {
+		return ap, nil
+	}
+	ap := newAddrPool(network, address, &p.opts)
+	p.pools[key] = ap
+	return ap, nil
+}
+
+// Stats is the statistics of the pool of one address.
+type Stats struct {
+	Network string
+	Address string
+	// Active is the number of connections, including the idle and the in-use ones.
+	Active int
+	// Idle is the number of idle connections.
+	Idle int
+	// Waiting is the number of Get calls waiting for a connection.
+	Waiting int
+	// Hits is the number of Get calls served by an idle connection.
+	Hits uint64
+	// Dials is the number of connections dialed, DialErrors is the number of failed ones.
+	Dials      uint64
+	DialErrors uint64
+	// Waits is the number of Get calls which have waited for a connection,
+	// WaitTimeouts is the number of them which failed because ctx is done.
+	Waits        uint64
+	WaitTimeouts uint64
+	// Evicted is the number of idle connections closed by the idle timeout or the liveness check.
+	Evicted uint64
+	// Dropped is the number of connections removed from the pool because they are closed.
+	Dropped uint64
+}
+
+type entry struct {
+	conn  tnet.Conn
+	since time.Time
+	idle  bool
+}
+
+// addrPool is the pool of one address.
+type addrPool struct {
+	network string
+	address string
+	opts    *options
+	dialer  tnet.Dialer
+	timer   *asynctimer.Timer
+
+	mu      sync.Mutex
+	idle    []*entry
+	conns   map[tnet.Conn]*entry
+	active  int
+	waiting int
+	notify  chan struct{}
+	closed  bool
+
+	hits, dials, dialErrors, waits, waitTimeouts, evicted, dropped atomic.Uint64
+}
+
+func newAddrPool(network, address string, opts *options) *addrPool {
+	ap := &addrPool{
+		network: network,
+		address: address,
+		opts:    opts,
+		dialer:  opts.dialer,
+		conns:   make(map[tnet.Conn]*entry),
+		notify:  make(chan struct{}),
+	}
+	// Copy the options to avoid modifying the ones of the user.
+	ap.dialer.Options = append(append([]tnet.Option(nil), opts.dialer.Options...),
+		tnet.WithTCPInterceptors(ap.intercept))
+	if opts.idleTimeout > 0 {
+		ap.timer = asynctimer.NewTimer(ap, onEvictTimer, evictInterval(opts.idleTimeout))
+		if err := asynctimer.Add(ap.timer); err != nil {
+			ap.timer = nil
+		}
+	}
+	return ap
+}
+
+// evictInterval returns the interval of checking the idle connections, so that they are
+// closed within 1.5 times of the idle timeout.
+func evictInterval(idleTimeout time.Duration) time.Duration {
+	if d := idleTimeout / 2; d > time.Second {
+		return d
+	}
+	return time.Second
+}
+
+func (ap *addrPool) get(ctx context.Context) (*Conn, error) {
+	var waited bool
+	for {
+		ap.mu.Lock()
+		if ap.closed {
+			ap.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if e := ap.popIdleLocked(); e != nil {
+			e.idle = false
+			ap.mu.Unlock()
+			ap.hits.Inc()
+			return &Conn{Conn: e.conn, pool: ap}, nil
+		}
+		if ap.opts.maxActive <= 0 || ap.active < ap.opts.maxActive {
+			// Reserve the place before dialing.
+			ap.active++
+			ap.mu.Unlock()
+			return ap.dial(ctx)
+		}
+		if !ap.opts.wait {
+			ap.mu.Unlock()
+			return nil, ErrPoolExhausted
+		}
+		notify := ap.notify
+		ap.waiting++
+		ap.mu.Unlock()
+		if !waited {
+			waited = true
+			ap.waits.Inc()
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			ap.mu.Lock()
+			ap.waiting--
+			ap.mu.Unlock()
+			ap.waitTimeouts.Inc()
+			return nil, ctx.Err()
+		}
+		ap.mu.Lock()
+		ap.waiting--
+		ap.mu.Unlock()
+	}
+}
+
+// popIdleLocked pops an alive idle connection, the broken ones are closed.
+func (ap *addrPool) popIdleLocked() *entry {
+	for len(ap.idle) > 0 {
+		var e *entry
+		if ap.opts.fifo {
+			e = ap.idle[0]
+			ap.idle[0] = nil
+			ap.idle = ap.idle[1:]
+		} else {
+			e = ap.idle[len(ap.idle)-1]
+			ap.idle[len(ap.idle)-1] = nil
+			ap.idle = ap.idle[:len(ap.idle)-1]
+		}
+		if isAlive(e.conn) {
+			return e
+		}
+		ap.removeLocked(e)
+		ap.evicted.Inc()
+		go e.conn.Close()
+	}
+	return nil
+}
+
+// isAlive checks whether conn can be reused. The data received by an idle connection is
+// unexpected, so such connection is not reused either.
+func isAlive(conn tnet.Conn) bool {
+	return conn.IsActive() && conn.Len() == 0
+}
+
+func (ap *addrPool) dial(ctx context.Context) (*Conn, error) {
+	ap.dials.Inc()
+	conn, err := ap.dialer.DialContext(ctx, ap.network, ap.address)
+	if err != nil {
+		ap.dialErrors.Inc()
+		ap.mu.Lock()
+		ap.active--
+		ap.notifyLocked()
+		ap.mu.Unlock()
+		return nil, err
+	}
+	ap.mu.Lock()
+	if ap.closed {
+		ap.active--
+		ap.mu.Unlock()
+		conn.Close()
+		return nil, ErrPoolClosed
+	}
+	ap.conns[conn] = &entry{conn: conn}
+	ap.mu.Unlock()
+	return &Conn{Conn: conn, pool: ap}, nil
+}
+
+// put puts conn back to the pool, it is closed if it is broken or the pool is full.
+func (ap *addrPool) put(conn tnet.Conn) error {
+	ap.mu.Lock()
+	e, ok := ap.conns[conn]
+	if !ok {
+		// Dropped by the pool already.
+		ap.mu.Unlock()
+		return nil
+	}
+	if ap.closed || !isAlive(conn) || len(ap.idle) >= ap.opts.maxIdle {
+		ap.removeLocked(e)
+		ap.mu.Unlock()
+		return conn.Close()
+	}
+	e.idle = true
+	e.since = time.Now()
+	ap.idle = append(ap.idle, e)
+	ap.notifyLocked()
+	ap.mu.Unlock()
+	return nil
+}
+
+// discard removes conn from the pool and closes it.
+func (ap *addrPool) discard(conn tnet.Conn) error {
+	ap.mu.Lock()
+	if e, ok := ap.conns[conn]; ok {
+		ap.removeLocked(e)
+	}
+	ap.mu.Unlock()
+	return conn.Close()
+}
+
+// intercept drops the connection from the pool when it is closed.
+func (ap *addrPool) intercept(conn tnet.Conn, kind tnet.HookKind, next tnet.TCPHandler) error {
+	if kind == tnet.HookClosed {
+		ap.mu.Lock()
+		if e, ok := ap.conns[conn]; ok {
+			ap.removeLocked(e)
+			ap.dropped.Inc()
+		}
+		ap.mu.Unlock()
+	}
+	return next(conn)
+}
+
+// removeLocked removes e from the pool, and wakes up the waiters as there is a free place.
+func (ap *addrPool) removeLocked(e *entry) {
+	delete(ap.conns, e.conn)
+	ap.active--
+	if e.idle {
+		e.idle = false
+		for i, ie := range ap.idle {
+			if ie == e {
+				ap.idle = append(ap.idle[:i], ap.idle[i+1:]...)
+				break
+			}
+		}
+	}
+	ap.notifyLocked()
+}
+
+func (ap *addrPool) notifyLocked() {
+	if ap.waiting == 0 {
+		return
+	}
+	close(ap.notify)
+	ap.notify = make(chan struct{})
+}
+
+func onEvictTimer(data interface{}) {
+	ap, ok := data.(*addrPool)
+	if !ok || ap == nil {
+		return
+	}
+	ap.evict()
+}
+
+// evict closes the idle connections which have been idle for too long or are broken.
+func (ap *addrPool) evict() {
+	var expired []tnet.Conn
+	deadline := time.Now().Add(-ap.opts.idleTimeout)
+	ap.mu.Lock()
+	if ap.closed {
+		ap.mu.Unlock()
+		return
+	}
+	for i := 0; i < len(ap.idle); {
+		e := ap.idle[i]
+		if e.since.After(deadline) && isAlive(e.conn) {
+			i++
+			continue
+		}
+		ap.removeLocked(e)
+		expired = append(expired, e.conn)
+	}
+	// Restart the timer in the lock, so that it doesn't race with close.
+	if err := asynctimer.Add(ap.timer); err != nil {
+		ap.timer = nil
+	}
+	ap.mu.Unlock()
+	ap.evicted.Add(uint64(len(expired)))
+	for _, conn := range expired {
+		conn.Close()
+	}
+}
+
+func (ap *addrPool) close() {
+	ap.mu.Lock()
+	ap.closed = true
+	if ap.timer != nil {
+		asynctimer.Del(ap.timer)
+		ap.timer = nil
+	}
+	idle := ap.idle
+	ap.idle = nil
+	for _, e := range idle {
+		delete(ap.conns, e.conn)
+		ap.active--
+	}
+	// Wake up the waiters to return ErrPoolClosed.
+	close(ap.notify)
+	ap.notify = make(chan struct{})
+	ap.mu.Unlock()
+	for _, e := range idle {
+		e.conn.Close()
+	}
+}
+
+func (ap *addrPool) stats() Stats {
+	ap.mu.Lock()
+	s := Stats{
+		Network: ap.network,
+		Address: ap.address,
+		Active:  ap.active,
+		Idle:    len(ap.idle),
+		Waiting: ap.waiting,
+	}
+	ap.mu.Unlock()
+	s.Hits = ap.hits.Load()
+	s.Dials = ap.dials.Load()
+	s.DialErrors = ap.dialErrors.Load()
+	s.Waits = ap.waits.Load()
+	s.WaitTimeouts = ap.waitTimeouts.Load()
+	s.Evicted = ap.evicted.Load()
+	s.Dropped = ap.dropped.Load()
+	return s
+}
+
+// Conn is a connection got from the Pool. Close puts it back to the pool instead
+// of closing it, use Discard to close it if it can't be reused, for example, when
+// a request on it times out.
+type Conn struct {
+	tnet.Conn
+	pool     *addrPool
+	released atomic.Bool
+}
+
+// Close puts the connection back to the pool. The connection is closed instead if it is
+// closed or has unread data, or the pool has enough idle connections.
+// The Conn must not be used after Close.
+func (c *Conn) Close() error {
+	if !c.released.CAS(false, true) {
+		return nil
+	}
+	return c.pool.put(c.Conn)
+}
+
+// Discard removes the connection from the pool and closes it.
+func (c *Conn) Discard() error {
+	if !c.released.CAS(false, true) {
+		return nil
+	}
+	return c.pool.discard(c.Conn)
+}
@@ -0,0 +1,243 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+	"trpc.group/trpc-go/tnet/pool"
+)
+
+var helloWorld = []byte("helloworld")
+
+// startServer starts an echo server, the accepted connections are sent to peers.
+func startServer(t *testing.T) (string, chan net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { ln.Close() })
+	peers := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			peers <- conn
+			go io.Copy(conn, conn)
+		}
+	}()
+	return ln.Addr().String(), peers
+}
+
+func echo(t *testing.T, conn tnet.Conn) {
+	_, err := conn.Write(helloWorld)
+	require.Nil(t, err)
+	data, err := conn.ReadN(len(helloWorld))
+	require.Nil(t, err)
+	assert.Equal(t, helloWorld, data)
+}
+
+func findStats(p *pool.Pool, address string) pool.Stats {
+	for _, s := range p.Stats() {
+		if s.Address == address {
+			return s
+		}
+	}
+	return pool.Stats{}
+}
+
+func TestPool_Reuse(t *testing.T) {
+	addr, _ := startServer(t)
+	p := pool.New()
+	defer p.Close()
+
+	conn, err := p.Get(context.Background(), "tcp", addr)
+	require.Nil(t, err)
+	echo(t, conn)
+	local := conn.LocalAddr().String()
+	require.Nil(t, conn.Close())
+	// Close twice is harmless.
+	require.Nil(t, conn.Close())
+
+	conn, err = p.Get(context.Background(), "tcp", addr)
+	require.Nil(t, err)
+	assert.Equal(t, local, conn.LocalAddr().String())
+	echo(t, conn)
+	require.Nil(t, conn.Close())
+
+	s := findStats(p, addr)
+	assert.Equal(t, "tcp", s.Network)
+	assert.Equal(t, 1, s.Active)
+	assert.Equal(t, 1, s.Idle)
+	assert.Equal(t, uint64(1), s.Hits)
+	assert.Equal(t, uint64(1), s.Dials)
+
+	require.Nil(t, p.Close())
+	_, err = p.Get(context.Background(), "tcp", addr)
+	assert.Equal(t, pool.ErrPoolClosed, err)
+}
+
+func TestPool_Order(t *testing.T) {
+	addr, _ := startServer(t)
+	for _, fifo := range []bool{false, true} {
+		p := pool.New(pool.WithFIFO(fifo))
+		c1, err := p.Get(context.Background(), "tcp", addr)
+		require.Nil(t, err)
+		c2, err := p.Get(context.Background(), "tcp", addr)
+		require.Nil(t, err)
+		first, last := c1.LocalAddr().String(), c2.LocalAddr().String()
+		require.Nil(t, c1.Close())
+		require.Nil(t, c2.Close())
+
+		conn, err := p.Get(context.Background(), "tcp", addr)
+		require.Nil(t, err)
+		if fifo {
+			assert.Equal(t, first, conn.LocalAddr().String())
+		} else {
+			assert.Equal(t, last, conn.LocalAddr().String())
+		}
+		require.Nil(t, p.Close())
+	}
+}
+
+func TestPool_MaxActive(t *testing.T) {
+	addr, _ := startServer(t)
+	p := pool.New(pool.WithMaxActive(1))
+	defer p.Close()
+
+	conn, err := p.Get(context.Background(), "tcp", addr)
+	require.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = p.Get(ctx, "tcp", addr)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	got := make(chan *pool.Conn)
+	go func() {
+		conn, err := p.Get(context.Background(), "tcp", addr)
+		assert.Nil(t, err)
+		got <- conn
+	}()
+	time.Sleep(50 * time.Millisecond)
+	local := conn.LocalAddr().String()
+	require.Nil(t, conn.Close())
+	select {
+	case conn = <-got:
+		assert.Equal(t, local, conn.LocalAddr().String())
+	case <-time.After(time.Second):
+		t.Fatal("waiting Get is not woken up")
+	}
+
+	// A discarded connection frees the place.
+	require.Nil(t, conn.Discard())
+	conn, err = p.Get(context.Background(), "tcp", addr)
+	require.Nil(t, err)
+	assert.NotEqual(t, local, conn.LocalAddr().String())
+
+	s := findStats(p, addr)
+	assert.Equal(t, 1, s.Active)
+	assert.Equal(t, uint64(2), s.Waits)
+	assert.Equal(t, uint64(1), s.WaitTimeouts)
+	assert.Equal(t, uint64(2), s.Dials)
+
+	np := pool.New(pool.WithMaxActive(1), pool.WithWait(false))
+	defer np.Close()
+	_, err = np.Get(context.Background(), "tcp", addr)
+	require.Nil(t, err)
+	_, err = np.Get(context.Background(), "tcp", addr)
+	assert.Equal(t, pool.ErrPoolExhausted, err)
+}
+
+func TestPool_DropClosed(t *testing.T) {
+	addr, peers := startServer(t)
+	closed := make(chan struct{}, 1)
+	p := pool.New(pool.WithDialer(tnet.Dialer{Options: []tnet.Option{
+		tnet.WithOnTCPClosed(func(tnet.Conn) error {
+			closed <- struct{}{}
+			return nil
+		}),
+	}}))
+	defer p.Close()
+
+	conn, err := p.Get(context.Background(), "tcp", addr)
+	require.Nil(t, err)
+	require.Nil(t, conn.Close())
+	// The peer closes the idle connection.
+	(<-peers).Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnTCPClosed of the user is not called")
+	}
+	s := findStats(p, addr)
+	assert.Equal(t, 0, s.Active)
+	assert.Equal(t, 0, s.Idle)
+	assert.Equal(t, uint64(1), s.Dropped)
+}
+
+func TestPool_Liveness(t *testing.T) {
+	addr, peers := startServer(t)
+	p := pool.New()
+	defer p.Close()
+
+	conn, err := p.Get(context.Background(), "tcp", addr)
+	require.Nil(t, err)
+	local := conn.LocalAddr().String()
+	require.Nil(t, conn.Close())
+	// Unexpected data arrives at the idle connection.
+	_, err = (<-peers).Write(helloWorld)
+	require.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err = p.Get(context.Background(), "tcp", addr)
+	require.Nil(t, err)
+	assert.NotEqual(t, local, conn.LocalAddr().String())
+	s := findStats(p, addr)
+	assert.Equal(t, uint64(1), s.Evicted)
+	assert.Equal(t, uint64(0), s.Hits)
+}
+
+func TestPool_IdleTimeout(t *testing.T) {
+	t.Parallel()
+	addr, _ := startServer(t)
+	p := pool.New(pool.WithIdleTimeout(time.Second), pool.WithMaxIdle(1))
+	defer p.Close()
+
+	c1, err := p.Get(context.Background(), "tcp", addr)
+	require.Nil(t, err)
+	c2, err := p.Get(context.Background(), "tcp", addr)
+	require.Nil(t, err)
+	require.Nil(t, c1.Close())
+	// The pool has enough idle connections, c2 is closed.
+	require.Nil(t, c2.Close())
+	assert.False(t, c2.IsActive())
+	s := findStats(p, addr)
+	assert.Equal(t, 1, s.Active)
+	assert.Equal(t, 1, s.Idle)
+
+	time.Sleep(4 * time.Second)
+	assert.False(t, c1.IsActive())
+	s = findStats(p, addr)
+	assert.Equal(t, 0, s.Active)
+	assert.Equal(t, 0, s.Idle)
+	assert.Equal(t, uint64(1), s.Evicted)
+}
@@ -0,0 +1,126 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package protomux
+
+import (
+	"bytes"
+)
+
+// Result is the result of matching the first bytes of a connection.
+type Result int
+
+const (
+	// NoMatch means that the connection is not of the protocol.
+	NoMatch Result = iota
+	// Match means that the connection is of the protocol.
+	Match
+	// NeedMore means that more bytes are needed to decide.
+	NeedMore
+)
+
+// Matcher matches the first bytes of a connection received so far. It is called again
+// with more bytes if it returns NeedMore, and b must not be kept after it returns.
+type Matcher func(b []byte) Result
+
+// Any matches all connections.
+func Any() Matcher {
+	return func([]byte) Result { return Match }
+}
+
+// Prefix matches the connections starting with one of the prefixes, such as the magic
+// number of a binary protocol.
+func Prefix(prefixes ...[]byte) Matcher {
+	return func(b []byte) Result {
+		result := NoMatch
+		for _, p := range prefixes {
+			switch {
+			case bytes.HasPrefix(b, p):
+				return Match
+			case bytes.HasPrefix(p, b):
+				result = NeedMore
+			}
+		}
+		return result
+	}
+}
+
+// TLS matches the connections starting with a TLS handshake record.
+func TLS() Matcher {
+	return func(b []byte) Result {
+		const recordTypeHandshake, versionMajor = 0x16, 0x03
+		switch {
+		case len(b) == 0:
+			return NeedMore
+		case b[0] != recordTypeHandshake:
+			return NoMatch
+		case len(b) == 1:
+			return NeedMore
+		case b[1] != versionMajor:
+			return NoMatch
+		default:
+			return Match
+		}
+	}
+}
+
+var httpMethods = [][]byte{
+	[]byte("GET "),
+	[]byte("HEAD "),
+	[]byte("POST "),
+	[]byte("PUT "),
+	[]byte("DELETE "),
+	[]byte("CONNECT "),
+	[]byte("OPTIONS "),
+	[]byte("TRACE "),
+	[]byte("PATCH "),
+}
+
+// HTTP1 matches the connections starting with an HTTP/1.x request method. The WebSocket
+// handshake is an HTTP request too, so the WebSocket route must be added before it.
+func HTTP1() Matcher {
+	return Prefix(httpMethods...)
+}
+
+// HTTP2 matches the connections starting with the HTTP/2 client connection preface.
+func HTTP2() Matcher {
+	return Prefix([]byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"))
+}
+
+// WebSocket matches the connections starting with an HTTP GET request which has the header
+// "Upgrade: websocket". The whole request header must be received to decide.
+func WebSocket() Matcher {
+	get := Prefix([]byte("GET "))
+	return func(b []byte) Result {
+		if r := get(b); r != Match {
+			return r
+		}
+		end := bytes.Index(b, []byte("\r\n\r\n"))
+		if end < 0 {
+			return NeedMore
+		}
+		lines := bytes.Split(b[:end], []byte("\r\n"))
+		for _, line := range lines[1:] {
+			i := bytes.IndexByte(line, ':')
+			if i < 0 || !bytes.EqualFold(bytes.TrimSpace(line[:i]), []byte("Upgrade")) {
+				continue
+			}
+			for _, v := range bytes.Split(line[i+1:], []byte(",")) {
+				if bytes.EqualFold(bytes.TrimSpace(v), []byte("websocket")) {
+					return Match
+				}
+			}
+		}
+		return NoMatch
+	}
+}
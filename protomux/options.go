@@ -0,0 +1,95 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package protomux
+
+import (
+	"time"
+
+	"trpc.group/trpc-go/tnet"
+)
+
+const (
+	defaultSniffTimeout  = 10 * time.Second
+	defaultMaxSniffBytes = 4096
+)
+
+// Handler serves the connections dispatched to a route.
+type Handler = tnet.TCPHandlers
+
+type route struct {
+	matcher Matcher
+	handler Handler
+}
+
+type options struct {
+	routes        []route
+	fallback      *Handler
+	sniffTimeout  time.Duration
+	maxSniffBytes int
+	tcpOptions    []tnet.Option
+}
+
+func (o *options) setDefault() {
+	o.sniffTimeout = defaultSniffTimeout
+	o.maxSniffBytes = defaultMaxSniffBytes
+}
+
+// Option is the option of the mux service.
+type Option func(*options)
+
+// WithRoute adds a route. The routes are matched in the order they are added, a connection
+// is dispatched to the first route of which the matcher returns Match, as soon as all the
+// routes before it return NoMatch.
+func WithRoute(m Matcher, h Handler) Option {
+	return func(o *options) {
+		o.routes = append(o.routes, route{matcher: m, handler: h})
+	}
+}
+
+// WithFallback sets the handler of the connections matching no route. It also serves the
+// connections sending nothing within the sniff timeout, so that the protocols in which the
+// server speaks first can be served. Without fallback, these connections are closed.
+func WithFallback(h Handler) Option {
+	return func(o *options) {
+		o.fallback = &h
+	}
+}
+
+// WithSniffTimeout sets how long to wait for the bytes deciding the route, default is 10s.
+// If d <= 0, there is no timeout. The precision is 1 second, a shorter d is taken as 1 second.
+func WithSniffTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 && d < time.Second {
+			d = time.Second
+		}
+		o.sniffTimeout = d
+	}
+}
+
+// WithMaxSniffBytes sets the max number of bytes peeked to decide the route, default is 4096.
+// The matchers still returning NeedMore with that many bytes are regarded as NoMatch.
+func WithMaxSniffBytes(n int) Option {
+	return func(o *options) {
+		o.maxSniffBytes = n
+	}
+}
+
+// WithTCPOptions sets the options of the underlying tcp service, such as tnet.WithTCPKeepAlive
+// and tnet.WithProxyProtocol. tnet.WithOnTCPOpened and tnet.WithOnTCPClosed are ignored, use
+// the Handler of the routes instead.
+func WithTCPOptions(opt ...tnet.Option) Option {
+	return func(o *options) {
+		o.tcpOptions = append(o.tcpOptions, opt...)
+	}
+}
@@ -0,0 +1,215 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+// Package protomux provides a tcp service serving several protocols on one listener, which
+// sniffs the first bytes of each connection and dispatches it to the handler of the protocol.
+package protomux
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"go.uber.org/atomic"
+	"trpc.group/trpc-go/tnet"
+	"trpc.group/trpc-go/tnet/internal/asynctimer"
+	"trpc.group/trpc-go/tnet/log"
+)
+
+var (
+	// ErrNoRoute is returned when a connection matches no route and there is no fallback.
+	ErrNoRoute   = errors.New("protomux: no route matches the connection")
+	errNoSniffer = errors.New("protomux: connection is not opened by the mux")
+)
+
+// NewService creates a tcp service which dispatches each connection to the route matching
+// its first bytes, see WithRoute and WithFallback. The bytes are only peeked, so the handler
+// of the route reads the connection from the beginning.
+func NewService(ln net.Listener, opt ...Option) (tnet.Service, error) {
+	opts := options{}
+	opts.setDefault()
+	for _, o := range opt {
+		o(&opts)
+	}
+	if len(opts.routes) == 0 && opts.fallback == nil {
+		return nil, errors.New("protomux: no route")
+	}
+	for _, r := range opts.routes {
+		if r.matcher == nil || r.handler.OnRequest == nil {
+			return nil, errors.New("protomux: matcher or OnRequest of the route is nil")
+		}
+	}
+	if opts.fallback != nil && opts.fallback.OnRequest == nil {
+		return nil, errors.New("protomux: OnRequest of the fallback is nil")
+	}
+	m := &mux{opts: opts}
+	tcpOpts := append(append([]tnet.Option(nil), opts.tcpOptions...),
+		tnet.WithOnTCPOpened(m.onOpened),
+		tnet.WithOnTCPClosed(m.onClosed),
+	)
+	return tnet.NewTCPService(ln, m.onRequest, tcpOpts...)
+}
+
+type mux struct {
+	opts     options
+	sniffers sync.Map // tnet.Conn -> *sniffer
+}
+
+func (m *mux) onOpened(conn tnet.Conn) error {
+	s := &sniffer{m: m, conn: conn}
+	if m.opts.sniffTimeout > 0 {
+		s.timer = asynctimer.NewTimer(s, onSniffTimeout, m.opts.sniffTimeout)
+	}
+	// The timer is set before the sniffer is visible to onRequest and onTimeout.
+	m.sniffers.Store(conn, s)
+	if s.timer == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handler.Load() != nil {
+		return nil
+	}
+	return asynctimer.Add(s.timer)
+}
+
+func (m *mux) onRequest(conn tnet.Conn) error {
+	v, ok := m.sniffers.Load(conn)
+	if !ok {
+		return errNoSniffer
+	}
+	return v.(*sniffer).handle()
+}
+
+func (m *mux) onClosed(conn tnet.Conn) error {
+	v, ok := m.sniffers.LoadAndDelete(conn)
+	if !ok {
+		return nil
+	}
+	s := v.(*sniffer)
+	if s.timer != nil {
+		asynctimer.Del(s.timer)
+	}
+	if h := s.handler.Load(); h != nil && h.OnClosed != nil {
+		return h.OnClosed(conn)
+	}
+	return nil
+}
+
+// sniffer decides the route of a connection.
+type sniffer struct {
+	m    *mux
+	conn tnet.Conn
+	// mu serializes sniffing and the first request handled by the dispatched handler.
+	mu         sync.Mutex
+	handler    atomic.Pointer[Handler]
+	dispatched atomic.Bool
+	timer      *asynctimer.Timer
+}
+
+func (s *sniffer) handle() error {
+	if s.dispatched.Load() {
+		return s.handler.Load().OnRequest(s.conn)
+	}
+	s.mu.Lock()
+	h := s.handler.Load()
+	if h == nil {
+		var err error
+		if h, err = s.sniff(); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		if err := s.dispatch(h); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
+	s.dispatched.Store(true)
+	s.mu.Unlock()
+	return h.OnRequest(s.conn)
+}
+
+// sniff returns the handler of the first matching route, or EAGAIN if more bytes are needed.
+func (s *sniffer) sniff() (*Handler, error) {
+	opts := &s.m.opts
+	n := s.conn.Len()
+	if n > opts.maxSniffBytes {
+		n = opts.maxSniffBytes
+	}
+	b, err := s.conn.Peek(n)
+	if err != nil {
+		return nil, err
+	}
+	for i := range opts.routes {
+		switch opts.routes[i].matcher(b) {
+		case Match:
+			return &opts.routes[i].handler, nil
+		case NeedMore:
+			if n < opts.maxSniffBytes {
+				return nil, tnet.EAGAIN
+			}
+		}
+	}
+	if opts.fallback != nil {
+		return opts.fallback, nil
+	}
+	return nil, ErrNoRoute
+}
+
+func (s *sniffer) dispatch(h *Handler) error {
+	if s.timer != nil {
+		asynctimer.Del(s.timer)
+	}
+	s.handler.Store(h)
+	if h.OnOpened != nil {
+		return h.OnOpened(s.conn)
+	}
+	return nil
+}
+
+func onSniffTimeout(data interface{}) {
+	if s, ok := data.(*sniffer); ok && s != nil {
+		s.onTimeout()
+	}
+}
+
+// onTimeout dispatches the connection to the fallback, or closes it.
+func (s *sniffer) onTimeout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handler.Load() != nil || !s.conn.IsActive() {
+		return
+	}
+	h := s.m.opts.fallback
+	if h == nil {
+		log.Debugf("protomux: connection from %s is closed by sniff timeout", s.conn.RemoteAddr())
+		s.conn.Close()
+		return
+	}
+	if err := s.dispatch(h); err != nil {
+		s.conn.Close()
+		return
+	}
+	// Otherwise the bytes received before the timeout are not handled until more bytes arrive.
+	for s.conn.Len() > 0 && s.conn.IsActive() {
+		err := h.OnRequest(s.conn)
+		if errors.Is(err, tnet.EAGAIN) {
+			break
+		}
+		if err != nil {
+			s.conn.Close()
+			return
+		}
+	}
+	s.dispatched.Store(true)
+}
@@ -0,0 +1,265 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package protomux_test
+
+import (
+	"bufio"
+	"context"
+	stdtls "crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+	"trpc.group/trpc-go/tnet/protomux"
+	"trpc.group/trpc-go/tnet/tls"
+)
+
+var (
+	magic    = []byte{0x09, 0x30}
+	greeting = []byte("greeting")
+)
+
+func echo(conn tnet.Conn) error {
+	b, err := conn.Next(conn.Len())
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(b)
+	return err
+}
+
+func startService(t *testing.T, opt ...protomux.Option) string {
+	ln, err := tnet.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	s, err := protomux.NewService(ln, opt...)
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Serve(ctx)
+	return ln.Addr().String()
+}
+
+func startMux(t *testing.T, opt ...protomux.Option) string {
+	cert, err := stdtls.LoadX509KeyPair("../tls/testdata/server.crt", "../tls/testdata/server.key")
+	require.Nil(t, err)
+	opt = append([]protomux.Option{
+		protomux.WithRoute(protomux.TLS(), tls.NewHandler(func(c tls.Conn) error {
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(c, buf); err != nil {
+				return err
+			}
+			_, err := c.Write(buf)
+			return err
+		}, tls.WithServerTLSConfig(&stdtls.Config{Certificates: []stdtls.Certificate{cert}}))),
+		protomux.WithRoute(protomux.Prefix(magic), protomux.Handler{OnRequest: echo}),
+		protomux.WithRoute(protomux.HTTP1(), protomux.Handler{OnRequest: func(conn tnet.Conn) error {
+			req, err := http.ReadRequest(bufio.NewReader(conn))
+			if err != nil {
+				return err
+			}
+			body := "path " + req.URL.Path
+			_, err = conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: " +
+				strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+			return err
+		}}),
+	}, opt...)
+	return startService(t, opt...)
+}
+
+func readFull(t *testing.T, conn net.Conn, n int) []byte {
+	b := make([]byte, n)
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(3*time.Second)))
+	_, err := io.ReadFull(conn, b)
+	require.Nil(t, err)
+	return b
+}
+
+func TestService_Routes(t *testing.T) {
+	addr := startMux(t)
+
+	// tls
+	tc, err := stdtls.Dial("tcp", addr, &stdtls.Config{InsecureSkipVerify: true})
+	require.Nil(t, err)
+	_, err = tc.Write([]byte("hello"))
+	require.Nil(t, err)
+	assert.Equal(t, []byte("hello"), readFull(t, tc, 5))
+	require.Nil(t, tc.Close())
+
+	// The magic number, sent byte by byte.
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	_, err = conn.Write(magic[:1])
+	require.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	_, err = conn.Write(append(magic[1:], 'x'))
+	require.Nil(t, err)
+	assert.Equal(t, append(magic, 'x'), readFull(t, conn, 3))
+	require.Nil(t, conn.Close())
+
+	// http
+	client := http.Client{Timeout: time.Second}
+	resp, err := client.Get("http://" + addr + "/foo")
+	require.Nil(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "path /foo", string(body))
+}
+
+func TestService_NoRoute(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	addr := startMux(t, protomux.WithTCPOptions(tnet.WithOnTCPClosed(func(tnet.Conn) error {
+		closed <- struct{}{}
+		return nil
+	})))
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("unknown"))
+	require.Nil(t, err)
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(3*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	// The OnTCPClosed of the tcp options is ignored.
+	assert.Empty(t, closed)
+}
+
+func TestService_Fallback(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	addr := startMux(t,
+		protomux.WithSniffTimeout(time.Second),
+		protomux.WithFallback(protomux.Handler{
+			OnOpened: func(conn tnet.Conn) error {
+				_, err := conn.Write(greeting)
+				return err
+			},
+			OnRequest: echo,
+			OnClosed: func(tnet.Conn) error {
+				closed <- struct{}{}
+				return nil
+			},
+		}))
+
+	// The server speaks first after the sniff timeout.
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	assert.Equal(t, greeting, readFull(t, conn, len(greeting)))
+	_, err = conn.Write([]byte("hello"))
+	require.Nil(t, err)
+	assert.Equal(t, []byte("hello"), readFull(t, conn, 5))
+	require.Nil(t, conn.Close())
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnClosed of the fallback is not called")
+	}
+
+	// No route matches.
+	conn, err = net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("unknown"))
+	require.Nil(t, err)
+	assert.Equal(t, append(greeting, "unknown"...), readFull(t, conn, len(greeting)+7))
+
+	// A partial magic number is dispatched to the fallback after the timeout.
+	conn, err = net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(magic[:1])
+	require.Nil(t, err)
+	assert.Equal(t, append(greeting, magic[0]), readFull(t, conn, len(greeting)+1))
+}
+
+func TestService_MaxSniffBytes(t *testing.T) {
+	long := []byte("0123456789abcdef")
+	addr := startService(t,
+		protomux.WithMaxSniffBytes(8),
+		protomux.WithRoute(protomux.Prefix(long), protomux.Handler{OnRequest: func(tnet.Conn) error {
+			return errors.New("unexpected route")
+		}}),
+		protomux.WithFallback(protomux.Handler{OnRequest: echo}))
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	// The prefix can't be decided within 8 bytes, so the fallback is used without waiting.
+	_, err = conn.Write(long[:10])
+	require.Nil(t, err)
+	assert.Equal(t, long[:10], readFull(t, conn, 10))
+}
+
+func TestService_SniffTimeout(t *testing.T) {
+	addr := startMux(t, protomux.WithSniffTimeout(time.Second))
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	// A partial magic number is not enough to decide.
+	_, err = conn.Write(magic[:1])
+	require.Nil(t, err)
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(3*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestNewService_Error(t *testing.T) {
+	ln, err := tnet.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	_, err = protomux.NewService(ln)
+	assert.NotNil(t, err)
+	_, err = protomux.NewService(ln, protomux.WithRoute(protomux.Any(), protomux.Handler{}))
+	assert.NotNil(t, err)
+	_, err = protomux.NewService(ln, protomux.WithFallback(protomux.Handler{}))
+	assert.NotNil(t, err)
+}
+
+func TestMatchers(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		matcher protomux.Matcher
+		data    string
+		want    protomux.Result
+	}{
+		{"tls empty", protomux.TLS(), "", protomux.NeedMore},
+		{"tls partial", protomux.TLS(), "\x16", protomux.NeedMore},
+		{"tls", protomux.TLS(), "\x16\x03\x01", protomux.Match},
+		{"tls bad version", protomux.TLS(), "\x16\x02", protomux.NoMatch},
+		{"not tls", protomux.TLS(), "GET", protomux.NoMatch},
+		{"http partial", protomux.HTTP1(), "PO", protomux.NeedMore},
+		{"http", protomux.HTTP1(), "POST / HTTP/1.1\r\n", protomux.Match},
+		{"not http", protomux.HTTP1(), "POSTX", protomux.NoMatch},
+		{"http2", protomux.HTTP2(), "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", protomux.Match},
+		{"http2 partial", protomux.HTTP2(), "PRI * HTTP", protomux.NeedMore},
+		{"websocket partial", protomux.WebSocket(), "GET / HTTP/1.1\r\nUpgrade: websocket\r\n", protomux.NeedMore},
+		{"websocket", protomux.WebSocket(),
+			"GET / HTTP/1.1\r\nHost: a\r\nupgrade: WebSocket\r\n\r\n", protomux.Match},
+		{"websocket list", protomux.WebSocket(),
+			"GET / HTTP/1.1\r\nUpgrade: foo, websocket\r\n\r\n", protomux.Match},
+		{"plain get", protomux.WebSocket(), "GET / HTTP/1.1\r\nHost: a\r\n\r\n", protomux.NoMatch},
+		{"websocket post", protomux.WebSocket(), "POST / HTTP/1.1\r\n", protomux.NoMatch},
+		{"prefix", protomux.Prefix([]byte("ab"), []byte("cd")), "cde", protomux.Match},
+		{"any", protomux.Any(), "", protomux.Match},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.matcher([]byte(tt.data)))
+		})
+	}
+}
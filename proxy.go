@@ -0,0 +1,372 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	socks5Version       = 0x05
+	socks5AuthNone      = 0x00
+	socks5AuthPassword  = 0x02
+	socks5AuthNoAccept  = 0xff
+	socks5PasswordVer   = 0x01
+	socks5CmdConnect    = 0x01
+	socks5AddrIPv4      = 0x01
+	socks5AddrDomain    = 0x03
+	socks5AddrIPv6      = 0x04
+	maxProxyHeaderBytes = 8 << 10
+)
+
+// defaultProxyHandshakeTimeout bounds the handshake with the proxy when neither Dialer.Timeout
+// nor the deadline of the ctx is set, so that a proxy which never replies doesn't hang the dial.
+var defaultProxyHandshakeTimeout = 30 * time.Second
+
+var socks5Replies = [...]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// ProxyURL returns a proxy function for Dialer.Proxy that always returns the same URL.
+func ProxyURL(proxy *url.URL) func(address string) (*url.URL, error) {
+	return func(string) (*url.URL, error) {
+		return proxy, nil
+	}
+}
+
+// ProxyFromEnvironment is a proxy function for Dialer.Proxy that returns the proxy from the
+// environment variables ALL_PROXY or HTTP_PROXY (or the lowercase versions thereof), in that
+// order, unless the address matches NO_PROXY. NO_PROXY is a comma-separated list of hosts,
+// domain suffixes, IPs and CIDRs, optionally with ports, "*" matches all. The loopback
+// addresses are never proxied. A proxy without scheme is treated as http.
+func ProxyFromEnvironment(address string) (*url.URL, error) {
+	proxy := getEnvAny("ALL_PROXY", "all_proxy")
+	if proxy == "" {
+		proxy = getEnvAny("HTTP_PROXY", "http_proxy")
+	}
+	if proxy == "" || !useProxy(address, getEnvAny("NO_PROXY", "no_proxy")) {
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		// The proxy may be like "host:port".
+		if u, err = url.Parse("http://" + proxy); err != nil {
+			return nil, fmt.Errorf("invalid proxy address %q: %w", proxy, err)
+		}
+	}
+	return u, nil
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// useProxy reports whether the address should be connected through the proxy by noProxy.
+func useProxy(address, noProxy string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	host = strings.ToLower(host)
+	if host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return false
+	}
+	for _, entry := range strings.Split(strings.ToLower(noProxy), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return false
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return false
+			}
+			continue
+		}
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+		if entryIP := net.ParseIP(entry); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return false
+			}
+			continue
+		}
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialProxy connects to the proxy, and asks it to connect to address. The handshake is done
+// before the connection is opened, so the connection looks the same as a direct one to users,
+// except that its RemoteAddr is the address of the proxy.
+func (d *Dialer) dialProxy(ctx context.Context, network, address string, proxy *url.URL, opts *options) (Conn, error) {
+	var handshake func(context.Context, *tcpconn, *url.URL, string) error
+	var defaultPort string
+	switch proxy.Scheme {
+	case "socks5":
+		handshake, defaultPort = socks5LocalHandshake, "1080"
+	case "socks5h":
+		handshake, defaultPort = socks5Handshake, "1080"
+	case "http":
+		handshake, defaultPort = httpConnectHandshake, "80"
+	default:
+		return nil, &net.OpError{Op: "proxyconnect", Net: network, Source: d.LocalAddr,
+			Err: fmt.Errorf("unsupported proxy scheme %q", proxy.Scheme)}
+	}
+	proxyAddress := proxy.Host
+	if proxy.Port() == "" {
+		proxyAddress = net.JoinHostPort(proxy.Hostname(), defaultPort)
+	}
+	fd, laddr, raddr, err := d.connect(ctx, network, proxyAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := scheduleTCPConn(fd, network, laddr, raddr, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultProxyHandshakeTimeout)
+		defer cancel()
+	}
+	if err := handshake(ctx, conn, proxy, address); err != nil {
+		conn.closeWithReason(CloseReasonIOError)
+		return nil, &net.OpError{Op: "proxyconnect", Net: network, Source: laddr, Addr: raddr, Err: err}
+	}
+	if d.ProxyHeader != nil {
+		if err := d.writeProxyHeader(conn); err != nil {
+			conn.closeWithReason(CloseReasonIOError)
+			return nil, &net.OpError{Op: "dial", Net: network, Source: laddr, Addr: raddr, Err: err}
+		}
+	}
+	if err := conn.openScheduled(opts); err != nil {
+		return nil, err
+	}
+	// The data sent by the target right after the handshake is received before the handler is set.
+	conn.triggerRequest()
+	return conn, nil
+}
+
+// socks5LocalHandshake resolves the host of address locally, and asks the SOCKS5 proxy to
+// connect to the first resolved IP.
+func socks5LocalHandshake(ctx context.Context, conn *tcpconn, proxy *url.URL, address string) error {
+	addrs, err := resolveTCPAddrs(ctx, conn.nfd.network, address)
+	if err != nil {
+		return err
+	}
+	return socks5Handshake(ctx, conn, proxy, addrs[0].String())
+}
+
+// socks5Handshake asks the SOCKS5 proxy to connect to address, see RFC 1928 and RFC 1929.
+// The host of address is sent to the proxy to resolve if it is not an IP.
+func socks5Handshake(ctx context.Context, conn *tcpconn, proxy *url.URL, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	method := byte(socks5AuthNone)
+	if proxy.User != nil {
+		method = socks5AuthPassword
+	}
+	if _, err := conn.WritevContext(ctx, []byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+	b, err := conn.ReadNContext(ctx, 2)
+	if err != nil {
+		return err
+	}
+	if b[0] != socks5Version {
+		return fmt.Errorf("unexpected socks version %d", b[0])
+	}
+	switch b[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if proxy.User == nil {
+			return errors.New("socks5 proxy requires authentication")
+		}
+		if err := socks5Authenticate(ctx, conn, proxy.User); err != nil {
+			return err
+		}
+	case socks5AuthNoAccept:
+		return errors.New("no acceptable socks5 authentication methods")
+	default:
+		return fmt.Errorf("unsupported socks5 authentication method %d", b[1])
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host %q is too long", host)
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5AddrIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5AddrIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.WritevContext(ctx, req); err != nil {
+		return err
+	}
+	if b, err = conn.ReadNContext(ctx, 4); err != nil {
+		return err
+	}
+	if b[0] != socks5Version {
+		return fmt.Errorf("unexpected socks version %d", b[0])
+	}
+	if b[1] != 0 {
+		if int(b[1]) < len(socks5Replies) && socks5Replies[b[1]] != "" {
+			return fmt.Errorf("socks5 connect error: %s", socks5Replies[b[1]])
+		}
+		return fmt.Errorf("socks5 connect error: unknown code %d", b[1])
+	}
+	// Skip the bound address.
+	var n int
+	switch b[3] {
+	case socks5AddrIPv4:
+		n = net.IPv4len
+	case socks5AddrIPv6:
+		n = net.IPv6len
+	case socks5AddrDomain:
+		if b, err = conn.ReadNContext(ctx, 1); err != nil {
+			return err
+		}
+		n = int(b[0])
+	default:
+		return fmt.Errorf("unknown socks5 address type %d", b[3])
+	}
+	_, err = conn.ReadNContext(ctx, n+2)
+	return err
+}
+
+func socks5Authenticate(ctx context.Context, conn *tcpconn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("socks5 username or password is too long")
+	}
+	req := []byte{socks5PasswordVer, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.WritevContext(ctx, req); err != nil {
+		return err
+	}
+	b, err := conn.ReadNContext(ctx, 2)
+	if err != nil {
+		return err
+	}
+	if b[0] != socks5PasswordVer {
+		return fmt.Errorf("unexpected socks5 authentication version %d", b[0])
+	}
+	if b[1] != 0 {
+		return errors.New("socks5 authentication failed")
+	}
+	return nil
+}
+
+// httpConnectHandshake asks the HTTP proxy to connect to address by the CONNECT method.
+func httpConnectHandshake(ctx context.Context, conn *tcpconn, proxy *url.URL, address string) error {
+	var req bytes.Buffer
+	fmt.Fprintf(&req, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		fmt.Fprintf(&req, "Proxy-Authorization: Basic %s\r\n", auth)
+	}
+	req.WriteString("\r\n")
+	if _, err := conn.WritevContext(ctx, req.Bytes()); err != nil {
+		return err
+	}
+
+	// Read exactly the response header, the following data belongs to the target.
+	var header []byte
+	for n := 1; ; n = len(header) + 1 {
+		if n > maxProxyHeaderBytes {
+			return errors.New("proxy response header is too large")
+		}
+		if l := conn.Len(); l > n {
+			n = l
+		}
+		b, err := conn.PeekContext(ctx, n)
+		if err != nil {
+			return err
+		}
+		if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+			header = append(header[:0], b[:i]...)
+			if err := conn.Skip(i + 4); err != nil {
+				return err
+			}
+			conn.Release()
+			break
+		}
+		header = append(header[:0], b...)
+	}
+	statusLine := string(header)
+	if i := strings.Index(statusLine, "\r\n"); i >= 0 {
+		statusLine = statusLine[:i]
+	}
+	fields := strings.SplitN(statusLine, " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/") {
+		return fmt.Errorf("malformed proxy response %q", statusLine)
+	}
+	if fields[1] != "200" {
+		return fmt.Errorf("proxy connect error: %s", strings.Join(fields[1:], " "))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialer_ProxyHandshakeTimeout(t *testing.T) {
+	// The proxy accepts the connections but never replies.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	old := defaultProxyHandshakeTimeout
+	defaultProxyHandshakeTimeout = 50 * time.Millisecond
+	defer func() { defaultProxyHandshakeTimeout = old }()
+	for _, scheme := range []string{"socks5", "http"} {
+		d := Dialer{Proxy: ProxyURL(&url.URL{Scheme: scheme, Host: ln.Addr().String()})}
+		start := time.Now()
+		_, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:80")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), scheme)
+		assert.Less(t, time.Since(start), time.Second, scheme)
+	}
+}
@@ -0,0 +1,423 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+	"trpc.group/trpc-go/tnet/internal/asynctimer"
+)
+
+// ProxyProtocolMode is whether the tcp service reads the PROXY protocol header.
+type ProxyProtocolMode int
+
+const (
+	// ProxyProtocolDisabled means that no PROXY protocol header is read, which is the default.
+	ProxyProtocolDisabled ProxyProtocolMode = iota
+	// ProxyProtocolOptional means that the header is read if the connection starts with one.
+	// If the connection sends nothing, it is opened without header after the header timeout,
+	// so the protocols in which the server speaks first should use a short timeout.
+	ProxyProtocolOptional
+	// ProxyProtocolRequired means that the connection must start with the header, otherwise
+	// it is closed with CloseReasonProxyHeader.
+	ProxyProtocolRequired
+)
+
+const (
+	defaultProxyHeaderTimeout = 10 * time.Second
+	// maxProxyHeaderV1Len is the max length of the v1 header including CRLF.
+	maxProxyHeaderV1Len = 107
+	proxyHeaderV2Len    = 16
+)
+
+// The types of the TLVs of the PROXY protocol v2 header.
+const (
+	ProxyTLVTypeALPN      = 0x01
+	ProxyTLVTypeAuthority = 0x02
+	ProxyTLVTypeCRC32C    = 0x03
+	ProxyTLVTypeNoop      = 0x04
+	ProxyTLVTypeUniqueID  = 0x05
+	ProxyTLVTypeSSL       = 0x20
+	ProxyTLVTypeNetNS     = 0x30
+)
+
+var (
+	proxyHeaderV1Sig = []byte("PROXY ")
+	proxyHeaderV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	errNoProxyHeader = errors.New("no proxy header")
+)
+
+// ProxyHeader is the PROXY protocol header, see https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt.
+type ProxyHeader struct {
+	// Version is 1 for the text header, or 2 for the binary header.
+	Version int
+	// Local is true for the v2 LOCAL command, which is sent by the balancer itself such as
+	// health checks, so the addresses of the connection are kept.
+	Local bool
+	// SourceAddr is the address of the client, it is nil if the addresses are unknown.
+	SourceAddr net.Addr
+	// DestAddr is the address the client connects to.
+	DestAddr net.Addr
+	// TLVs are the type-length-value extensions of the v2 header.
+	TLVs []ProxyTLV
+}
+
+// ProxyTLV is a type-length-value extension of the PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeaderOf returns the PROXY protocol header read by the connection of the tcp service,
+// or nil if there is none.
+func ProxyHeaderOf(conn Conn) *ProxyHeader {
+	tc, ok := conn.(*tcpconn)
+	if !ok || tc == nil {
+		return nil
+	}
+	return tc.proxyHeader
+}
+
+// Encode encodes the header. The v1 header is "PROXY UNKNOWN" if the addresses are not tcp
+// addresses of the same family, and the TLVs are only encoded in the v2 header.
+func (h *ProxyHeader) Encode() ([]byte, error) {
+	src, srcOK := h.SourceAddr.(*net.TCPAddr)
+	dst, dstOK := h.DestAddr.(*net.TCPAddr)
+	known := srcOK && dstOK && !h.Local && (src.IP.To4() == nil) == (dst.IP.To4() == nil)
+	switch h.Version {
+	case 1:
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		proto := "TCP4"
+		if src.IP.To4() == nil {
+			proto = "TCP6"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, src.IP, dst.IP, src.Port, dst.Port)), nil
+	case 2:
+		b := append([]byte(nil), proxyHeaderV2Sig...)
+		cmd, fam := byte(0x20), byte(0x00)
+		if !h.Local {
+			cmd |= 0x01
+		}
+		var addrs []byte
+		if known {
+			if ip4 := src.IP.To4(); ip4 != nil {
+				fam = 0x11
+				addrs = append(append(addrs, ip4...), dst.IP.To4()...)
+			} else {
+				fam = 0x21
+				addrs = append(append(addrs, src.IP.To16()...), dst.IP.To16()...)
+			}
+			addrs = appendUint16(addrs, uint16(src.Port))
+			addrs = appendUint16(addrs, uint16(dst.Port))
+		}
+		for _, tlv := range h.TLVs {
+			if len(tlv.Value) > 0xffff {
+				return nil, fmt.Errorf("proxy header TLV %#x is too long", tlv.Type)
+			}
+			addrs = append(addrs, tlv.Type)
+			addrs = appendUint16(addrs, uint16(len(tlv.Value)))
+			addrs = append(addrs, tlv.Value...)
+		}
+		if len(addrs) > 0xffff {
+			return nil, errors.New("proxy header is too long")
+		}
+		b = append(b, cmd, fam)
+		b = appendUint16(b, uint16(len(addrs)))
+		return append(b, addrs...), nil
+	default:
+		return nil, fmt.Errorf("unknown proxy header version %d", h.Version)
+	}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// parseProxyHeader parses the header at the beginning of b, and returns the header and its
+// length. It returns EAGAIN if b is not long enough, or errNoProxyHeader if b doesn't start
+// with a header.
+func parseProxyHeader(b []byte) (*ProxyHeader, int, error) {
+	switch {
+	case hasPrefix(b, proxyHeaderV2Sig):
+		return parseProxyHeaderV2(b)
+	case hasPrefix(b, proxyHeaderV1Sig):
+		return parseProxyHeaderV1(b)
+	default:
+		return nil, 0, errNoProxyHeader
+	}
+}
+
+// hasPrefix reports whether b and prefix are the same in their common length.
+func hasPrefix(b, prefix []byte) bool {
+	if len(b) > len(prefix) {
+		b = b[:len(prefix)]
+	}
+	return bytes.Equal(b, prefix[:len(b)])
+}
+
+func parseProxyHeaderV1(b []byte) (*ProxyHeader, int, error) {
+	end := bytes.Index(b, []byte("\r\n"))
+	if end < 0 {
+		if len(b) >= maxProxyHeaderV1Len {
+			return nil, 0, errors.New("proxy header v1 is too long")
+		}
+		return nil, 0, EAGAIN
+	}
+	if end+2 > maxProxyHeaderV1Len {
+		return nil, 0, errors.New("proxy header v1 is too long")
+	}
+	h := &ProxyHeader{Version: 1}
+	fields := strings.Split(string(b[:end]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, end + 2, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, 0, fmt.Errorf("invalid proxy header v1 %q", b[:end])
+	}
+	src, err := parseProxyHeaderV1Addr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, 0, err
+	}
+	dst, err := parseProxyHeaderV1Addr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, 0, err
+	}
+	h.SourceAddr, h.DestAddr = src, dst
+	return h, end + 2, nil
+}
+
+func parseProxyHeaderV1Addr(ipStr, portStr string, v4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil || (ip.To4() != nil) != v4 {
+		return nil, fmt.Errorf("invalid proxy header v1 address %q", ipStr)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy header v1 port %q", portStr)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func parseProxyHeaderV2(b []byte) (*ProxyHeader, int, error) {
+	if len(b) < proxyHeaderV2Len {
+		return nil, 0, EAGAIN
+	}
+	n := proxyHeaderV2Len + int(binary.BigEndian.Uint16(b[14:16]))
+	if len(b) < n {
+		return nil, 0, EAGAIN
+	}
+	if b[12]>>4 != 2 {
+		return nil, 0, fmt.Errorf("invalid proxy header v2 version %d", b[12]>>4)
+	}
+	h := &ProxyHeader{Version: 2}
+	switch b[12] & 0x0f {
+	case 0x00:
+		h.Local = true
+	case 0x01:
+	default:
+		return nil, 0, fmt.Errorf("invalid proxy header v2 command %d", b[12]&0x0f)
+	}
+	payload := b[proxyHeaderV2Len:n]
+	var addrLen int
+	switch b[13] >> 4 {
+	case 0x0:
+	case 0x1:
+		addrLen = 2*net.IPv4len + 4
+	case 0x2:
+		addrLen = 2*net.IPv6len + 4
+	case 0x3:
+		addrLen = 2 * 108
+	default:
+		return nil, 0, fmt.Errorf("invalid proxy header v2 address family %d", b[13]>>4)
+	}
+	if len(payload) < addrLen {
+		return nil, 0, errors.New("proxy header v2 addresses are truncated")
+	}
+	// Only the STREAM transport is accepted on the tcp service, the addresses of the other
+	// transports are skipped so that the connection keeps the addresses of the socket.
+	if addrLen > 0 && !h.Local && b[13]&0x0f == 0x1 {
+		h.SourceAddr, h.DestAddr = parseProxyHeaderV2Addrs(b[13], payload[:addrLen])
+	}
+	for tlvs := payload[addrLen:]; len(tlvs) > 0; {
+		if len(tlvs) < 3 {
+			return nil, 0, errors.New("proxy header v2 TLV is truncated")
+		}
+		l := 3 + int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < l {
+			return nil, 0, errors.New("proxy header v2 TLV is truncated")
+		}
+		h.TLVs = append(h.TLVs, ProxyTLV{Type: tlvs[0], Value: append([]byte(nil), tlvs[3:l]...)})
+		tlvs = tlvs[l:]
+	}
+	return h, n, nil
+}
+
+func parseProxyHeaderV2Addrs(fam byte, b []byte) (net.Addr, net.Addr) {
+	if fam>>4 == 0x3 {
+		path := func(b []byte) string {
+			if i := bytes.IndexByte(b, 0); i >= 0 {
+				b = b[:i]
+			}
+			return string(b)
+		}
+		return &net.UnixAddr{Name: path(b[:108]), Net: "unix"}, &net.UnixAddr{Name: path(b[108:]), Net: "unix"}
+	}
+	ipLen := (len(b) - 4) / 2
+	srcIP := append(net.IP(nil), b[:ipLen]...)
+	dstIP := append(net.IP(nil), b[ipLen:2*ipLen]...)
+	srcPort := int(binary.BigEndian.Uint16(b[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(b[2*ipLen+2:]))
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+}
+
+// proxyHeaderReader reads the PROXY protocol header of an accepted connection before the
+// connection is opened, that is, before the OnTCPOpened hook and the request handler.
+type proxyHeaderReader struct {
+	s      *tcpservice
+	tc     *tcpconn
+	mu     sync.Mutex
+	opened atomic.Bool
+	timer  *asynctimer.Timer
+}
+
+func newProxyHeaderReader(s *tcpservice, tc *tcpconn) *proxyHeaderReader {
+	r := &proxyHeaderReader{s: s, tc: tc}
+	if s.opts.proxyHeaderTimeout > 0 {
+		r.timer = asynctimer.NewTimer(r, proxyHeaderOnTimeout, s.opts.proxyHeaderTimeout)
+	}
+	return r
+}
+
+// start starts the header timeout, it is called after the connection is set up, so that
+// the timeout doesn't race with the setup.
+func (r *proxyHeaderReader) start() error {
+	if r.timer == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.opened.Load() {
+		return nil
+	}
+	if err := asynctimer.Add(r.timer); err != nil {
+		return fmt.Errorf("tnet proxy header timeout asynctimer add error: %w", err)
+	}
+	return nil
+}
+
+// handle is the request handler until the connection is opened.
+func (r *proxyHeaderReader) handle(conn Conn) error {
+	if !r.opened.Load() {
+		if err := r.read(); err != nil {
+			return err
+		}
+		// Only the header is received.
+		if r.tc.Len() == 0 {
+			return nil
+		}
+	}
+	// The handler may be replaced in OnTCPOpened.
+	handler := r.tc.getOnRequest()
+	if handler == nil {
+		return nil
+	}
+	return handler(conn)
+}
+
+func (r *proxyHeaderReader) read() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.opened.Load() {
+		return nil
+	}
+	n := r.tc.Len()
+	if n > proxyHeaderV2Len+0xffff {
+		n = proxyHeaderV2Len + 0xffff
+	}
+	b, err := r.tc.Peek(n)
+	if err != nil {
+		return err
+	}
+	h, n, err := parseProxyHeader(b)
+	switch {
+	case err == nil:
+	case errors.Is(err, EAGAIN):
+		return err
+	case errors.Is(err, errNoProxyHeader) && r.s.opts.proxyProtocol == ProxyProtocolOptional:
+	default:
+		r.tc.setCloseReason(CloseReasonProxyHeader)
+		return fmt.Errorf("read proxy header error: %w", err)
+	}
+	if r.timer != nil {
+		asynctimer.Del(r.timer)
+	}
+	if h != nil {
+		if err := r.tc.Skip(n); err != nil {
+			return err
+		}
+		r.tc.Release()
+		r.tc.proxyHeader = h
+		if !h.Local && h.SourceAddr != nil {
+			r.tc.nfd.raddr, r.tc.nfd.laddr = h.SourceAddr, h.DestAddr
+		}
+	}
+	return r.open()
+}
+
+// open sets the request handler of the service, and executes the OnTCPOpened hook.
+func (r *proxyHeaderReader) open() error {
+	r.opened.Store(true)
+	if err := r.tc.SetOnRequest(r.s.reqHandle); err != nil {
+		return err
+	}
+	if r.s.opts.onTCPOpened != nil {
+		return r.tc.callTCPHandler(TCPHandler(r.s.opts.onTCPOpened))
+	}
+	return nil
+}
+
+func proxyHeaderOnTimeout(data interface{}) {
+	if r, ok := data.(*proxyHeaderReader); ok && r != nil {
+		r.onTimeout()
+	}
+}
+
+func (r *proxyHeaderReader) onTimeout() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.opened.Load() || !r.tc.IsActive() {
+		return
+	}
+	if r.s.opts.proxyProtocol == ProxyProtocolRequired {
+		r.tc.closeWithReason(CloseReasonProxyHeader)
+		return
+	}
+	if err := r.open(); err != nil {
+		r.tc.closeWithReason(CloseReasonHandlerError)
+		return
+	}
+	// The data received before the timeout is not a header.
+	r.tc.triggerRequest()
+}
@@ -0,0 +1,281 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+)
+
+type proxyOpened struct {
+	header     *tnet.ProxyHeader
+	remoteAddr string
+	localAddr  string
+}
+
+// startProxyProtocolService starts an echo service reading the PROXY protocol header,
+// the opened and closed connections are sent to the returned channels.
+func startProxyProtocolService(t *testing.T, opt ...tnet.Option) (string, chan proxyOpened, chan tnet.CloseReason) {
+	ln, err := tnet.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	opened := make(chan proxyOpened, 1)
+	closed := make(chan tnet.CloseReason, 1)
+	opt = append(opt,
+		tnet.WithOnTCPOpened(func(c tnet.Conn) error {
+			opened <- proxyOpened{
+				header:     tnet.ProxyHeaderOf(c),
+				remoteAddr: c.RemoteAddr().String(),
+				localAddr:  c.LocalAddr().String(),
+			}
+			return nil
+		}),
+		tnet.WithOnTCPClosed(func(c tnet.Conn) error {
+			closed <- c.(tnet.ContextConn).CloseReason()
+			return nil
+		}),
+	)
+	s, err := tnet.NewTCPService(ln, func(c tnet.Conn) error {
+		b, err := c.Next(c.Len())
+		if err != nil {
+			return err
+		}
+		_, err = c.Write(b)
+		return err
+	}, opt...)
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Serve(ctx)
+	return ln.Addr().String(), opened, closed
+}
+
+func readFull(t *testing.T, conn net.Conn, n int) []byte {
+	b := make([]byte, n)
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err := io.ReadFull(conn, b)
+	require.Nil(t, err)
+	return b
+}
+
+func TestProxyProtocol_V2(t *testing.T) {
+	addr, opened, _ := startProxyProtocolService(t, tnet.WithProxyProtocol(tnet.ProxyProtocolRequired))
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	h := &tnet.ProxyHeader{
+		Version:    2,
+		SourceAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 12345},
+		DestAddr:   &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443},
+		TLVs: []tnet.ProxyTLV{
+			{Type: tnet.ProxyTLVTypeAuthority, Value: []byte("example.com")},
+			{Type: tnet.ProxyTLVTypeUniqueID, Value: []byte{1, 2, 3}},
+		},
+	}
+	b, err := h.Encode()
+	require.Nil(t, err)
+	// The header and the data arrive together.
+	_, err = conn.Write(append(b, helloWorld...))
+	require.Nil(t, err)
+	assert.Equal(t, helloWorld, readFull(t, conn, len(helloWorld)))
+
+	o := <-opened
+	assert.Equal(t, "[2001:db8::1]:12345", o.remoteAddr)
+	assert.Equal(t, "[2001:db8::2]:443", o.localAddr)
+	require.NotNil(t, o.header)
+	assert.Equal(t, 2, o.header.Version)
+	assert.Equal(t, h.TLVs, o.header.TLVs)
+}
+
+func TestProxyProtocol_V1Split(t *testing.T) {
+	addr, opened, _ := startProxyProtocolService(t, tnet.WithProxyProtocol(tnet.ProxyProtocolOptional))
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	header := "PROXY TCP4 192.0.2.1 198.51.100.1 5000 80\r\n"
+	for _, part := range []string{header[:4], header[4:20], header[20:]} {
+		_, err = conn.Write([]byte(part))
+		require.Nil(t, err)
+		time.Sleep(20 * time.Millisecond)
+	}
+	o := <-opened
+	assert.Equal(t, "192.0.2.1:5000", o.remoteAddr)
+	assert.Equal(t, "198.51.100.1:80", o.localAddr)
+	require.NotNil(t, o.header)
+	assert.Equal(t, 1, o.header.Version)
+
+	_, err = conn.Write(helloWorld)
+	require.Nil(t, err)
+	assert.Equal(t, helloWorld, readFull(t, conn, len(helloWorld)))
+}
+
+func TestProxyProtocol_Local(t *testing.T) {
+	addr, opened, _ := startProxyProtocolService(t, tnet.WithProxyProtocol(tnet.ProxyProtocolRequired))
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	b, err := (&tnet.ProxyHeader{Version: 2, Local: true}).Encode()
+	require.Nil(t, err)
+	_, err = conn.Write(b)
+	require.Nil(t, err)
+	o := <-opened
+	assert.True(t, o.header.Local)
+	// The addresses of the connection are kept.
+	assert.Equal(t, conn.LocalAddr().String(), o.remoteAddr)
+}
+
+func TestProxyProtocol_DgramTransport(t *testing.T) {
+	addr, opened, _ := startProxyProtocolService(t, tnet.WithProxyProtocol(tnet.ProxyProtocolRequired))
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	b, err := (&tnet.ProxyHeader{
+		Version:    2,
+		SourceAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5000},
+		DestAddr:   &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 53},
+	}).Encode()
+	require.Nil(t, err)
+	// Change the transport from STREAM to DGRAM.
+	b[13] = b[13]&0xf0 | 0x2
+	_, err = conn.Write(append(b, helloWorld...))
+	require.Nil(t, err)
+	assert.Equal(t, helloWorld, readFull(t, conn, len(helloWorld)))
+	o := <-opened
+	assert.Nil(t, o.header.SourceAddr)
+	assert.Nil(t, o.header.DestAddr)
+	// The addresses of the connection are kept.
+	assert.Equal(t, conn.LocalAddr().String(), o.remoteAddr)
+	assert.Equal(t, conn.RemoteAddr().String(), o.localAddr)
+}
+
+func TestProxyProtocol_Required(t *testing.T) {
+	addr, opened, closed := startProxyProtocolService(t, tnet.WithProxyProtocol(tnet.ProxyProtocolRequired))
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(helloWorld)
+	require.Nil(t, err)
+	select {
+	case r := <-closed:
+		assert.Equal(t, tnet.CloseReasonProxyHeader, r)
+	case <-time.After(time.Second):
+		t.Fatal("connection without header is not closed")
+	}
+	assert.Empty(t, opened)
+
+	// Malformed v1 header.
+	conn, err = net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("PROXY TCP4 bad\r\n"))
+	require.Nil(t, err)
+	select {
+	case r := <-closed:
+		assert.Equal(t, tnet.CloseReasonProxyHeader, r)
+	case <-time.After(time.Second):
+		t.Fatal("connection with malformed header is not closed")
+	}
+}
+
+func TestProxyProtocol_Optional(t *testing.T) {
+	addr, opened, _ := startProxyProtocolService(t, tnet.WithProxyProtocol(tnet.ProxyProtocolOptional))
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(helloWorld)
+	require.Nil(t, err)
+	assert.Equal(t, helloWorld, readFull(t, conn, len(helloWorld)))
+	o := <-opened
+	assert.Nil(t, o.header)
+	assert.Equal(t, conn.LocalAddr().String(), o.remoteAddr)
+}
+
+func TestProxyProtocol_Timeout(t *testing.T) {
+	addr, opened, closed := startProxyProtocolService(t,
+		tnet.WithProxyProtocol(tnet.ProxyProtocolRequired),
+		tnet.WithProxyHeaderTimeout(time.Second))
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	select {
+	case r := <-closed:
+		assert.Equal(t, tnet.CloseReasonProxyHeader, r)
+	case <-time.After(3 * time.Second):
+		t.Fatal("connection is not closed after the header timeout")
+	}
+	assert.Empty(t, opened)
+
+	// The connection of optional mode is opened after the timeout, so that the server
+	// can speak first.
+	addr, opened, _ = startProxyProtocolService(t,
+		tnet.WithProxyProtocol(tnet.ProxyProtocolOptional),
+		tnet.WithProxyHeaderTimeout(time.Second))
+	conn, err = net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	select {
+	case o := <-opened:
+		assert.Nil(t, o.header)
+	case <-time.After(3 * time.Second):
+		t.Fatal("connection is not opened after the header timeout")
+	}
+}
+
+func TestDialer_ProxyHeader(t *testing.T) {
+	addr, opened, _ := startProxyProtocolService(t, tnet.WithProxyProtocol(tnet.ProxyProtocolRequired))
+	for _, version := range []int{1, 2} {
+		d := tnet.Dialer{Timeout: time.Second, ProxyHeader: &tnet.ProxyHeader{Version: version}}
+		conn, err := d.DialContext(context.Background(), "tcp", addr)
+		require.Nil(t, err)
+		_, err = conn.Write(helloWorld)
+		require.Nil(t, err)
+		data, err := conn.ReadN(len(helloWorld))
+		require.Nil(t, err)
+		assert.Equal(t, helloWorld, data)
+
+		o := <-opened
+		require.NotNil(t, o.header)
+		assert.Equal(t, version, o.header.Version)
+		assert.Equal(t, conn.LocalAddr().String(), o.remoteAddr)
+		assert.Equal(t, conn.RemoteAddr().String(), o.localAddr)
+		require.Nil(t, conn.Close())
+	}
+
+	d := tnet.Dialer{ProxyHeader: &tnet.ProxyHeader{Version: 3}}
+	_, err := d.DialContext(context.Background(), "tcp", addr)
+	assert.NotNil(t, err)
+}
+
+func TestProxyHeader_Encode(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5000}
+	dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 80}
+	b, err := (&tnet.ProxyHeader{Version: 1, SourceAddr: src, DestAddr: dst}).Encode()
+	require.Nil(t, err)
+	assert.Equal(t, "PROXY UNKNOWN\r\n", string(b))
+
+	_, err = (&tnet.ProxyHeader{Version: 2, TLVs: []tnet.ProxyTLV{{Value: make([]byte, 0x10000)}}}).Encode()
+	assert.NotNil(t, err)
+	_, err = (&tnet.ProxyHeader{}).Encode()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,300 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+// Package proxytest provides an in-process proxy for testing the proxy dialing of tnet,
+// which serves both SOCKS5 and HTTP CONNECT on the same address.
+package proxytest
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// Server is a SOCKS5 and HTTP CONNECT proxy listening on a loopback address.
+type Server struct {
+	// Addr is the address of the proxy.
+	Addr string
+
+	username string
+	password string
+	ln       net.Listener
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	targets []string
+	conns   map[net.Conn]struct{}
+}
+
+// NewServer starts a proxy without authentication.
+func NewServer() *Server {
+	return NewServerWithAuth("", "")
+}
+
+// NewServerWithAuth starts a proxy that requires the username and password, unless username is empty.
+func NewServerWithAuth(username, password string) *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("proxytest: failed to listen: %v", err))
+	}
+	s := &Server{
+		Addr:     ln.Addr().String(),
+		username: username,
+		password: password,
+		ln:       ln,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// URL returns the URL of the proxy with the scheme, which is "socks5" or "http".
+// The username and password are included if the proxy requires authentication.
+func (s *Server) URL(scheme string) *url.URL {
+	u := &url.URL{Scheme: scheme, Host: s.Addr}
+	if s.username != "" {
+		u.User = url.UserPassword(s.username, s.password)
+	}
+	return u
+}
+
+// Targets returns the addresses the proxy has connected to.
+func (s *Server) Targets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.targets...)
+}
+
+// Close stops the proxy and closes all the connections.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	if !s.track(conn) {
+		return
+	}
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	if err != nil {
+		return
+	}
+	var target string
+	var reply func(ok bool) error
+	if first[0] == 0x05 {
+		target, reply, err = s.socks5(br, conn)
+	} else {
+		target, reply, err = s.httpConnect(br, conn)
+	}
+	if err != nil {
+		return
+	}
+	upstream, err := net.Dial("tcp", target)
+	if reply(err == nil) != nil || err != nil {
+		return
+	}
+	defer upstream.Close()
+	if !s.track(upstream) {
+		return
+	}
+	s.mu.Lock()
+	s.targets = append(s.targets, target)
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(upstream, br)
+		if tc, ok := upstream.(*net.TCPConn); ok {
+			tc.CloseWrite()
+		}
+		close(done)
+	}()
+	io.Copy(conn, upstream)
+	conn.Close()
+	<-done
+	s.mu.Lock()
+	delete(s.conns, upstream)
+	s.mu.Unlock()
+}
+
+func (s *Server) socks5(br *bufio.Reader, conn net.Conn) (string, func(bool) error, error) {
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return "", nil, err
+	}
+	methods := make([]byte, b[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return "", nil, err
+	}
+	want := byte(0x00)
+	if s.username != "" {
+		want = 0x02
+	}
+	if !bytesContain(methods, want) {
+		conn.Write([]byte{0x05, 0xff})
+		return "", nil, errors.New("no acceptable method")
+	}
+	if _, err := conn.Write([]byte{0x05, want}); err != nil {
+		return "", nil, err
+	}
+	if want == 0x02 {
+		username, err := readString(br, 1)
+		if err != nil {
+			return "", nil, err
+		}
+		password, err := readString(br, 0)
+		if err != nil {
+			return "", nil, err
+		}
+		if username != s.username || password != s.password {
+			conn.Write([]byte{0x01, 0x01})
+			return "", nil, errors.New("authentication failed")
+		}
+		if _, err := conn.Write([]byte{0x01, 0x00}); err != nil {
+			return "", nil, err
+		}
+	}
+
+	b = make([]byte, 4)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return "", nil, err
+	}
+	var host string
+	switch b[3] {
+	case 0x01, 0x04:
+		ip := make(net.IP, 4)
+		if b[3] == 0x04 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(br, ip); err != nil {
+			return "", nil, err
+		}
+		host = ip.String()
+	case 0x03:
+		h, err := readString(br, 0)
+		if err != nil {
+			return "", nil, err
+		}
+		host = h
+	default:
+		return "", nil, errors.New("unknown address type")
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(br, port); err != nil {
+		return "", nil, err
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+	return target, func(ok bool) error {
+		rep := byte(0x00)
+		if !ok {
+			rep = 0x05
+		}
+		_, err := conn.Write([]byte{0x05, rep, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return err
+	}, nil
+}
+
+// readString reads a string prefixed with its length, after skipping skip bytes.
+func readString(br *bufio.Reader, skip int) (string, error) {
+	b := make([]byte, skip+1)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return "", err
+	}
+	s := make([]byte, b[skip])
+	if _, err := io.ReadFull(br, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+func bytesContain(b []byte, c byte) bool {
+	for _, v := range b {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) httpConnect(br *bufio.Reader, conn net.Conn) (string, func(bool) error, error) {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return "", nil, err
+	}
+	if req.Method != http.MethodConnect {
+		conn.Write([]byte("HTTP/1.1 405 Method Not Allowed\r\n\r\n"))
+		return "", nil, errors.New("method not allowed")
+	}
+	if s.username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(s.username + ":" + s.password))
+		if req.Header.Get("Proxy-Authorization") != "Basic "+auth {
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			return "", nil, errors.New("authentication failed")
+		}
+	}
+	return req.Host, func(ok bool) error {
+		status := "200 Connection established"
+		if !ok {
+			status = "502 Bad Gateway"
+		}
+		_, err := fmt.Fprintf(conn, "HTTP/1.1 %s\r\n\r\n", status)
+		return err
+	}, nil
+}
@@ -0,0 +1,827 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrReconnecting means that the ReconnectingConn lost its connection and is reconnecting.
+	ErrReconnecting = netError{error: errors.New("conn is reconnecting")}
+	// ErrReconnectQueueFull means that the writes queued during reconnecting exceed the limit.
+	ErrReconnectQueueFull = netError{error: errors.New("reconnect write queue is full")}
+
+	errUnsupportedConn = errors.New("not supported by the underlying connection")
+)
+
+const (
+	defaultReconnectMinBackoff = 100 * time.Millisecond
+	defaultReconnectMaxBackoff = 30 * time.Second
+	defaultReconnectJitter     = 0.2
+)
+
+// ReconnectOption is the option of DialReconnecting.
+type ReconnectOption struct {
+	f func(*reconnectOptions)
+}
+
+type reconnectOptions struct {
+	dialer      Dialer
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	jitter      float64
+	onReconnect func(conn Conn) error
+	queueLimit  int
+}
+
+func (o *reconnectOptions) setDefault() {
+	o.minBackoff = defaultReconnectMinBackoff
+	o.maxBackoff = defaultReconnectMaxBackoff
+	o.jitter = defaultReconnectJitter
+}
+
+// WithReconnectDialer sets the Dialer used to dial the connections, the Options of the Dialer
+// are applied to every connection.
+func WithReconnectDialer(d Dialer) ReconnectOption {
+	return ReconnectOption{func(o *reconnectOptions) {
+		o.dialer = d
+	}}
+}
+
+// WithReconnectBackoff sets the backoff before reconnecting, it starts from min and is doubled
+// after each failure up to max. Default is 100ms to 30s.
+func WithReconnectBackoff(min, max time.Duration) ReconnectOption {
+	return ReconnectOption{func(o *reconnectOptions) {
+		if min > 0 {
+			o.minBackoff = min
+		}
+		if max >= o.minBackoff {
+			o.maxBackoff = max
+		}
+	}}
+}
+
+// WithReconnectJitter sets the jitter of the backoff, the backoff is randomized in the range
+// [backoff*(1-jitter), backoff*(1+jitter)]. Default is 0.2.
+func WithReconnectJitter(jitter float64) ReconnectOption {
+	return ReconnectOption{func(o *reconnectOptions) {
+		if jitter >= 0 && jitter <= 1 {
+			o.jitter = jitter
+		}
+	}}
+}
+
+// WithOnReconnect sets the hook called with the new connection after reconnecting, before the
+// connection is used by the ReconnectingConn. It can replay the handshake on conn directly,
+// the data received is not passed to the TCPHandler until it returns. If it returns error,
+// the new connection is closed and reconnecting goes on.
+func WithOnReconnect(onReconnect func(conn Conn) error) ReconnectOption {
+	return ReconnectOption{func(o *reconnectOptions) {
+		o.onReconnect = onReconnect
+	}}
+}
+
+// WithReconnectQueue queues the writes during reconnecting, up to limit bytes, and sends them
+// after reconnected. The writes beyond limit fail with ErrReconnectQueueFull.
+// By default, no write is queued, and writes fail with ErrReconnecting during reconnecting.
+func WithReconnectQueue(limit int) ReconnectOption {
+	return ReconnectOption{func(o *reconnectOptions) {
+		o.queueLimit = limit
+	}}
+}
+
+// reconnect settings are recorded by ReconnectingConn and applied to each new connection.
+const (
+	settingNonBlocking = iota
+	settingSafeWrite
+	settingKeepAlive
+	settingIdleTimeout
+	settingReadIdleTimeout
+	settingWriteIdleTimeout
+	settingReadDeadline
+	settingWriteDeadline
+	numReconnectSettings
+)
+
+type queuedWrite struct {
+	data []byte
+	done func(error)
+}
+
+// deferredDone holds back the done callbacks called while rc.mu is held, so that
+// a callback calling into the ReconnectingConn doesn't deadlock.
+type deferredDone struct {
+	mu      sync.Mutex
+	holding bool
+	calls   []func()
+}
+
+// wrap returns done which is deferred until release while holding.
+func (d *deferredDone) wrap(done func(error)) func(error) {
+	if done == nil {
+		return nil
+	}
+	return func(err error) {
+		d.mu.Lock()
+		if d.holding {
+			d.calls = append(d.calls, func() { done(err) })
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+		done(err)
+	}
+}
+
+// release stops holding back the callbacks and runs the deferred ones.
+func (d *deferredDone) release() {
+	d.mu.Lock()
+	d.holding = false
+	calls := d.calls
+	d.calls = nil
+	d.mu.Unlock()
+	for _, call := range calls {
+		call()
+	}
+}
+
+// ReconnectingConn is a client Conn which redials the address with jittered exponential
+// backoff when the underlying connection is closed, until it is closed by Close.
+//
+// The handlers, metadata, id and settings such as SetIdleTimeout are kept across reconnects.
+// The TCPHandler and OnTCPClosed are called with the ReconnectingConn, and OnTCPClosed is
+// called each time the underlying connection is closed. Reading during reconnecting returns
+// ErrReconnecting, and the blocked reading returns the error of the lost connection.
+type ReconnectingConn struct {
+	connContext
+	network string
+	address string
+	opts    reconnectOptions
+	id      uint64
+
+	mu        sync.Mutex
+	conn      Conn
+	closed    bool
+	laddr     net.Addr
+	raddr     net.Addr
+	metaData  interface{}
+	onRequest TCPHandler
+	onClosed  OnTCPClosed
+	settings  [numReconnectSettings]func(Conn) error
+	corked    int
+	queue     []queuedWrite
+	queued    int
+}
+
+// ReconnectingConn must implements Conn interface.
+var (
+	_ Conn              = (*ReconnectingConn)(nil)
+	_ Corker            = (*ReconnectingConn)(nil)
+	_ BlockReader       = (*ReconnectingConn)(nil)
+	_ OwnedReader       = (*ReconnectingConn)(nil)
+	_ CallbackWriter    = (*ReconnectingConn)(nil)
+	_ ContextReadWriter = (*ReconnectingConn)(nil)
+	_ ContextConn       = (*ReconnectingConn)(nil)
+	_ StatsConn         = (*ReconnectingConn)(nil)
+)
+
+// DialReconnecting connects to the address on the named network, and returns a ReconnectingConn
+// which reconnects when the connection is lost. The first dial is done by ctx, and its error
+// is returned directly without retrying.
+func DialReconnecting(ctx context.Context, network, address string, opt ...ReconnectOption) (*ReconnectingConn, error) {
+	rc := &ReconnectingConn{
+		network: network,
+		address: address,
+		id:      lastConnID.Inc(),
+	}
+	rc.opts.setDefault()
+	for _, o := range opt {
+		o.f(&rc.opts)
+	}
+	// The Options of the user's Dialer are not modified.
+	opts := make([]Option, 0, len(rc.opts.dialer.Options)+1)
+	opts = append(opts, rc.opts.dialer.Options...)
+	rc.opts.dialer.Options = append(opts, WithTCPInterceptors(rc.intercept))
+
+	conn, err := rc.opts.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	rc.promote(conn)
+	return rc, nil
+}
+
+// intercept installs the request handler before the connection is scheduled, and
+// reconnects when the connection is closed.
+func (rc *ReconnectingConn) intercept(conn Conn, kind HookKind, next TCPHandler) error {
+	switch kind {
+	case HookOpened:
+		if err := next(conn); err != nil {
+			return err
+		}
+		return conn.SetOnRequest(func(c Conn) error { return rc.handleRequest(c) })
+	case HookClosed:
+		err := next(conn)
+		rc.lost(conn)
+		return err
+	default:
+		return next(conn)
+	}
+}
+
+// handleRequest calls the user's handler if conn is the current connection, otherwise the
+// data is left for OnReconnect, and handled after conn becomes the current one.
+func (rc *ReconnectingConn) handleRequest(conn Conn) error {
+	rc.mu.Lock()
+	current, handler := rc.conn == conn, rc.onRequest
+	rc.mu.Unlock()
+	if !current || handler == nil {
+		return EAGAIN
+	}
+	return handler(rc)
+}
+
+// lost is called when conn is closed, it starts reconnecting if conn is the current connection.
+// It is safe to be called several times for the same conn.
+func (rc *ReconnectingConn) lost(conn Conn) {
+	rc.mu.Lock()
+	if rc.conn != conn {
+		rc.mu.Unlock()
+		return
+	}
+	rc.conn = nil
+	closed, onClosed := rc.closed, rc.onClosed
+	rc.mu.Unlock()
+	if onClosed != nil {
+		onClosed(rc)
+	}
+	if !closed {
+		go rc.reconnect()
+	}
+}
+
+// reconnect dials until a new connection becomes the current one or rc is closed.
+func (rc *ReconnectingConn) reconnect() {
+	ctx := rc.Context()
+	backoff := rc.opts.minBackoff
+	for {
+		t := time.NewTimer(rc.jitter(backoff))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+		conn, err := rc.opts.dialer.DialContext(ctx, rc.network, rc.address)
+		if err == nil && rc.handshake(conn) == nil && rc.promote(conn) == nil {
+			return
+		}
+		if backoff *= 2; backoff > rc.opts.maxBackoff {
+			backoff = rc.opts.maxBackoff
+		}
+	}
+}
+
+func (rc *ReconnectingConn) jitter(d time.Duration) time.Duration {
+	if rc.opts.jitter == 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + rc.opts.jitter*(2*rand.Float64()-1)))
+}
+
+// handshake applies the settings to the new connection, and calls the OnReconnect hook.
+func (rc *ReconnectingConn) handshake(conn Conn) error {
+	rc.mu.Lock()
+	err := rc.applySettingsLocked(conn)
+	rc.mu.Unlock()
+	if err == nil && rc.opts.onReconnect != nil {
+		err = rc.opts.onReconnect(conn)
+	}
+	if err != nil {
+		conn.Close()
+	}
+	return err
+}
+
+func (rc *ReconnectingConn) applySettingsLocked(conn Conn) error {
+	for _, set := range rc.settings {
+		if set == nil {
+			continue
+		}
+		if err := set(conn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promote makes conn the current connection after sending the queued writes.
+// It returns error if conn can't be used. The writes not sent are kept in the queue for the
+// next connection, except the failed one whose done has been called with the error.
+func (rc *ReconnectingConn) promote(conn Conn) error {
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	// The settings may be changed during OnReconnect.
+	if err := rc.applySettingsLocked(conn); err != nil {
+		rc.mu.Unlock()
+		conn.Close()
+		return err
+	}
+	if c, ok := conn.(Corker); ok {
+		for i := 0; i < rc.corked; i++ {
+			c.Cork()
+		}
+	}
+	// The queue is replayed under rc.mu to keep it ahead of the new writes, the done
+	// callbacks called meanwhile run after rc.mu is unlocked.
+	deferred := &deferredDone{holding: true}
+	for len(rc.queue) > 0 {
+		// The write is popped before sending, as its done is called on error.
+		q := rc.queue[0]
+		rc.queue[0] = queuedWrite{}
+		rc.queue = rc.queue[1:]
+		rc.queued -= len(q.data)
+		if _, err := writevWithCallback(conn, deferred.wrap(q.done), [][]byte{q.data}); err != nil {
+			if q.done == nil {
+				// Nobody is notified of the failure, so it is sent by the next connection.
+				rc.queue = append([]queuedWrite{q}, rc.queue...)
+				rc.queued += len(q.data)
+			}
+			rc.mu.Unlock()
+			deferred.release()
+			conn.Close()
+			return err
+		}
+	}
+	rc.queue = nil
+	rc.conn, rc.laddr, rc.raddr = conn, conn.LocalAddr(), conn.RemoteAddr()
+	rc.mu.Unlock()
+	deferred.release()
+	if !conn.IsActive() {
+		// The conn is closed before it becomes the current one, so the close hook missed it.
+		rc.lost(conn)
+		return nil
+	}
+	// The data received during reconnecting is handled now.
+	if tc, ok := conn.(*tcpconn); ok {
+		tc.triggerRequest()
+	}
+	return nil
+}
+
+// current returns the current connection.
+func (rc *ReconnectingConn) current() (Conn, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.closed {
+		return nil, ErrConnClosed
+	}
+	if rc.conn == nil {
+		return nil, ErrReconnecting
+	}
+	return rc.conn, nil
+}
+
+// writev writes p to the current connection, or queues p during reconnecting.
+func (rc *ReconnectingConn) writev(done func(error), p [][]byte) (int, error) {
+	for {
+		rc.mu.Lock()
+		conn, closed := rc.conn, rc.closed
+		if closed || conn == nil {
+			n, err := rc.enqueueLocked(done, p)
+			rc.mu.Unlock()
+			return n, err
+		}
+		rc.mu.Unlock()
+		n, err := writevWithCallback(conn, done, p)
+		// The done is called on error, so the data can't be queued again.
+		if err == nil || done != nil || rc.opts.queueLimit <= 0 || !errors.Is(err, ErrConnClosed) {
+			return n, err
+		}
+		// The conn is closed concurrently, queue the data for the next connection.
+		rc.lost(conn)
+	}
+}
+
+// writevWithCallback writes p to conn, and done is called once the data is written to the
+// kernel if it is not nil, which requires conn to implement CallbackWriter.
+func writevWithCallback(conn Conn, done func(error), p [][]byte) (int, error) {
+	if done == nil {
+		return conn.Writev(p...)
+	}
+	w, ok := conn.(CallbackWriter)
+	if !ok {
+		done(errUnsupportedConn)
+		return 0, errUnsupportedConn
+	}
+	return w.WritevWithCallback(done, p...)
+}
+
+func (rc *ReconnectingConn) enqueueLocked(done func(error), p [][]byte) (int, error) {
+	err := error(ErrReconnecting)
+	var n int
+	for _, b := range p {
+		n += len(b)
+	}
+	switch {
+	case rc.closed:
+		err = ErrConnClosed
+	case rc.opts.queueLimit <= 0:
+	case rc.queued+n > rc.opts.queueLimit:
+		err = ErrReconnectQueueFull
+	default:
+		data := make([]byte, 0, n)
+		for _, b := range p {
+			data = append(data, b...)
+		}
+		rc.queue = append(rc.queue, queuedWrite{data: data, done: done})
+		rc.queued += n
+		return n, nil
+	}
+	if done != nil {
+		done(err)
+	}
+	return 0, err
+}
+
+// set records the setting and applies it to the current connection.
+func (rc *ReconnectingConn) set(setting int, f func(Conn) error) error {
+	rc.mu.Lock()
+	rc.settings[setting] = f
+	conn := rc.conn
+	rc.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return f(conn)
+}
+
+// Read reads data from the current connection.
+func (rc *ReconnectingConn) Read(b []byte) (int, error) {
+	conn, err := rc.current()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Read(b)
+}
+
+// Write writes data to the current connection.
+func (rc *ReconnectingConn) Write(b []byte) (int, error) {
+	return rc.writev(nil, [][]byte{b})
+}
+
+// Writev writes data to the current connection.
+func (rc *ReconnectingConn) Writev(p ...[]byte) (int, error) {
+	return rc.writev(nil, p)
+}
+
+// WritevContext is the same as Writev, except that it returns ctx.Err() without writing if ctx is done.
+func (rc *ReconnectingConn) WritevContext(ctx context.Context, p ...[]byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return rc.writev(nil, p)
+}
+
+// WritevWithCallback is the same as Writev, and done is called once the data is written to the kernel.
+// If the data is queued during reconnecting, done is called after it is sent by the next connection.
+func (rc *ReconnectingConn) WritevWithCallback(done func(error), p ...[]byte) (int, error) {
+	return rc.writev(done, p)
+}
+
+// Peek returns the next n bytes of the current connection without advancing the reader.
+func (rc *ReconnectingConn) Peek(n int) ([]byte, error) {
+	conn, err := rc.current()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Peek(n)
+}
+
+// Next returns the next n bytes of the current connection with advancing the reader.
+func (rc *ReconnectingConn) Next(n int) ([]byte, error) {
+	conn, err := rc.current()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Next(n)
+}
+
+// PeekBlocks returns the next n bytes of the current connection as blocks without advancing the reader.
+func (rc *ReconnectingConn) PeekBlocks(n int) ([][]byte, error) {
+	conn, err := rc.current()
+	if err != nil {
+		return nil, err
+	}
+	r, ok := conn.(BlockReader)
+	if !ok {
+		return nil, errUnsupportedConn
+	}
+	return r.PeekBlocks(n)
+}
+
+// NextBlocks returns the next n bytes of the current connection as blocks with advancing the reader.
+func (rc *ReconnectingConn) NextBlocks(n int) ([][]byte, error) {
+	conn, err := rc.current()
+	if err != nil {
+		return nil, err
+	}
+	r, ok := conn.(BlockReader)
+	if !ok {
+		return nil, errUnsupportedConn
+	}
+	return r.NextBlocks(n)
+}
+
+// NextOwned returns the next n bytes of the current connection as OwnedBytes.
+func (rc *ReconnectingConn) NextOwned(n int) (OwnedBytes, error) {
+	conn, err := rc.current()
+	if err != nil {
+		return nil, err
+	}
+	r, ok := conn.(OwnedReader)
+	if !ok {
+		return nil, errUnsupportedConn
+	}
+	return r.NextOwned(n)
+}
+
+// Skip skips the next n bytes of the current connection.
+func (rc *ReconnectingConn) Skip(n int) error {
+	conn, err := rc.current()
+	if err != nil {
+		return err
+	}
+	return conn.Skip(n)
+}
+
+// Release releases the buffer of the current connection.
+func (rc *ReconnectingConn) Release() {
+	if conn, err := rc.current(); err == nil {
+		conn.Release()
+	}
+}
+
+// ReadN reads the next n bytes of the current connection.
+func (rc *ReconnectingConn) ReadN(n int) ([]byte, error) {
+	conn, err := rc.current()
+	if err != nil {
+		return nil, err
+	}
+	return conn.ReadN(n)
+}
+
+// PeekContext is the same as Peek, and it also stops waiting when ctx is done.
+func (rc *ReconnectingConn) PeekContext(ctx context.Context, n int) ([]byte, error) {
+	conn, err := rc.current()
+	if err != nil {
+		return nil, err
+	}
+	r, ok := conn.(ContextReadWriter)
+	if !ok {
+		return nil, errUnsupportedConn
+	}
+	return r.PeekContext(ctx, n)
+}
+
+// NextContext is the same as Next, and it also stops waiting when ctx is done.
+func (rc *ReconnectingConn) NextContext(ctx context.Context, n int) ([]byte, error) {
+	conn, err := rc.current()
+	if err != nil {
+		return nil, err
+	}
+	r, ok := conn.(ContextReadWriter)
+	if !ok {
+		return nil, errUnsupportedConn
+	}
+	return r.NextContext(ctx, n)
+}
+
+// ReadNContext is the same as ReadN, and it also stops waiting when ctx is done.
+func (rc *ReconnectingConn) ReadNContext(ctx context.Context, n int) ([]byte, error) {
+	conn, err := rc.current()
+	if err != nil {
+		return nil, err
+	}
+	r, ok := conn.(ContextReadWriter)
+	if !ok {
+		return nil, errUnsupportedConn
+	}
+	return r.ReadNContext(ctx, n)
+}
+
+// Cork holds back the sending of the following writes, it is kept across reconnects.
+func (rc *ReconnectingConn) Cork() {
+	rc.mu.Lock()
+	rc.corked++
+	conn := rc.conn
+	rc.mu.Unlock()
+	if c, ok := conn.(Corker); ok {
+		c.Cork()
+	}
+}
+
+// Uncork ends a Cork.
+func (rc *ReconnectingConn) Uncork() error {
+	rc.mu.Lock()
+	if rc.corked == 0 {
+		rc.mu.Unlock()
+		return errors.New("uncork without cork")
+	}
+	rc.corked--
+	conn := rc.conn
+	rc.mu.Unlock()
+	if c, ok := conn.(Corker); ok {
+		return c.Uncork()
+	}
+	return nil
+}
+
+// Len returns the length of the readable data of the current connection.
+func (rc *ReconnectingConn) Len() int {
+	conn, err := rc.current()
+	if err != nil {
+		return 0
+	}
+	return conn.Len()
+}
+
+// IsActive checks whether the ReconnectingConn is connected.
+func (rc *ReconnectingConn) IsActive() bool {
+	conn, err := rc.current()
+	return err == nil && conn.IsActive()
+}
+
+// Close closes the ReconnectingConn and stops reconnecting. The queued writes fail with ErrConnClosed.
+func (rc *ReconnectingConn) Close() error {
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		return nil
+	}
+	rc.closed = true
+	conn, queue := rc.conn, rc.queue
+	rc.queue, rc.queued = nil, 0
+	rc.mu.Unlock()
+
+	rc.setCloseReason(CloseReasonUser)
+	rc.cancelContext()
+	for _, q := range queue {
+		if q.done != nil {
+			q.done(ErrConnClosed)
+		}
+	}
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// LocalAddr returns the local address of the last connection.
+func (rc *ReconnectingConn) LocalAddr() net.Addr {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.laddr
+}
+
+// RemoteAddr returns the remote address of the last connection.
+func (rc *ReconnectingConn) RemoteAddr() net.Addr {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.raddr
+}
+
+// SetDeadline sets the read and write deadlines, they are applied to the new connections too.
+func (rc *ReconnectingConn) SetDeadline(t time.Time) error {
+	if err := rc.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return rc.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline, it is applied to the new connections too.
+func (rc *ReconnectingConn) SetReadDeadline(t time.Time) error {
+	return rc.set(settingReadDeadline, func(conn Conn) error { return conn.SetReadDeadline(t) })
+}
+
+// SetWriteDeadline sets the write deadline, it is applied to the new connections too.
+func (rc *ReconnectingConn) SetWriteDeadline(t time.Time) error {
+	return rc.set(settingWriteDeadline, func(conn Conn) error { return conn.SetWriteDeadline(t) })
+}
+
+// SetNonBlocking sets the connections to nonblocking.
+func (rc *ReconnectingConn) SetNonBlocking(nonblock bool) {
+	rc.set(settingNonBlocking, func(conn Conn) error {
+		conn.SetNonBlocking(nonblock)
+		return nil
+	})
+}
+
+// SetFlushWrite sets whether to flush the data or not.
+// Deprecated: whether enable this feature is controlled by system automatically.
+func (rc *ReconnectingConn) SetFlushWrite(bool) {}
+
+// SetSafeWrite sets whether writing on the connections is safe or not.
+func (rc *ReconnectingConn) SetSafeWrite(safeWrite bool) {
+	rc.set(settingSafeWrite, func(conn Conn) error {
+		conn.SetSafeWrite(safeWrite)
+		return nil
+	})
+}
+
+// SetKeepAlive sets keep alive time for the connections.
+func (rc *ReconnectingConn) SetKeepAlive(t time.Duration) error {
+	return rc.set(settingKeepAlive, func(conn Conn) error { return conn.SetKeepAlive(t) })
+}
+
+// SetIdleTimeout sets the idle timeout of the connections.
+func (rc *ReconnectingConn) SetIdleTimeout(d time.Duration) error {
+	return rc.set(settingIdleTimeout, func(conn Conn) error { return conn.SetIdleTimeout(d) })
+}
+
+// SetReadIdleTimeout sets the read idle timeout of the connections.
+func (rc *ReconnectingConn) SetReadIdleTimeout(d time.Duration) error {
+	return rc.set(settingReadIdleTimeout, func(conn Conn) error { return conn.SetReadIdleTimeout(d) })
+}
+
+// SetWriteIdleTimeout sets the write idle timeout of the connections.
+func (rc *ReconnectingConn) SetWriteIdleTimeout(d time.Duration) error {
+	return rc.set(settingWriteIdleTimeout, func(conn Conn) error { return conn.SetWriteIdleTimeout(d) })
+}
+
+// SetOnRequest sets or replaces the TCPHandler, which is called with the ReconnectingConn.
+func (rc *ReconnectingConn) SetOnRequest(handle TCPHandler) error {
+	if handle == nil {
+		return errors.New("handle can't be nil")
+	}
+	rc.mu.Lock()
+	rc.onRequest = handle
+	conn := rc.conn
+	rc.mu.Unlock()
+	// The data received before the handler is set is handled now.
+	if tc, ok := conn.(*tcpconn); ok {
+		tc.triggerRequest()
+	}
+	return nil
+}
+
+// SetOnClosed sets the hook called with the ReconnectingConn each time the underlying connection
+// is closed, including the last one closed by Close.
+func (rc *ReconnectingConn) SetOnClosed(handle OnTCPClosed) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.onClosed = handle
+	return nil
+}
+
+// SetMetaData sets metadata, it is kept across reconnects.
+func (rc *ReconnectingConn) SetMetaData(m interface{}) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.metaData = m
+}
+
+// GetMetaData gets metadata.
+func (rc *ReconnectingConn) GetMetaData() interface{} {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.metaData
+}
+
+// ID returns the process-unique id of the ReconnectingConn, it is kept across reconnects.
+func (rc *ReconnectingConn) ID() uint64 {
+	return rc.id
+}
+
+// Stats returns the statistics snapshot of the current connection, with the id of the ReconnectingConn.
+func (rc *ReconnectingConn) Stats() ConnStats {
+	var s ConnStats
+	if conn, err := rc.current(); err == nil {
+		if sc, ok := conn.(StatsConn); ok {
+			s = sc.Stats()
+		}
+	}
+	s.ID = rc.id
+	return s
+}
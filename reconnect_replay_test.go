@@ -0,0 +1,121 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replayConn fails the writes after failAfter writes succeed.
+type replayConn struct {
+	Conn
+	failAfter int
+	written   []string
+	closed    bool
+}
+
+var errReplay = errors.New("replay write error")
+
+func (c *replayConn) Writev(p ...[]byte) (int, error) {
+	if len(c.written) >= c.failAfter {
+		return 0, errReplay
+	}
+	c.written = append(c.written, string(p[0]))
+	return len(p[0]), nil
+}
+
+func (c *replayConn) WritevWithCallback(done func(error), p ...[]byte) (int, error) {
+	n, err := c.Writev(p...)
+	done(err)
+	return n, err
+}
+
+func (c *replayConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *replayConn) IsActive() bool       { return !c.closed }
+func (c *replayConn) LocalAddr() net.Addr  { return nil }
+func (c *replayConn) RemoteAddr() net.Addr { return nil }
+
+func TestReconnectingConn_promoteFailMidReplay(t *testing.T) {
+	rc := &ReconnectingConn{opts: reconnectOptions{queueLimit: 1024}}
+	calls := make(map[string][]error)
+	doneOf := func(name string) func(error) {
+		return func(err error) { calls[name] = append(calls[name], err) }
+	}
+	rc.mu.Lock()
+	for _, w := range []struct {
+		data string
+		done func(error)
+	}{
+		{"a", doneOf("a")},
+		{"b", doneOf("b")},
+		{"c", nil},
+		{"d", doneOf("d")},
+	} {
+		_, err := rc.enqueueLocked(w.done, [][]byte{[]byte(w.data)})
+		require.Nil(t, err)
+	}
+	rc.mu.Unlock()
+
+	conn := &replayConn{failAfter: 1}
+	assert.Equal(t, errReplay, rc.promote(conn))
+	assert.True(t, conn.closed)
+	assert.Equal(t, []string{"a"}, conn.written)
+	assert.Equal(t, []error{nil}, calls["a"])
+	assert.Equal(t, []error{errReplay}, calls["b"])
+	assert.Len(t, rc.queue, 2)
+	assert.Equal(t, 2, rc.queued)
+
+	conn = &replayConn{failAfter: 0}
+	assert.Equal(t, errReplay, rc.promote(conn))
+	assert.Empty(t, conn.written)
+	assert.Len(t, rc.queue, 2, "the write without done is kept")
+
+	conn = &replayConn{failAfter: 10}
+	assert.Nil(t, rc.promote(conn))
+	assert.Equal(t, []string{"c", "d"}, conn.written)
+	assert.Equal(t, []error{nil}, calls["a"])
+	assert.Equal(t, []error{errReplay}, calls["b"])
+	assert.Equal(t, []error{nil}, calls["d"])
+	assert.Empty(t, rc.queue)
+	assert.Zero(t, rc.queued)
+	assert.Equal(t, conn, rc.conn)
+}
+
+func TestReconnectingConn_promoteDoneCallsBack(t *testing.T) {
+	rc := &ReconnectingConn{opts: reconnectOptions{queueLimit: 1024}}
+	var doneErr, writeErr error
+	rc.mu.Lock()
+	_, err := rc.enqueueLocked(func(err error) {
+		doneErr = err
+		// Calling into rc from done must not deadlock with the replay.
+		_, writeErr = rc.Write([]byte("b"))
+	}, [][]byte{[]byte("a")})
+	require.Nil(t, err)
+	rc.mu.Unlock()
+
+	conn := &replayConn{failAfter: 10}
+	assert.Nil(t, rc.promote(conn))
+	assert.Nil(t, doneErr)
+	assert.Nil(t, writeErr)
+	assert.Equal(t, []string{"a", "b"}, conn.written)
+}
@@ -0,0 +1,198 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+)
+
+// newKillableEchoServer starts a server which echoes the data, and closes the connection
+// when "kill" is received.
+func newKillableEchoServer(t *testing.T) string {
+	ln, err := tnet.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	s, err := tnet.NewTCPService(ln, func(conn tnet.Conn) error {
+		b, err := conn.ReadN(conn.Len())
+		if err != nil {
+			return err
+		}
+		if string(b) == "kill" {
+			return conn.Close()
+		}
+		_, err = conn.Write(b)
+		return err
+	})
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Serve(ctx)
+	return ln.Addr().String()
+}
+
+func TestReconnectingConn(t *testing.T) {
+	addr := newKillableEchoServer(t)
+	reconnecting, resume := make(chan tnet.Conn, 1), make(chan struct{})
+	rc, err := tnet.DialReconnecting(context.Background(), "tcp", addr,
+		tnet.WithReconnectBackoff(10*time.Millisecond, 100*time.Millisecond),
+		tnet.WithReconnectQueue(1024),
+		tnet.WithOnReconnect(func(conn tnet.Conn) error {
+			// Replay the handshake on the new connection.
+			if _, err := conn.Write([]byte("hs")); err != nil {
+				return err
+			}
+			reconnecting <- conn
+			<-resume
+			return nil
+		}))
+	require.Nil(t, err)
+	defer rc.Close()
+
+	received := make(chan string, 10)
+	require.Nil(t, rc.SetOnRequest(func(conn tnet.Conn) error {
+		assert.Equal(t, rc, conn)
+		b, err := conn.ReadN(conn.Len())
+		if err != nil {
+			return err
+		}
+		received <- string(b)
+		return nil
+	}))
+	closed := make(chan struct{}, 2)
+	require.Nil(t, rc.SetOnClosed(func(conn tnet.Conn) error {
+		assert.Equal(t, rc, conn)
+		closed <- struct{}{}
+		return nil
+	}))
+	rc.SetMetaData("meta")
+	id := rc.ID()
+	require.Nil(t, rc.SetIdleTimeout(time.Minute))
+
+	_, err = rc.Write([]byte("a"))
+	require.Nil(t, err)
+	assert.Equal(t, "a", recvString(t, received))
+
+	// The server closes the connection, and the ReconnectingConn reconnects.
+	_, err = rc.Write([]byte("kill"))
+	require.Nil(t, err)
+	waitSignal(t, closed)
+	var conn tnet.Conn
+	select {
+	case conn = <-reconnecting:
+	case <-time.After(time.Second):
+		t.Fatal("wait reconnecting timeout")
+	}
+	assert.NotEqual(t, id, conn.(tnet.StatsConn).ID())
+	// The writes are queued until OnReconnect returns.
+	assert.False(t, rc.IsActive())
+	n, err := rc.Write([]byte("q"))
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+	_, err = rc.Read(make([]byte, 1))
+	assert.Equal(t, tnet.ErrReconnecting, err)
+	_, err = rc.Write(make([]byte, 1024))
+	assert.Equal(t, tnet.ErrReconnectQueueFull, err)
+	close(resume)
+
+	// The echo of the handshake arrives before the new connection is used, and it is
+	// handled after that, followed by the echo of the queued write.
+	var echoed string
+	for len(echoed) < len("hsq") {
+		echoed += recvString(t, received)
+	}
+	assert.Equal(t, "hsq", echoed)
+	assert.True(t, rc.IsActive())
+	assert.Equal(t, conn.LocalAddr(), rc.LocalAddr())
+	assert.Equal(t, "meta", rc.GetMetaData())
+	assert.Equal(t, id, rc.ID())
+	assert.Equal(t, id, rc.Stats().ID)
+
+	// Close stops reconnecting.
+	require.Nil(t, rc.Close())
+	waitSignal(t, closed)
+	assert.Equal(t, tnet.CloseReasonUser, rc.CloseReason())
+	<-rc.Context().Done()
+	_, err = rc.Write([]byte("a"))
+	assert.Equal(t, tnet.ErrConnClosed, err)
+	assert.False(t, conn.IsActive())
+}
+
+func TestReconnectingConn_FailWrites(t *testing.T) {
+	addr := newKillableEchoServer(t)
+	reconnecting, resume := make(chan struct{}, 1), make(chan struct{})
+	rc, err := tnet.DialReconnecting(context.Background(), "tcp", addr,
+		tnet.WithReconnectBackoff(10*time.Millisecond, 10*time.Millisecond),
+		tnet.WithReconnectJitter(0),
+		tnet.WithOnReconnect(func(conn tnet.Conn) error {
+			reconnecting <- struct{}{}
+			<-resume
+			return nil
+		}))
+	require.Nil(t, err)
+	defer rc.Close()
+
+	_, err = rc.Write([]byte("kill"))
+	require.Nil(t, err)
+	waitSignal(t, reconnecting)
+	_, err = rc.Write([]byte("a"))
+	assert.Equal(t, tnet.ErrReconnecting, err)
+	var cbErr error
+	_, err = rc.WritevWithCallback(func(err error) { cbErr = err }, []byte("a"))
+	assert.Equal(t, tnet.ErrReconnecting, err)
+	assert.Equal(t, tnet.ErrReconnecting, cbErr)
+	close(resume)
+
+	// Blocking reads work after reconnected.
+	require.Eventually(t, rc.IsActive, time.Second, 10*time.Millisecond)
+	_, err = rc.Write([]byte("b"))
+	require.Nil(t, err)
+	b, err := rc.ReadN(1)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("b"), b)
+}
+
+func TestReconnectingConn_DialError(t *testing.T) {
+	ln, err := net.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	addr := ln.Addr().String()
+	require.Nil(t, ln.Close())
+	_, err = tnet.DialReconnecting(context.Background(), "tcp", addr)
+	var opErr *net.OpError
+	assert.True(t, errors.As(err, &opErr))
+}
+
+func recvString(t *testing.T, ch chan string) string {
+	select {
+	case s := <-ch:
+		return s
+	case <-time.After(time.Second):
+		t.Fatal("wait data timeout")
+		return ""
+	}
+}
+
+func waitSignal(t *testing.T, ch chan struct{}) {
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("wait signal timeout")
+	}
+}
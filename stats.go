@@ -0,0 +1,128 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"time"
+
+	"go.uber.org/atomic"
+	"trpc.group/trpc-go/tnet/internal/asynctimer"
+)
+
+// ConnStats is a snapshot of the statistics of a connection.
+type ConnStats struct {
+	// ID is the process-unique id of the connection.
+	ID uint64
+	// Created is the time when the connection is created.
+	Created time.Time
+	// LastRead is the time of the last successful read syscall, it is zero if nothing is read.
+	// Its precision is 1 second.
+	LastRead time.Time
+	// LastWrite is the time of the last successful write syscall, it is zero if nothing is written.
+	// Its precision is 1 second.
+	LastWrite time.Time
+	// BytesIn is the number of bytes read from the socket.
+	BytesIn uint64
+	// BytesOut is the number of bytes written to the socket.
+	BytesOut uint64
+	// ReadCalls is the number of successful read syscalls.
+	ReadCalls uint64
+	// WriteCalls is the number of successful write syscalls.
+	WriteCalls uint64
+	// InBuffered is the number of bytes in the inbound buffer, which are not read by user yet.
+	InBuffered int
+	// OutBuffered is the number of bytes in the outbound buffer, which are not sent yet.
+	OutBuffered int
+}
+
+// lastConnID is the id of the last created connection.
+var lastConnID atomic.Uint64
+
+// connStats records the statistics of a connection, only atomic adds and stores are made on
+// each read/write syscall. The time is taken from the coarse clock of asynctimer, as time.Now
+// is too expensive for the path.
+type connStats struct {
+	id         uint64
+	created    int64
+	lastRead   atomic.Int64
+	lastWrite  atomic.Int64
+	bytesIn    atomic.Uint64
+	bytesOut   atomic.Uint64
+	readCalls  atomic.Uint64
+	writeCalls atomic.Uint64
+}
+
+func (s *connStats) init() {
+	s.id = lastConnID.Inc()
+	s.created = time.Now().UnixNano()
+}
+
+func (s *connStats) onRead(n int) {
+	s.readCalls.Inc()
+	s.bytesIn.Add(uint64(n))
+	s.lastRead.Store(asynctimer.NowUnixNano())
+}
+
+func (s *connStats) onWrite(n int) {
+	s.writeCalls.Inc()
+	s.bytesOut.Add(uint64(n))
+	s.lastWrite.Store(asynctimer.NowUnixNano())
+}
+
+func (s *connStats) snapshot() ConnStats {
+	return ConnStats{
+		ID:         s.id,
+		Created:    time.Unix(0, s.created),
+		LastRead:   unixNanoTime(s.lastRead.Load()),
+		LastWrite:  unixNanoTime(s.lastWrite.Load()),
+		BytesIn:    s.bytesIn.Load(),
+		BytesOut:   s.bytesOut.Load(),
+		ReadCalls:  s.readCalls.Load(),
+		WriteCalls: s.writeCalls.Load(),
+	}
+}
+
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// ID returns the process-unique id of the tcpconn.
+func (tc *tcpconn) ID() uint64 {
+	return tc.nfd.stats.id
+}
+
+// Stats returns the statistics snapshot of the tcpconn.
+func (tc *tcpconn) Stats() ConnStats {
+	s := tc.nfd.stats.snapshot()
+	s.InBuffered = tc.inBuffer.LenRead()
+	s.OutBuffered = tc.outBuffer.LenRead()
+	return s
+}
+
+// ID returns the process-unique id of the udpconn.
+func (uc *udpconn) ID() uint64 {
+	return uc.nfd.stats.id
+}
+
+// Stats returns the statistics snapshot of the udpconn, the buffered bytes of udpconn
+// include the addresses of the packets.
+func (uc *udpconn) Stats() ConnStats {
+	s := uc.nfd.stats.snapshot()
+	s.InBuffered = uc.inBuffer.LenRead()
+	s.OutBuffered = uc.outBuffer.LenRead()
+	return s
+}
@@ -0,0 +1,124 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+)
+
+func TestTCPConnStats(t *testing.T) {
+	ln, err := net.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	begin := time.Now()
+	conn, err := tnet.DialTCP("tcp", ln.Addr().String(), time.Second)
+	require.Nil(t, err)
+	defer conn.Close()
+	peer := <-accepted
+	defer peer.Close()
+
+	another, err := tnet.DialTCP("tcp", ln.Addr().String(), time.Second)
+	require.Nil(t, err)
+	defer another.Close()
+	assert.NotZero(t, conn.(tnet.StatsConn).ID())
+	assert.NotEqual(t, conn.(tnet.StatsConn).ID(), another.(tnet.StatsConn).ID())
+
+	stats := conn.(tnet.StatsConn).Stats()
+	assert.Equal(t, conn.(tnet.StatsConn).ID(), stats.ID)
+	assert.False(t, stats.Created.Before(begin.Truncate(time.Millisecond)))
+	assert.Zero(t, stats.ReadCalls)
+	assert.Zero(t, stats.WriteCalls)
+	assert.True(t, stats.LastRead.IsZero())
+	assert.True(t, stats.LastWrite.IsZero())
+
+	_, err = conn.Write(helloWorld)
+	require.Nil(t, err)
+	_, err = io.ReadFull(peer, make([]byte, len(helloWorld)))
+	require.Nil(t, err)
+	_, err = peer.Write(hello)
+	require.Nil(t, err)
+	_, err = conn.Peek(len(hello))
+	require.Nil(t, err)
+
+	stats = conn.(tnet.StatsConn).Stats()
+	assert.Equal(t, uint64(len(helloWorld)), stats.BytesOut)
+	assert.Equal(t, uint64(len(hello)), stats.BytesIn)
+	assert.Equal(t, uint64(1), stats.WriteCalls)
+	assert.Equal(t, uint64(1), stats.ReadCalls)
+	assert.Equal(t, len(hello), stats.InBuffered)
+	assert.Zero(t, stats.OutBuffered)
+	// The coarse clock is behind by up to 1 second.
+	assert.False(t, stats.LastRead.Before(begin.Add(-time.Second)))
+	assert.False(t, stats.LastWrite.Before(begin.Add(-time.Second)))
+	assert.False(t, stats.LastRead.After(time.Now()))
+
+	// The EOF is not counted as a read.
+	require.Nil(t, peer.Close())
+	_, err = conn.Peek(len(hello) + 1)
+	assert.NotNil(t, err)
+	stats = conn.(tnet.StatsConn).Stats()
+	assert.Equal(t, uint64(1), stats.ReadCalls)
+	assert.Equal(t, uint64(len(hello)), stats.BytesIn)
+}
+
+func TestUDPConnStats(t *testing.T) {
+	lns, err := tnet.ListenPackets("udp", getTestAddr(), false)
+	require.Nil(t, err)
+	received := make(chan tnet.ConnStats, 1)
+	s, err := tnet.NewUDPService(lns, func(conn tnet.PacketConn) error {
+		p, _, err := conn.ReadPacket()
+		if err != nil {
+			return err
+		}
+		p.Free()
+		received <- conn.(tnet.StatsConn).Stats()
+		return nil
+	})
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx)
+
+	conn, err := tnet.DialUDP("udp", lns[0].LocalAddr().String(), time.Second)
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(helloWorld)
+	require.Nil(t, err)
+	select {
+	case stats := <-received:
+		assert.Equal(t, uint64(len(helloWorld)), stats.BytesIn)
+		assert.Equal(t, uint64(1), stats.ReadCalls)
+		assert.NotZero(t, stats.ID)
+	case <-time.After(time.Second):
+		t.Fatal("wait udp packet timeout")
+	}
+	assert.Eventually(t, func() bool {
+		return conn.(tnet.StatsConn).Stats().BytesOut == uint64(len(helloWorld))
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,108 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package streammux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"trpc.group/trpc-go/tnet"
+)
+
+// The frame consists of a header of headerSize bytes and a payload of length bytes.
+//
+//	| version(1) | cmd(1) | flags(2) | stream id(4) | length(4) | payload(length) |
+//
+// All the integers are in big endian.
+const (
+	version    = 1
+	headerSize = 12
+	// maxPayloadSize limits the payload size of a frame, it prevents the peer from
+	// making the receiver allocate huge memory.
+	maxPayloadSize = 16 << 20
+)
+
+// The commands of frame.
+const (
+	// cmdSYN opens a stream.
+	cmdSYN byte = iota
+	// cmdFIN closes the writing side of a stream.
+	cmdFIN
+	// cmdPSH carries the data of a stream.
+	cmdPSH
+	// cmdNOP is the keepalive ping or pong, which is distinguished by the flags.
+	cmdNOP
+	// cmdUPD grows the send window of a stream, the payload is the 4 bytes increment.
+	cmdUPD
+	// cmdRST aborts a stream.
+	cmdRST
+	// cmdGOAWAY tells the peer not to open new streams, the payload is the 4 bytes code.
+	cmdGOAWAY
+)
+
+// The flags of cmdNOP.
+const (
+	flagPing uint16 = 1 << iota
+	flagPong
+)
+
+var errInvalidFrame = errors.New("streammux: invalid frame")
+
+type frameHeader struct {
+	cmd      byte
+	flags    uint16
+	streamID uint32
+	length   uint32
+}
+
+func encodeFrame(cmd byte, flags uint16, streamID uint32, payload []byte) []byte {
+	buf := make([]byte, headerSize+len(payload))
+	buf[0] = version
+	buf[1] = cmd
+	binary.BigEndian.PutUint16(buf[2:], flags)
+	binary.BigEndian.PutUint32(buf[4:], streamID)
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(payload)))
+	copy(buf[headerSize:], payload)
+	return buf
+}
+
+func parseHeader(b []byte) (frameHeader, error) {
+	h := frameHeader{
+		cmd:      b[1],
+		flags:    binary.BigEndian.Uint16(b[2:]),
+		streamID: binary.BigEndian.Uint32(b[4:]),
+		length:   binary.BigEndian.Uint32(b[8:]),
+	}
+	if b[0] != version {
+		return h, fmt.Errorf("%w: unsupported version %d", errInvalidFrame, b[0])
+	}
+	if h.length > maxPayloadSize {
+		return h, fmt.Errorf("%w: payload size %d is too large", errInvalidFrame, h.length)
+	}
+	return h, nil
+}
+
+// decodeFrame decodes a frame directly from the buffer of the tnet.Conn.
+func decodeFrame(r tnet.FrameReader) ([]byte, error) {
+	head, err := r.Peek(headerSize)
+	if err != nil {
+		return nil, err
+	}
+	h, err := parseHeader(head)
+	if err != nil {
+		return nil, err
+	}
+	return r.Next(headerSize + int(h.length))
+}
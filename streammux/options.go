@@ -0,0 +1,86 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package streammux
+
+import "time"
+
+const (
+	// initialWindow is the receive window of a new stream, which is known by both sides
+	// without negotiation. A larger window is granted by window update after opening.
+	initialWindow = 256 << 10
+
+	defaultMaxFrameSize      = 32 << 10
+	defaultAcceptBacklog     = 1024
+	defaultKeepAliveInterval = 10 * time.Second
+	defaultKeepAliveTimeout  = 30 * time.Second
+)
+
+type options struct {
+	maxReceiveWindow  int
+	maxFrameSize      int
+	acceptBacklog     int
+	keepAliveInterval time.Duration
+	keepAliveTimeout  time.Duration
+}
+
+func (o *options) setDefault() {
+	o.maxReceiveWindow = initialWindow
+	o.maxFrameSize = defaultMaxFrameSize
+	o.acceptBacklog = defaultAcceptBacklog
+	o.keepAliveInterval = defaultKeepAliveInterval
+	o.keepAliveTimeout = defaultKeepAliveTimeout
+}
+
+// Option is the option of Session.
+type Option func(*options)
+
+// WithMaxReceiveWindow sets the receive window of each stream, which is the max bytes the
+// peer can send before the data is read. It can't be smaller than 256KB, which is the default.
+func WithMaxReceiveWindow(n int) Option {
+	return func(o *options) {
+		if n > initialWindow {
+			o.maxReceiveWindow = n
+		}
+	}
+}
+
+// WithMaxFrameSize sets the max payload size of the data frames sent, default is 32KB.
+// A larger frame costs less overhead, and a smaller one is fairer to the other streams.
+func WithMaxFrameSize(n int) Option {
+	return func(o *options) {
+		if n > 0 && n <= maxPayloadSize {
+			o.maxFrameSize = n
+		}
+	}
+}
+
+// WithAcceptBacklog sets the max number of the opened streams waiting to be accepted,
+// the streams beyond it are reset. Default is 1024.
+func WithAcceptBacklog(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.acceptBacklog = n
+		}
+	}
+}
+
+// WithKeepAlive sets the keepalive of the session. A ping is sent every interval, and the session
+// is closed if nothing is received from the peer within timeout. The precision is 1 second.
+// Default interval is 10s and timeout is 30s, and interval <= 0 disables keepalive.
+func WithKeepAlive(interval, timeout time.Duration) Option {
+	return func(o *options) {
+		o.keepAliveInterval = interval
+		o.keepAliveTimeout = timeout
+	}
+}
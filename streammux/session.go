@@ -0,0 +1,393 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+// Package streammux multiplexes independent bidirectional byte streams over one connection,
+// such as a tnet.Conn or a tls connection. Each stream implements net.Conn and has its own flow
+// control window, so a slow stream doesn't block the others.
+package streammux
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+	"trpc.group/trpc-go/tnet"
+	"trpc.group/trpc-go/tnet/internal/asynctimer"
+	"trpc.group/trpc-go/tnet/log"
+)
+
+var (
+	// ErrSessionClosed is returned when the session is closed.
+	ErrSessionClosed = errors.New("streammux: session is closed")
+	// ErrGoAway is returned when opening a stream after the peer has sent GOAWAY.
+	ErrGoAway = errors.New("streammux: peer has gone away")
+	// ErrStreamReset is returned when the stream is reset by the peer.
+	ErrStreamReset = errors.New("streammux: stream is reset")
+	// ErrKeepAliveTimeout closes the session when nothing is received within the keepalive timeout.
+	ErrKeepAliveTimeout = errors.New("streammux: keepalive timeout")
+)
+
+// Session multiplexes the streams over one connection. The two sides of the connection must
+// be created by Client and Server respectively.
+type Session struct {
+	conn  net.Conn
+	opts  options
+	timer *asynctimer.Timer
+
+	// nextID is the id of the next opened stream, the client uses odd ids and the server uses even ids.
+	nextID  atomic.Uint32
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	accepts chan *Stream
+
+	localGoAway  atomic.Bool
+	remoteGoAway atomic.Bool
+	lastRecv     atomic.Int64
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Client creates the client side session over conn.
+// If conn is a tnet.Conn, its TCPHandler is replaced by the session to decode the frames
+// directly from the buffer of conn. Otherwise, the frames are read by a goroutine.
+func Client(conn net.Conn, opt ...Option) (*Session, error) {
+	return newSession(conn, true, opt...)
+}
+
+// Server creates the server side session over conn, see Client for how conn is read.
+func Server(conn net.Conn, opt ...Option) (*Session, error) {
+	return newSession(conn, false, opt...)
+}
+
+func newSession(conn net.Conn, client bool, opt ...Option) (*Session, error) {
+	if conn == nil {
+		return nil, errors.New("streammux: conn is nil")
+	}
+	s := &Session{
+		conn:    conn,
+		streams: make(map[uint32]*Stream),
+		closed:  make(chan struct{}),
+	}
+	s.opts.setDefault()
+	for _, o := range opt {
+		o(&s.opts)
+	}
+	s.accepts = make(chan *Stream, s.opts.acceptBacklog)
+	if client {
+		s.nextID.Store(1)
+	} else {
+		s.nextID.Store(2)
+	}
+	s.lastRecv.Store(time.Now().UnixNano())
+	tc, ok := conn.(tnet.Conn)
+	cc, hasContext := conn.(tnet.ContextConn)
+	if ok && hasContext {
+		if err := tc.SetOnRequest(tnet.NewFramedHandler(decodeFrame, s.onMessage, tnet.FrameInOrder)); err != nil {
+			return nil, err
+		}
+		go func() {
+			<-cc.Context().Done()
+			s.closeWithError(fmt.Errorf("%w: %s", ErrSessionClosed, cc.CloseReason()))
+		}()
+	} else {
+		go s.recvLoop()
+	}
+	if s.opts.keepAliveInterval > 0 {
+		interval := s.opts.keepAliveInterval
+		if interval < time.Second {
+			interval = time.Second
+		}
+		s.timer = asynctimer.NewTimer(s, onKeepAliveTimer, interval)
+		if err := asynctimer.Add(s.timer); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// OpenStream opens a new stream, which is accepted by AcceptStream of the peer.
+func (s *Session) OpenStream() (*Stream, error) {
+	if s.IsClosed() {
+		return nil, s.closeErr
+	}
+	if s.remoteGoAway.Load() {
+		return nil, ErrGoAway
+	}
+	id := s.nextID.Add(2) - 2
+	st := newStream(id, s)
+	s.mu.Lock()
+	s.streams[id] = st
+	s.mu.Unlock()
+	if err := s.writeFrame(cmdSYN, 0, id, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	if err := st.grantWindow(); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// AcceptStream waits for and returns the next stream opened by the peer.
+func (s *Session) AcceptStream() (*Stream, error) {
+	return s.AcceptStreamContext(context.Background())
+}
+
+// AcceptStreamContext is the same as AcceptStream, and it also stops waiting when ctx is done.
+func (s *Session) AcceptStreamContext(ctx context.Context) (*Stream, error) {
+	select {
+	case st := <-s.accepts:
+		return st, nil
+	case <-s.closed:
+		return nil, s.closeErr
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// GoAway tells the peer not to open new streams, the streams opened by the peer afterwards
+// are reset. The existing streams are not affected.
+func (s *Session) GoAway() error {
+	s.localGoAway.Store(true)
+	code := make([]byte, 4)
+	return s.writeFrame(cmdGOAWAY, 0, 0, code)
+}
+
+// NumStreams returns the number of the streams which are not closed.
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// IsClosed checks whether the session is closed.
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloseChan returns a channel which is closed when the session is closed.
+func (s *Session) CloseChan() <-chan struct{} {
+	return s.closed
+}
+
+// LocalAddr returns the local address of the underlying connection.
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying connection.
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// Close closes the session and the underlying connection, all the streams are closed.
+func (s *Session) Close() error {
+	s.closeWithError(ErrSessionClosed)
+	return nil
+}
+
+func (s *Session) closeWithError(err error) {
+	s.closeOnce.Do(func() {
+		s.closeErr = err
+		close(s.closed)
+		s.conn.Close()
+		s.mu.Lock()
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		if s.timer != nil {
+			asynctimer.Del(s.timer)
+			s.timer = nil
+		}
+		s.mu.Unlock()
+		for _, st := range streams {
+			st.notifyAll()
+		}
+	})
+}
+
+func (s *Session) writeFrame(cmd byte, flags uint16, streamID uint32, payload []byte) error {
+	if s.IsClosed() {
+		return s.closeErr
+	}
+	// The frame is a new buffer, so it is safe to be held by tnet without safe write.
+	frame := encodeFrame(cmd, flags, streamID, payload)
+	s.writeMu.Lock()
+	_, err := s.conn.Write(frame)
+	s.writeMu.Unlock()
+	if err != nil {
+		s.closeWithError(err)
+	}
+	return err
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+func (s *Session) getStream(id uint32) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+// onMessage handles the frame decoded from the buffer of the tnet.Conn,
+// the frame stops being valid after onMessage returns.
+func (s *Session) onMessage(_ tnet.Conn, frame []byte) error {
+	h, err := parseHeader(frame)
+	if err != nil {
+		return err
+	}
+	return s.handleFrame(h, frame[headerSize:])
+}
+
+// recvLoop reads the frames from a connection which is not a tnet.Conn.
+func (s *Session) recvLoop() {
+	r := bufio.NewReader(s.conn)
+	head := make([]byte, headerSize)
+	var payload []byte
+	for {
+		if _, err := io.ReadFull(r, head); err != nil {
+			s.closeWithError(fmt.Errorf("%w: %v", ErrSessionClosed, err))
+			return
+		}
+		h, err := parseHeader(head)
+		if err != nil {
+			s.closeWithError(err)
+			return
+		}
+		if cap(payload) < int(h.length) {
+			payload = make([]byte, h.length)
+		}
+		payload = payload[:h.length]
+		if _, err := io.ReadFull(r, payload); err != nil {
+			s.closeWithError(fmt.Errorf("%w: %v", ErrSessionClosed, err))
+			return
+		}
+		if err := s.handleFrame(h, payload); err != nil {
+			s.closeWithError(err)
+			return
+		}
+	}
+}
+
+// handleFrame handles a frame, payload stops being valid after it returns.
+// It never blocks, so a slow stream doesn't block the others.
+func (s *Session) handleFrame(h frameHeader, payload []byte) error {
+	s.lastRecv.Store(time.Now().UnixNano())
+	switch h.cmd {
+	case cmdSYN:
+		return s.handleSYN(h.streamID)
+	case cmdFIN:
+		if st := s.getStream(h.streamID); st != nil {
+			st.remoteClose()
+		}
+	case cmdPSH:
+		if st := s.getStream(h.streamID); st != nil {
+			return st.pushData(payload)
+		}
+	case cmdUPD:
+		if len(payload) != 4 {
+			return fmt.Errorf("%w: window update of length %d", errInvalidFrame, len(payload))
+		}
+		if st := s.getStream(h.streamID); st != nil {
+			st.growSendWindow(int(binary.BigEndian.Uint32(payload)))
+		}
+	case cmdRST:
+		if st := s.getStream(h.streamID); st != nil {
+			s.removeStream(h.streamID)
+			st.resetByPeer()
+		}
+	case cmdNOP:
+		if h.flags&flagPing != 0 {
+			// Reply in another goroutine, writing a generic net.Conn may block.
+			go s.writeFrame(cmdNOP, flagPong, 0, nil)
+		}
+	case cmdGOAWAY:
+		s.remoteGoAway.Store(true)
+	default:
+		return fmt.Errorf("%w: unknown command %d", errInvalidFrame, h.cmd)
+	}
+	return nil
+}
+
+func (s *Session) handleSYN(id uint32) error {
+	// The streams opened by the peer have the ids of the other parity.
+	if id == 0 || id%2 == s.nextID.Load()%2 {
+		return fmt.Errorf("%w: stream id %d of wrong parity", errInvalidFrame, id)
+	}
+	if s.localGoAway.Load() {
+		go s.writeFrame(cmdRST, 0, id, nil)
+		return nil
+	}
+	st := newStream(id, s)
+	s.mu.Lock()
+	if _, ok := s.streams[id]; ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: stream id %d is in use", errInvalidFrame, id)
+	}
+	s.streams[id] = st
+	s.mu.Unlock()
+	select {
+	case s.accepts <- st:
+		go st.grantWindow()
+	default:
+		log.Debugf("streammux: accept backlog is full, reset stream %d\n", id)
+		s.removeStream(id)
+		go s.writeFrame(cmdRST, 0, id, nil)
+	}
+	return nil
+}
+
+func onKeepAliveTimer(data interface{}) {
+	s, ok := data.(*Session)
+	if !ok || s == nil {
+		return
+	}
+	s.keepAlive()
+}
+
+func (s *Session) keepAlive() {
+	idle := time.Since(time.Unix(0, s.lastRecv.Load()))
+	if s.opts.keepAliveTimeout > 0 && idle > s.opts.keepAliveTimeout {
+		s.closeWithError(ErrKeepAliveTimeout)
+		return
+	}
+	s.mu.Lock()
+	if s.timer != nil {
+		if err := asynctimer.Add(s.timer); err != nil {
+			s.timer = nil
+		}
+	}
+	s.mu.Unlock()
+	go s.writeFrame(cmdNOP, flagPing, 0, nil)
+}
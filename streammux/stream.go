@@ -0,0 +1,444 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package streammux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Stream is a bidirectional byte stream of the Session, it implements net.Conn.
+type Stream struct {
+	id   uint32
+	sess *Session
+
+	mu sync.Mutex
+	// chunks is the received data, each chunk is a copy of the payload of a frame.
+	chunks   [][]byte
+	buffered int
+	// unacked is the length of the consumed data which hasn't been granted to the peer.
+	unacked    int
+	sendWindow int
+
+	remoteFin bool
+	localFin  bool
+	closed    bool
+	reset     bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readCh        chan struct{}
+	writeCh       chan struct{}
+}
+
+func newStream(id uint32, sess *Session) *Stream {
+	return &Stream{
+		id:         id,
+		sess:       sess,
+		sendWindow: initialWindow,
+		readCh:     make(chan struct{}, 1),
+		writeCh:    make(chan struct{}, 1),
+	}
+}
+
+// ID returns the id of the stream.
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Len returns the length of the received data which is not read yet.
+func (st *Stream) Len() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.buffered
+}
+
+// Read reads the received data into p.
+func (st *Stream) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	st.mu.Lock()
+	if err := st.waitReadLocked(1); err != nil {
+		st.mu.Unlock()
+		return 0, err
+	}
+	n := 0
+	for n < len(p) && len(st.chunks) > 0 {
+		m := copy(p[n:], st.chunks[0])
+		n += m
+		st.dropLocked(m)
+	}
+	return n, st.consumeAndUnlock(n)
+}
+
+// Peek returns the next n bytes without advancing the reader. It waits until n bytes are
+// received or error occurs. The bytes are owned by the stream and never reused, so they
+// stay valid after the following reads.
+func (st *Stream) Peek(n int) ([]byte, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err := st.waitReadLocked(n); err != nil {
+		return nil, err
+	}
+	return st.peekLocked(n), nil
+}
+
+// Next returns the next n bytes with advancing the reader. It waits until n bytes are
+// received or error occurs. The bytes are owned by the stream and never reused, so they
+// stay valid after the following reads.
+func (st *Stream) Next(n int) ([]byte, error) {
+	st.mu.Lock()
+	if err := st.waitReadLocked(n); err != nil {
+		st.mu.Unlock()
+		return nil, err
+	}
+	b := st.peekLocked(n)
+	st.dropLocked(n)
+	return b, st.consumeAndUnlock(n)
+}
+
+// Skip skips the next n bytes. It waits until n bytes are received or error occurs.
+func (st *Stream) Skip(n int) error {
+	st.mu.Lock()
+	if err := st.waitReadLocked(n); err != nil {
+		st.mu.Unlock()
+		return err
+	}
+	for m := n; m > 0; {
+		d := len(st.chunks[0])
+		if d > m {
+			d = m
+		}
+		st.dropLocked(d)
+		m -= d
+	}
+	return st.consumeAndUnlock(n)
+}
+
+// ReadN is similar to Next, except that the returned bytes are copied.
+func (st *Stream) ReadN(n int) ([]byte, error) {
+	b, err := st.Next(n)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), b...), nil
+}
+
+// waitReadLocked waits until n bytes are buffered.
+func (st *Stream) waitReadLocked(n int) error {
+	for st.buffered < n {
+		switch {
+		case st.closed:
+			return io.ErrClosedPipe
+		case st.reset:
+			return ErrStreamReset
+		case st.remoteFin:
+			if st.buffered == 0 {
+				return io.EOF
+			}
+			return io.ErrUnexpectedEOF
+		case st.sess.IsClosed():
+			return st.sess.closeErr
+		}
+		if err := st.waitLocked(st.readCh, st.readDeadline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitLocked unlocks st, waits for ch to be signaled or the deadline, and then locks st again.
+func (st *Stream) waitLocked(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	st.mu.Unlock()
+	defer st.mu.Lock()
+	select {
+	case <-ch:
+		return nil
+	case <-st.sess.closed:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// peekLocked returns the first n bytes, the chunks are merged if n spans several of them.
+func (st *Stream) peekLocked(n int) []byte {
+	if len(st.chunks[0]) >= n {
+		return st.chunks[0][:n]
+	}
+	merged := make([]byte, 0, n)
+	i := 0
+	for len(merged) < n {
+		merged = append(merged, st.chunks[i]...)
+		i++
+	}
+	// Keep the merged chunk in place of the merged ones.
+	st.chunks = append([][]byte{merged}, st.chunks[i:]...)
+	return merged[:n]
+}
+
+// dropLocked drops m bytes of the first chunk.
+func (st *Stream) dropLocked(m int) {
+	st.chunks[0] = st.chunks[0][m:]
+	if len(st.chunks[0]) == 0 {
+		st.chunks[0] = nil
+		st.chunks = st.chunks[1:]
+	}
+	st.buffered -= m
+}
+
+// consumeAndUnlock records the consumed n bytes and unlocks st. When half of the window
+// is consumed, it is granted to the peer again.
+func (st *Stream) consumeAndUnlock(n int) error {
+	if st.buffered > 0 {
+		// Wake up the other readers.
+		notify(st.readCh)
+	}
+	st.unacked += n
+	if st.unacked < st.sess.opts.maxReceiveWindow/2 || st.closed || st.remoteFin || st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	delta := st.unacked
+	st.unacked = 0
+	st.mu.Unlock()
+	return st.sendWindowUpdate(delta)
+}
+
+// grantWindow grants the window beyond the initial window to the peer.
+func (st *Stream) grantWindow() error {
+	if delta := st.sess.opts.maxReceiveWindow - initialWindow; delta > 0 {
+		return st.sendWindowUpdate(delta)
+	}
+	return nil
+}
+
+func (st *Stream) sendWindowUpdate(delta int) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(delta))
+	return st.sess.writeFrame(cmdUPD, 0, st.id, payload)
+}
+
+// Write writes p to the stream, it waits when the send window is used up.
+func (st *Stream) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		st.mu.Lock()
+		for st.sendWindow <= 0 {
+			if err := st.writableLocked(); err != nil {
+				st.mu.Unlock()
+				return n, err
+			}
+			if err := st.waitLocked(st.writeCh, st.writeDeadline); err != nil {
+				st.mu.Unlock()
+				return n, err
+			}
+		}
+		if err := st.writableLocked(); err != nil {
+			st.mu.Unlock()
+			return n, err
+		}
+		m := len(p)
+		if m > st.sendWindow {
+			m = st.sendWindow
+		}
+		if m > st.sess.opts.maxFrameSize {
+			m = st.sess.opts.maxFrameSize
+		}
+		st.sendWindow -= m
+		if st.sendWindow > 0 {
+			// Wake up the other writers.
+			notify(st.writeCh)
+		}
+		st.mu.Unlock()
+		if err := st.sess.writeFrame(cmdPSH, 0, st.id, p[:m]); err != nil {
+			return n, err
+		}
+		n += m
+		p = p[m:]
+	}
+	return n, nil
+}
+
+func (st *Stream) writableLocked() error {
+	switch {
+	case st.closed, st.localFin:
+		return io.ErrClosedPipe
+	case st.reset:
+		return ErrStreamReset
+	case st.sess.IsClosed():
+		return st.sess.closeErr
+	case !st.writeDeadline.IsZero() && !time.Now().Before(st.writeDeadline):
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+// CloseWrite closes the writing side of the stream, the peer reads io.EOF after the sent data.
+// The stream can still be read.
+func (st *Stream) CloseWrite() error {
+	st.mu.Lock()
+	if st.localFin || st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localFin = true
+	done := st.remoteFin
+	st.mu.Unlock()
+	st.notifyAll()
+	if done {
+		st.sess.removeStream(st.id)
+	}
+	return st.sess.writeFrame(cmdFIN, 0, st.id, nil)
+}
+
+// Close closes the stream, the unread data is dropped. The peer reads io.EOF after the sent data.
+// If the unread data is dropped or the peer hasn't closed its writing side, the stream is reset
+// instead, as no window update is sent for the data dropped, and the writing of the peer fails
+// with ErrStreamReset rather than blocking on the window.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	reset := !st.reset && (st.buffered > 0 || !st.remoteFin)
+	st.chunks, st.buffered = nil, 0
+	st.mu.Unlock()
+	if reset {
+		st.sess.removeStream(st.id)
+		st.notifyAll()
+		return st.sess.writeFrame(cmdRST, 0, st.id, nil)
+	}
+	err := st.CloseWrite()
+	// The data sent by the peer afterwards is dropped, so the stream is no longer tracked.
+	st.sess.removeStream(st.id)
+	st.notifyAll()
+	if err == io.ErrClosedPipe {
+		return nil
+	}
+	return err
+}
+
+// LocalAddr returns the local address of the session.
+func (st *Stream) LocalAddr() net.Addr {
+	return st.sess.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the session.
+func (st *Stream) RemoteAddr() net.Addr {
+	return st.sess.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the stream.
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline, st.writeDeadline = t, t
+	st.mu.Unlock()
+	st.notifyAll()
+	return nil
+}
+
+// SetReadDeadline sets the read deadline of the stream.
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+	notify(st.readCh)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of the stream.
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+	notify(st.writeCh)
+	return nil
+}
+
+// pushData appends the data received, which is copied as payload stops being valid after return.
+func (st *Stream) pushData(payload []byte) error {
+	if len(payload) == 0 {
+		return nil
+	}
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	if st.buffered+len(payload) > st.sess.opts.maxReceiveWindow {
+		st.mu.Unlock()
+		return fmt.Errorf("%w: stream %d exceeds the receive window", errInvalidFrame, st.id)
+	}
+	st.chunks = append(st.chunks, append([]byte(nil), payload...))
+	st.buffered += len(payload)
+	st.mu.Unlock()
+	notify(st.readCh)
+	return nil
+}
+
+func (st *Stream) growSendWindow(delta int) {
+	st.mu.Lock()
+	st.sendWindow += delta
+	st.mu.Unlock()
+	notify(st.writeCh)
+}
+
+func (st *Stream) remoteClose() {
+	st.mu.Lock()
+	st.remoteFin = true
+	done := st.localFin
+	st.mu.Unlock()
+	if done {
+		st.sess.removeStream(st.id)
+	}
+	notify(st.readCh)
+}
+
+func (st *Stream) resetByPeer() {
+	st.mu.Lock()
+	st.reset = true
+	st.mu.Unlock()
+	st.notifyAll()
+}
+
+func (st *Stream) notifyAll() {
+	notify(st.readCh)
+	notify(st.writeCh)
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
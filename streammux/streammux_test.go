@@ -0,0 +1,229 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package streammux_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/tnet"
+	"trpc.group/trpc-go/tnet/streammux"
+)
+
+// newTnetSessions creates a pair of sessions over tnet connections,
+// the streams accepted by the server session are passed to onStream.
+func newTnetSessions(t *testing.T, onStream func(*streammux.Stream)) (*streammux.Session, *streammux.Session) {
+	ln, err := tnet.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	servers := make(chan *streammux.Session, 1)
+	s, err := tnet.NewTCPService(ln, func(tnet.Conn) error { return nil },
+		tnet.WithOnTCPOpened(func(conn tnet.Conn) error {
+			sess, err := streammux.Server(conn)
+			if err != nil {
+				return err
+			}
+			go func() {
+				for {
+					st, err := sess.AcceptStream()
+					if err != nil {
+						return
+					}
+					go onStream(st)
+				}
+			}()
+			servers <- sess
+			return nil
+		}))
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Serve(ctx)
+
+	conn, err := tnet.DialTCP("tcp", ln.Addr().String(), time.Second)
+	require.Nil(t, err)
+	client, err := streammux.Client(conn)
+	require.Nil(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client, <-servers
+}
+
+func echo(st *streammux.Stream) {
+	io.Copy(st, st)
+	st.Close()
+}
+
+func TestSession_Echo(t *testing.T) {
+	client, _ := newTnetSessions(t, echo)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			st, err := client.OpenStream()
+			require.Nil(t, err)
+			defer st.Close()
+			// The data is larger than the window, so the window updates are required.
+			data := bytes.Repeat([]byte{byte(i)}, 1<<20)
+			go func() {
+				_, err := st.Write(data)
+				assert.Nil(t, err)
+				assert.Nil(t, st.CloseWrite())
+			}()
+			got, err := io.ReadAll(st)
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(data, got))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSession_SlowStream(t *testing.T) {
+	streams := make(chan *streammux.Stream, 2)
+	client, _ := newTnetSessions(t, func(st *streammux.Stream) { streams <- st })
+
+	slow, err := client.OpenStream()
+	require.Nil(t, err)
+	<-streams
+	// The slow stream isn't read by the peer, so writing blocks when the window is used up.
+	require.Nil(t, slow.SetWriteDeadline(time.Now().Add(200*time.Millisecond)))
+	n, err := slow.Write(make([]byte, 1<<20))
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	assert.Equal(t, 256<<10, n)
+
+	// The other streams are not blocked.
+	fast, err := client.OpenStream()
+	require.Nil(t, err)
+	go echo(<-streams)
+	_, err = fast.Write([]byte("hello"))
+	require.Nil(t, err)
+	got, err := fast.ReadN(5)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("hello"), got)
+	assert.Equal(t, 2, client.NumStreams())
+	require.Nil(t, fast.Close())
+	assert.Equal(t, 1, client.NumStreams())
+}
+
+func TestSession_CloseResetsPeer(t *testing.T) {
+	closed := make(chan struct{})
+	client, _ := newTnetSessions(t, func(st *streammux.Stream) {
+		st.Close()
+		close(closed)
+	})
+	st, err := client.OpenStream()
+	require.Nil(t, err)
+	defer st.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("wait stream close timeout")
+	}
+	// The peer closes the stream without reading, so the writing beyond the window fails
+	// instead of blocking.
+	require.Nil(t, st.SetWriteDeadline(time.Now().Add(3*time.Second)))
+	_, err = st.Write(make([]byte, 1<<20))
+	assert.True(t, errors.Is(err, streammux.ErrStreamReset), err)
+}
+
+func TestSession_NetConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, err := streammux.Client(c1, streammux.WithMaxReceiveWindow(1<<20), streammux.WithMaxFrameSize(3))
+	require.Nil(t, err)
+	defer client.Close()
+	server, err := streammux.Server(c2)
+	require.Nil(t, err)
+	defer server.Close()
+
+	st, err := client.OpenStream()
+	require.Nil(t, err)
+	assert.Equal(t, uint32(1), st.ID())
+	peer, err := server.AcceptStream()
+	require.Nil(t, err)
+	assert.Equal(t, st.ID(), peer.ID())
+
+	_, err = st.Write([]byte("hello world"))
+	require.Nil(t, err)
+	require.Nil(t, st.CloseWrite())
+	// The data is split into frames of 3 bytes, and Peek merges them.
+	b, err := peer.Peek(5)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("hello"), b)
+	require.Nil(t, peer.Skip(6))
+	b, err = peer.Next(5)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("world"), b)
+	_, err = peer.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	// The stream is still readable after CloseWrite.
+	_, err = peer.Write([]byte("bye"))
+	require.Nil(t, err)
+	b, err = st.ReadN(3)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("bye"), b)
+	_, err = st.Write([]byte("bye"))
+	assert.Equal(t, io.ErrClosedPipe, err)
+
+	require.Nil(t, st.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err = st.Read(make([]byte, 1))
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	require.Nil(t, peer.Close())
+	require.Nil(t, st.SetReadDeadline(time.Time{}))
+	_, err = st.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	// The peer stops new streams by GOAWAY.
+	require.Nil(t, server.GoAway())
+	assert.Eventually(t, func() bool {
+		_, err := client.OpenStream()
+		return errors.Is(err, streammux.ErrGoAway)
+	}, time.Second, 10*time.Millisecond)
+
+	// Closing the session closes the streams of both sides.
+	require.Nil(t, client.Close())
+	assert.True(t, client.IsClosed())
+	select {
+	case <-server.CloseChan():
+	case <-time.After(time.Second):
+		t.Fatal("the peer session is not closed")
+	}
+	_, err = server.AcceptStream()
+	assert.NotNil(t, err)
+}
+
+func TestSession_KeepAliveTimeout(t *testing.T) {
+	t.Parallel()
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	// The peer reads everything but replies nothing.
+	go io.Copy(io.Discard, c2)
+	client, err := streammux.Client(c1, streammux.WithKeepAlive(time.Second, 2*time.Second))
+	require.Nil(t, err)
+	select {
+	case <-client.CloseChan():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the session is not closed by keepalive timeout")
+	}
+	_, err = client.OpenStream()
+	assert.Equal(t, streammux.ErrKeepAliveTimeout, err)
+}
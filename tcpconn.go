@@ -14,6 +14,7 @@
 package tnet
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"net"
@@ -55,7 +56,16 @@ var (
 )
 
 // tcpconn must implements Conn interface.
-var _ Conn = (*tcpconn)(nil)
+var (
+	_ Conn              = (*tcpconn)(nil)
+	_ Corker            = (*tcpconn)(nil)
+	_ BlockReader       = (*tcpconn)(nil)
+	_ OwnedReader       = (*tcpconn)(nil)
+	_ CallbackWriter    = (*tcpconn)(nil)
+	_ ContextReadWriter = (*tcpconn)(nil)
+	_ ContextConn       = (*tcpconn)(nil)
+	_ StatsConn         = (*tcpconn)(nil)
+)
 
 type tcpconn struct {
 	service        *tcpservice
@@ -73,16 +83,27 @@ type tcpconn struct {
 	writeIdleTimer *asynctimer.Timer
 	readIdleTimer  *asynctimer.Timer
 	writevData     iovec.IOData
+	writeCallbacks writeCallbacks
 	nfd            netFD
 
 	closer
+	connContext
 	postpone            autopostpone.PostponeWrite
 	waitReadLen         atomic.Int32
+	corked              atomic.Int32
 	reading             locker.Locker
 	writing             locker.Locker
-	nonblocking         bool
+	nonblocking         atomic.Bool
 	safeWrite           bool
 	outboundBufferLimit int
+	panicHandler        PanicHandler
+	proxyHeader         *ProxyHeader
+	// interceptors wrap the TCPHandler set by SetOnRequest on the dialed or adopted connections,
+	// the handler of the service connections is wrapped by the service.
+	interceptors []TCPInterceptor
+	// fills counts the fills of inBuffer, by which tcpAsyncHandler knows if data arrives
+	// after the handler returns EAGAIN.
+	fills atomic.Uint64
 }
 
 // MassiveConnections denotes whether this is under heavy connections' scenario.
@@ -136,7 +157,12 @@ func (tc *tcpconn) Read(b []byte) (n int, err error) {
 }
 
 // ReadN reads fixed length of data from the tcpconn.
-func (tc *tcpconn) ReadN(n int) (bytes []byte, err error) {
+func (tc *tcpconn) ReadN(n int) ([]byte, error) {
+	return tc.ReadNContext(context.Background(), n)
+}
+
+// ReadNContext is the same as ReadN, and it stops waiting when ctx is done.
+func (tc *tcpconn) ReadNContext(ctx context.Context, n int) (bytes []byte, err error) {
 	isLocked := false
 	defer func() {
 		// release apiRead lock to avoid deadlock with closedFinished
@@ -153,7 +179,7 @@ func (tc *tcpconn) ReadN(n int) (bytes []byte, err error) {
 		return nil, ErrConnClosed
 	}
 
-	if err := tc.waitRead(n); err != nil {
+	if err := tc.waitReadContext(ctx, n); err != nil {
 		return nil, err
 	}
 	dst := make([]byte, n)
@@ -165,7 +191,12 @@ func (tc *tcpconn) ReadN(n int) (bytes []byte, err error) {
 }
 
 // Next reads fixed length of data from the tcpconn.
-func (tc *tcpconn) Next(n int) (bytes []byte, err error) {
+func (tc *tcpconn) Next(n int) ([]byte, error) {
+	return tc.NextContext(context.Background(), n)
+}
+
+// NextContext is the same as Next, and it stops waiting when ctx is done.
+func (tc *tcpconn) NextContext(ctx context.Context, n int) (bytes []byte, err error) {
 	isLocked := false
 	defer func() {
 		// release apiRead lock to avoid deadlock with closedFinished
@@ -183,7 +214,7 @@ func (tc *tcpconn) Next(n int) (bytes []byte, err error) {
 		return nil, ErrConnClosed
 	}
 
-	if err := tc.waitRead(n); err != nil {
+	if err := tc.waitReadContext(ctx, n); err != nil {
 		return nil, err
 	}
 	return tc.inBuffer.Next(n)
@@ -192,7 +223,12 @@ func (tc *tcpconn) Next(n int) (bytes []byte, err error) {
 // Peek returns the next n bytes without advancing the reader. It waits until it has
 // read at least n bytes or error has occurred such as connection closed or read timeout.
 // The bytes stop being valid at the next ReadN or Release call.
-func (tc *tcpconn) Peek(n int) (bytes []byte, err error) {
+func (tc *tcpconn) Peek(n int) ([]byte, error) {
+	return tc.PeekContext(context.Background(), n)
+}
+
+// PeekContext is the same as Peek, and it stops waiting when ctx is done.
+func (tc *tcpconn) PeekContext(ctx context.Context, n int) (bytes []byte, err error) {
 	isLocked := false
 	defer func() {
 		// release apiRead lock to avoid deadlock with closedFinished
@@ -210,12 +246,73 @@ func (tc *tcpconn) Peek(n int) (bytes []byte, err error) {
 		return nil, ErrConnClosed
 	}
 
-	if err := tc.waitRead(n); err != nil {
+	if err := tc.waitReadContext(ctx, n); err != nil {
 		return nil, err
 	}
 	return tc.inBuffer.Peek(n)
 }
 
+// PeekBlocks returns the next n bytes as blocks of the underlying buffer without advancing
+// the reader and without copying. It waits like Peek. The blocks stop being valid at the
+// next ReadN or Release call.
+func (tc *tcpconn) PeekBlocks(n int) (blocks [][]byte, err error) {
+	isLocked := false
+	defer func() {
+		// release apiRead lock to avoid deadlock with closedFinished
+		if isLocked {
+			tc.endJobSafely(apiRead)
+		}
+		// if conn is closed,  wait storeReadBuffer finished and read from closedReadBuf
+		if tc.needReadFromClosedReadBuf(err) {
+			tc.waitReadFromClosedReadBuf()
+			blocks, err = toBlocks(tc.closedReadBuf.Peek(n))
+		}
+	}()
+
+	if isLocked = tc.beginJobSafely(apiRead); !isLocked {
+		return nil, ErrConnClosed
+	}
+
+	if err := tc.waitRead(n); err != nil {
+		return nil, err
+	}
+	return tc.inBuffer.PeekBlocksN(n)
+}
+
+// NextBlocks returns the next n bytes as blocks of the underlying buffer with advancing
+// the reader and without copying. It waits like Next. The blocks stop being valid at the
+// next ReadN or Release call.
+func (tc *tcpconn) NextBlocks(n int) (blocks [][]byte, err error) {
+	isLocked := false
+	defer func() {
+		// release apiRead lock to avoid deadlock with closedFinished
+		if isLocked {
+			tc.endJobSafely(apiRead)
+		}
+		// if conn is closed,  wait storeReadBuffer finished and read from closedReadBuf
+		if tc.needReadFromClosedReadBuf(err) {
+			tc.waitReadFromClosedReadBuf()
+			blocks, err = toBlocks(tc.closedReadBuf.Next(n))
+		}
+	}()
+
+	if isLocked = tc.beginJobSafely(apiRead); !isLocked {
+		return nil, ErrConnClosed
+	}
+
+	if err := tc.waitRead(n); err != nil {
+		return nil, err
+	}
+	return tc.inBuffer.NextBlocksN(n)
+}
+
+func toBlocks(p []byte, err error) ([][]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{p}, nil
+}
+
 // Skip skips the next n bytes and advances the reader. It waits until the underlayer has at
 // least n bytes or error has occurred such as connection closed or read timeout.
 func (tc *tcpconn) Skip(n int) (err error) {
@@ -253,28 +350,44 @@ func (tc *tcpconn) Release() {
 }
 
 func (tc *tcpconn) waitRead(n int) error {
+	return tc.waitReadContext(context.Background(), n)
+}
+
+// waitReadContext waits until inBuffer has at least n bytes, it's woken up by
+// readTrigger, read timeout or ctx.
+func (tc *tcpconn) waitReadContext(ctx context.Context, n int) error {
 	if !tc.IsActive() {
 		return ErrConnClosed
 	}
 	if tc.inBuffer.LenRead() >= n {
 		return nil
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	tc.waitReadLen.Store(int32(n))
-	if tc.nonblocking {
+	if tc.nonblocking.Load() {
 		return EAGAIN
 	}
 
 	defer tc.waitReadLen.Store(0)
 	if tc.rtimer != nil && !tc.rtimer.IsZero() {
-		return tc.waitReadWithTimeout(n)
+		return tc.waitReadWithTimeout(ctx, n)
 	}
 
 	for tc.inBuffer.LenRead() < n {
 		if !tc.IsActive() {
 			return ErrConnClosed
 		}
-		<-tc.readTrigger
+		select {
+		case <-tc.readTrigger:
+		case <-ctx.Done():
+			if tc.inBuffer.LenRead() >= n {
+				return nil
+			}
+			return ctx.Err()
+		}
 	}
 	return nil
 }
@@ -289,7 +402,7 @@ func (tc *tcpconn) writeTimeoutErr() error {
 	return netError{error: err, isTimeout: true}
 }
 
-func (tc *tcpconn) waitReadWithTimeout(n int) error {
+func (tc *tcpconn) waitReadWithTimeout(ctx context.Context, n int) error {
 	tc.rtimer.Start()
 	select {
 	case <-tc.rtimer.Wait():
@@ -308,6 +421,11 @@ func (tc *tcpconn) waitReadWithTimeout(n int) error {
 				return nil
 			}
 			return tc.readTimeoutErr()
+		case <-ctx.Done():
+			if tc.inBuffer.LenRead() >= n {
+				return nil
+			}
+			return ctx.Err()
 		}
 	}
 	return nil
@@ -332,23 +450,70 @@ func (tc *tcpconn) Writev(p ...[]byte) (int, error) {
 	if err != nil {
 		tc.endJobSafely(apiWrite)
 		metrics.Add(metrics.TCPOutboundBufferLimitExceeded, 1)
-		tc.Close()
+		tc.closeWithReason(CloseReasonLimitExceeded)
 		return n, err
 	}
-	if tc.postpone.Enabled() {
-		err = tc.notify()
-	} else {
-		err = tc.flush()
+	// The data is kept in outBuffer until the last Uncork.
+	if tc.corked.Load() > 0 {
+		tc.endJobSafely(apiWrite)
+		return n, nil
 	}
-	if err != nil {
+	if err := tc.send(); err != nil {
 		tc.endJobSafely(apiWrite)
-		tc.Close()
+		tc.closeWithReason(closeReasonOf(err))
 		return n, err
 	}
 	tc.endJobSafely(apiWrite)
 	return n, nil
 }
 
+// WritevContext is the same as Writev, and it returns the error of ctx without
+// writing if ctx is done. Writev never blocks on the network, data is sent asynchronously.
+func (tc *tcpconn) WritevContext(ctx context.Context, p ...[]byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return tc.Writev(p...)
+}
+
+// Cork holds back the sending of the following Write/Writev calls, the data is collected
+// in the outbound buffer until the matching Uncork.
+func (tc *tcpconn) Cork() {
+	tc.corked.Inc()
+}
+
+// Uncork ends a Cork, the collected data is sent at once when the last Cork ends.
+func (tc *tcpconn) Uncork() error {
+	corked := tc.corked.Dec()
+	if corked < 0 {
+		tc.corked.Inc()
+		return errors.New("uncork without cork")
+	}
+	if corked > 0 {
+		return nil
+	}
+	if !tc.beginJobSafely(apiWrite) {
+		return ErrConnClosed
+	}
+	defer tc.endJobSafely(apiWrite)
+	if tc.outBuffer.LenRead() == 0 {
+		return nil
+	}
+	if err := tc.send(); err != nil {
+		tc.closeWithReason(closeReasonOf(err))
+		return err
+	}
+	return nil
+}
+
+// send sends the data in outBuffer by notifying poller or flushing directly.
+func (tc *tcpconn) send() error {
+	if tc.postpone.Enabled() {
+		return tc.notify()
+	}
+	return tc.flush()
+}
+
 func (tc *tcpconn) writeToOutboundBuffer(p ...[]byte) (int, error) {
 	if tc == nil {
 		return 0, ErrConnClosed
@@ -382,6 +547,7 @@ func (tc *tcpconn) writeToNetFD() error {
 		return errors.Wrap(err, fmt.Sprintf("tcpconn output buffer skip %d", n))
 	}
 	tc.outBuffer.Release()
+	tc.writeCallbacks.onSent(n)
 	return nil
 }
 
@@ -452,6 +618,12 @@ func (tc *tcpconn) flush() error {
 
 // Close closes the tcpconn safely, it can be called multiple times concurrently.
 func (tc *tcpconn) Close() error {
+	return tc.closeWithReason(CloseReasonUser)
+}
+
+// closeWithReason closes the tcpconn, the reason takes effect only if it is the first close.
+func (tc *tcpconn) closeWithReason(reason CloseReason) error {
+	tc.setCloseReason(reason)
 	// mark conn as closed and close read trigger firstly
 	// to release apiRead lock
 	if !tc.beginJobSafely(closeAll) {
@@ -472,10 +644,12 @@ func (tc *tcpconn) Close() error {
 	// close before closeHandle to avoid Read/ReadN/Peek/Next/Skip blocked in closeHandle.
 	// close after storeReadBuffer to make sure storeReadBuffer finished.
 	close(tc.closedFinished)
+	// Cancel the context before onClosed, so that the close reason is visible to it.
+	tc.cancelContext()
 
 	// Execute user-defined closing process.
 	if closeHandle := tc.getOnClosed(); closeHandle != nil {
-		closeHandle(tc)
+		tc.callTCPHandler(TCPHandler(closeHandle))
 	}
 	// Stop all timers.
 	if tc.rtimer != nil {
@@ -502,6 +676,8 @@ func (tc *tcpconn) Close() error {
 	// Free input/output buffer.
 	tc.inBuffer.Free()
 	tc.outBuffer.Free()
+	// Fire the write callbacks whose data is not sent.
+	tc.writeCallbacks.closeAll(ErrConnClosed)
 	metrics.Add(metrics.TCPConnsClose, 1)
 	return nil
 }
@@ -550,7 +726,7 @@ func (tc *tcpconn) SetOnRequest(handle TCPHandler) error {
 	if handle == nil {
 		return errors.New("handle can't be nil")
 	}
-	tc.reqHandle.Store(handle)
+	tc.reqHandle.Store(chainTCPInterceptors(tc.interceptors, HookRequest, handle))
 	return nil
 }
 
@@ -640,7 +816,7 @@ func (tc *tcpconn) SetWriteIdleTimeout(d time.Duration) error {
 	if d <= 0 {
 		return nil
 	}
-	tc.writeIdleTimer = asynctimer.NewTimer(tc, tcpOnIdle, d)
+	tc.writeIdleTimer = asynctimer.NewTimer(tc, tcpOnWriteIdle, d)
 
 	if err := asynctimer.Add(tc.writeIdleTimer); err != nil {
 		return fmt.Errorf("tcp connection set write idle timeout asynctimer add error: %w", err)
@@ -660,7 +836,7 @@ func (tc *tcpconn) SetReadIdleTimeout(d time.Duration) error {
 	if d <= 0 {
 		return nil
 	}
-	tc.readIdleTimer = asynctimer.NewTimer(tc, tcpOnIdle, d)
+	tc.readIdleTimer = asynctimer.NewTimer(tc, tcpOnReadIdle, d)
 
 	if err := asynctimer.Add(tc.readIdleTimer); err != nil {
 		return fmt.Errorf("tcp connection set read idle timeout asynctimer add error: %w", err)
@@ -671,7 +847,7 @@ func (tc *tcpconn) SetReadIdleTimeout(d time.Duration) error {
 // SetNonBlocking sets conn to nonblocking. Read APIs will return EAGAIN when there is not
 // enough data for reading.
 func (tc *tcpconn) SetNonBlocking(nonblock bool) {
-	tc.nonblocking = nonblock
+	tc.nonblocking.Store(nonblock)
 }
 
 // SetFlushWrite sets whether to flush the data or not.
@@ -693,6 +869,31 @@ func (tc *tcpconn) SetSafeWrite(safeWrite bool) {
 	tc.safeWrite = safeWrite
 }
 
+// applyOptions applies the connection level options, it is called before tc is scheduled,
+// for both the accepted and the dialed connections.
+func (tc *tcpconn) applyOptions(opts *options) error {
+	tc.panicHandler = opts.panicHandler
+	if err := tc.SetKeepAlive(opts.tcpKeepAlive); err != nil {
+		return fmt.Errorf("tnet connection set keep alive error: %w", err)
+	}
+	if err := tc.SetIdleTimeout(opts.tcpIdleTimeout); err != nil {
+		return fmt.Errorf("tnet connection set idle timeout error: %w", err)
+	}
+	if err := tc.SetWriteIdleTimeout(opts.tcpWriteIdleTimeout); err != nil {
+		return fmt.Errorf("tnet connection set write idle timeout error: %w", err)
+	}
+	if err := tc.SetReadIdleTimeout(opts.tcpReadIdleTimeout); err != nil {
+		return fmt.Errorf("tnet connection set read idle timeout error: %w", err)
+	}
+	tc.outboundBufferLimit = opts.tcpOutboundBufferLimit
+	tc.SetNonBlocking(opts.nonblocking)
+	tc.SetSafeWrite(opts.safeWrite)
+	if opts.onTCPClosed != nil {
+		tc.SetOnClosed(opts.onTCPClosed)
+	}
+	return nil
+}
+
 func (tc *tcpconn) getOnRequest() TCPHandler {
 	handler := tc.reqHandle.Load()
 	if handler == nil {
@@ -745,11 +946,21 @@ func (tc *tcpconn) refreshConn() error {
 }
 
 func tcpOnIdle(data interface{}) {
-	c, ok := data.(Conn)
-	if !ok {
-		return
+	if tc, ok := data.(*tcpconn); ok && tc != nil {
+		tc.closeWithReason(CloseReasonIdleTimeout)
+	}
+}
+
+func tcpOnReadIdle(data interface{}) {
+	if tc, ok := data.(*tcpconn); ok && tc != nil {
+		tc.closeWithReason(CloseReasonReadIdleTimeout)
+	}
+}
+
+func tcpOnWriteIdle(data interface{}) {
+	if tc, ok := data.(*tcpconn); ok && tc != nil {
+		tc.closeWithReason(CloseReasonWriteIdleTimeout)
 	}
-	c.Close()
 }
 
 func tcpOnRead(data interface{}, ioData *iovec.IOData) error {
@@ -769,10 +980,13 @@ func tcpOnRead(data interface{}, ioData *iovec.IOData) error {
 		if errors.Is(err, buffer.ErrBufferFull) {
 			return nil
 		}
+		// The poller closes the conn by tcpOnHup.
+		tc.setCloseReason(closeReasonOf(err))
 		return err
 	}
+	tc.fills.Inc()
 
-	if tc.nonblocking {
+	if tc.nonblocking.Load() {
 		return tcpSyncHandle(tc)
 	}
 	// Wake up one reading blocked goroutine.
@@ -809,6 +1023,8 @@ func tcpOnWrite(data interface{}) error {
 		if errors.Is(err, unix.EAGAIN) {
 			return nil
 		}
+		// The poller closes the conn by tcpOnHup.
+		tc.setCloseReason(closeReasonOf(err))
 		return err
 	}
 	// Waiting for next OnWrite Event to write the left data.
@@ -833,7 +1049,7 @@ func tcpOnWrite(data interface{}) error {
 func tcpOnHup(data interface{}) {
 	tc, ok := data.(*tcpconn)
 	if ok && tc != nil {
-		tc.Close()
+		tc.closeWithReason(CloseReasonEOF)
 	}
 }
 
@@ -843,23 +1059,53 @@ func tcpAsyncHandler(conn *tcpconn) {
 		return
 	}
 	for {
+		// fills is the number of the fills of the inbound buffer that the handler has seen
+		// when it returned EAGAIN, the handler is called again only after a new fill.
+		var (
+			fills   uint64
+			waiting bool
+		)
 		for conn.Len() > 0 && conn.IsActive() {
-			if err := handler(conn); err != nil {
-				log.Debugf("tcpAsyncHandler err: %v\n", err)
-				conn.reading.Unlock()
-				conn.Close()
-				return
+			// Sample the counter before calling the handler, so that data arriving while the
+			// handler runs is never mistaken for data the handler has already seen.
+			seen := conn.fills.Load()
+			err := conn.callTCPHandler(handler)
+			if err == nil {
+				continue
+			}
+			if errors.Is(err, EAGAIN) {
+				fills, waiting = seen, true
+				break
 			}
+			log.Debugf("tcpAsyncHandler err: %v\n", err)
+			conn.reading.Unlock()
+			conn.closeWithReason(CloseReasonHandlerError)
+			return
 		}
 		conn.reading.Unlock()
 		conn.postpone.ResetReadingTryLockFail()
 		// Check again to prevent packet loss because conn may receive data before Unlock.
-		if conn.Len() <= 0 || !conn.reading.TryLock() {
+		if conn.Len() <= 0 || (waiting && conn.fills.Load() == fills) || !conn.reading.TryLock() {
 			return
 		}
 	}
 }
 
+// triggerRequest handles the data already in the inbound buffer by the request handler,
+// which is otherwise handled only when more data arrives.
+func (tc *tcpconn) triggerRequest() {
+	// The sync handler runs in the poller, it can't be triggered by other goroutines.
+	if tc.nonblocking.Load() || tc.Len() == 0 || tc.getOnRequest() == nil {
+		return
+	}
+	if !tc.reading.TryLock() {
+		return
+	}
+	if err := doTask(tc); err != nil {
+		tc.reading.Unlock()
+	}
+}
+
 func tcpSyncHandle(conn *tcpconn) error {
 	handler := conn.getOnRequest()
 	if handler == nil {
@@ -868,13 +1114,15 @@ func tcpSyncHandle(conn *tcpconn) error {
 	conn.postpone.ResetLoopCnt()
 	for conn.Len() > 0 && conn.IsActive() {
 		conn.postpone.IncLoopCnt()
-		err := handler(conn)
+		err := conn.callTCPHandler(handler)
 		if err == nil {
 			continue
 		}
 		if errors.Is(err, EAGAIN) {
 			return nil
 		}
+		// The poller closes the conn by tcpOnHup.
+		conn.setCloseReason(CloseReasonHandlerError)
 		return err
 	}
 	conn.postpone.CheckLoopCnt()
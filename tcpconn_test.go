@@ -14,6 +14,7 @@
 package tnet_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -460,6 +461,40 @@ func TestConnWrite_ServHandleErr(t *testing.T) {
 	})
 }
 
+func TestConnRead_HandlerEAGAIN(t *testing.T) {
+	doTestCase(t, testCase{
+		name: "server keeps connection when handler returns EAGAIN",
+		servHandle: func(t *testing.T, conn tnet.Conn, ch chan int) error {
+			if conn.Len() < len(helloWorld) {
+				return tnet.EAGAIN
+			}
+			b, err := conn.Next(len(helloWorld))
+			if err != nil {
+				return err
+			}
+			_, err = conn.Write(append([]byte(nil), b...))
+			return err
+		},
+		clientHandle: func(t *testing.T, conn net.Conn, ch chan int) {
+			// The handler returns EAGAIN for the first part, and processes the whole
+			// message once the rest arrives.
+			_, err := conn.Write(hello)
+			require.Nil(t, err)
+			time.Sleep(10 * time.Millisecond)
+			_, err = conn.Write(world)
+			require.Nil(t, err)
+			rsp := make([]byte, len(helloWorld))
+			require.Nil(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+			_, err = io.ReadFull(conn, rsp)
+			require.Nil(t, err)
+			assert.Equal(t, helloWorld, rsp)
+		},
+		ctrlHandle: func(t *testing.T, server tnet.Conn, client net.Conn, ch chan int) {
+			assert.True(t, server.IsActive())
+		},
+	})
+}
+
 var testTCPPKGNum = 10000
 
 func clientWriteAndReadData(t *testing.T, conn net.Conn, ch chan int) {
@@ -1149,3 +1184,231 @@ func TestResetIdleTimeout(t *testing.T) {
 	time.Sleep(idleTimeout * 2)
 	require.True(t, conn.IsActive())
 }
+
+func TestTCPConnCork(t *testing.T) {
+	ln, err := net.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	conn, err := tnet.DialTCP("tcp", ln.Addr().String(), time.Second)
+	require.Nil(t, err)
+	defer conn.Close()
+	peer := <-accepted
+	defer peer.Close()
+
+	corker, ok := conn.(tnet.Corker)
+	require.True(t, ok)
+	require.NotNil(t, corker.Uncork())
+	corker.Cork()
+	corker.Cork()
+	var wg sync.WaitGroup
+	for _, p := range [][]byte{hello, world} {
+		wg.Add(1)
+		go func(p []byte) {
+			defer wg.Done()
+			_, err := conn.Write(p)
+			assert.Nil(t, err)
+		}(p)
+	}
+	wg.Wait()
+	require.Equal(t, len(hello)+len(world), tnet.OutboundBuffered(conn))
+	require.Nil(t, corker.Uncork())
+	require.Equal(t, len(hello)+len(world), tnet.OutboundBuffered(conn))
+	require.Nil(t, corker.Uncork())
+
+	buf := make([]byte, len(hello)+len(world))
+	require.Nil(t, peer.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = io.ReadFull(peer, buf)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []byte("helloworld"), buf)
+	assert.Equal(t, 0, tnet.OutboundBuffered(conn))
+}
+
+func TestTCPConnPeekAndNextBlocks(t *testing.T) {
+	doTestCase(t, testCase{
+		name: "peek and next blocks",
+		servHandle: func(t *testing.T, conn tnet.Conn, ch chan int) error {
+			r, ok := conn.(tnet.BlockReader)
+			require.True(t, ok)
+			blocks, err := r.PeekBlocks(len(helloWorld))
+			assert.Nil(t, err)
+			assert.Equal(t, helloWorld, bytes.Join(blocks, nil))
+			blocks, err = r.NextBlocks(len(hello))
+			assert.Nil(t, err)
+			assert.Equal(t, hello, bytes.Join(blocks, nil))
+			blocks, err = r.NextBlocks(len(world))
+			assert.Nil(t, err)
+			assert.Equal(t, world, bytes.Join(blocks, nil))
+			conn.Release()
+			ch <- 1
+			return nil
+		},
+		clientHandle: func(t *testing.T, conn net.Conn, ch chan int) {
+			_, err := conn.Write(helloWorld)
+			assert.Nil(t, err)
+			<-ch
+		},
+	})
+}
+
+func TestTCPConnNextOwned(t *testing.T) {
+	doTestCase(t, testCase{
+		name: "next owned",
+		servHandle: func(t *testing.T, conn tnet.Conn, ch chan int) error {
+			r, ok := conn.(tnet.OwnedReader)
+			require.True(t, ok)
+			owned, err := r.NextOwned(len(hello))
+			assert.Nil(t, err)
+			rest, err := conn.Next(len(world))
+			assert.Nil(t, err)
+			assert.Equal(t, world, rest)
+			conn.Release()
+			// The owned bytes are still valid after Release.
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				assert.Equal(t, len(hello), owned.Len())
+				assert.Equal(t, hello, owned.Bytes())
+				assert.Equal(t, hello, bytes.Join(owned.Blocks(), nil))
+				owned.Free()
+				owned.Free()
+				assert.Zero(t, owned.Len())
+				assert.Nil(t, owned.Bytes())
+				assert.Nil(t, owned.Blocks())
+			}()
+			<-done
+			ch <- 1
+			return nil
+		},
+		clientHandle: func(t *testing.T, conn net.Conn, ch chan int) {
+			_, err := conn.Write(helloWorld)
+			assert.Nil(t, err)
+			<-ch
+		},
+	})
+}
+
+func TestTCPConnWritevWithCallback(t *testing.T) {
+	ln, err := net.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	conn, err := tnet.DialTCP("tcp", ln.Addr().String(), time.Second)
+	require.Nil(t, err)
+	conn.SetSafeWrite(false)
+	w, ok := conn.(tnet.CallbackWriter)
+	require.True(t, ok)
+	peer := <-accepted
+	defer peer.Close()
+
+	done := make(chan error, 3)
+	callback := func(err error) { done <- err }
+	wait := func() error {
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(time.Second):
+			t.Fatal("wait write callback timeout")
+			return nil
+		}
+	}
+	p := append([]byte(nil), hello...)
+	n, err := w.WritevWithCallback(callback, p)
+	require.Nil(t, err)
+	require.Equal(t, len(hello), n)
+	require.Nil(t, wait())
+	// The slice can be reused once the callback fires.
+	copy(p, world)
+	_, err = w.WritevWithCallback(callback, p)
+	require.Nil(t, err)
+	require.Nil(t, wait())
+
+	buf := make([]byte, len(hello)+len(world))
+	require.Nil(t, peer.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = io.ReadFull(peer, buf)
+	require.Nil(t, err)
+	assert.Equal(t, helloWorld, buf)
+
+	// The callback of the corked data fires with error when conn is closed.
+	conn.(tnet.Corker).Cork()
+	_, err = w.WritevWithCallback(callback, hello)
+	require.Nil(t, err)
+	select {
+	case <-done:
+		t.Fatal("callback of corked data fired")
+	case <-time.After(10 * time.Millisecond):
+	}
+	require.Nil(t, conn.Close())
+	assert.Equal(t, tnet.ErrConnClosed, wait())
+
+	_, err = w.WritevWithCallback(callback, hello)
+	assert.Equal(t, tnet.ErrConnClosed, err)
+	assert.Equal(t, tnet.ErrConnClosed, wait())
+}
+
+func TestTCPConnContextRead(t *testing.T) {
+	ln, err := net.Listen("tcp", getTestAddr())
+	require.Nil(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	conn, err := tnet.DialTCP("tcp", ln.Addr().String(), time.Second)
+	require.Nil(t, err)
+	defer conn.Close()
+	peer := <-accepted
+	defer peer.Close()
+	rw, ok := conn.(tnet.ContextReadWriter)
+	require.True(t, ok)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = rw.NextContext(ctx, len(hello))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// ctx is honored together with the read deadline.
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(time.Hour)))
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err = rw.PeekContext(ctx, len(hello))
+	assert.Equal(t, context.Canceled, err)
+	_, err = rw.ReadNContext(ctx, len(hello))
+	assert.Equal(t, context.Canceled, err)
+	_, err = rw.WritevContext(ctx, hello)
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = peer.Write(helloWorld)
+	require.Nil(t, err)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	p, err := rw.PeekContext(ctx, len(helloWorld))
+	require.Nil(t, err)
+	assert.Equal(t, helloWorld, p)
+	p, err = rw.ReadNContext(ctx, len(hello))
+	require.Nil(t, err)
+	assert.Equal(t, hello, p)
+	p, err = rw.NextContext(ctx, len(world))
+	require.Nil(t, err)
+	assert.Equal(t, world, p)
+	_, err = rw.WritevContext(ctx, hello)
+	assert.Nil(t, err)
+}
@@ -17,16 +17,32 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"sync"
+	"time"
 
+	"go.uber.org/atomic"
 	"golang.org/x/sys/unix"
 	"trpc.group/trpc-go/tnet/internal/cache/systype"
 	"trpc.group/trpc-go/tnet/internal/iovec"
 	"trpc.group/trpc-go/tnet/internal/netutil"
+	"trpc.group/trpc-go/tnet/internal/poller"
 	"trpc.group/trpc-go/tnet/metrics"
 )
 
 type tcpListener struct {
 	nfd netFD
+
+	// The fields below are used by the blocking Accept.
+	acceptMu   sync.Mutex
+	scheduled  bool
+	watching   atomic.Bool
+	readable   chan struct{}
+	closeCh    chan struct{}
+	closeOnce  sync.Once
+	deadlineMu sync.Mutex
+	deadline   time.Time
+	deadlineCh chan struct{}
 }
 
 type netError struct {
@@ -57,10 +73,121 @@ type temporary interface {
 	Temporary() bool
 }
 
-// Accept implements tcp listener's accept method.
+// Accept waits for and returns the next connection to the listener. It parks on the
+// readiness of the listener in tnet poller, and honors Close and SetDeadline. The returned
+// connection can be used as an ordinary net.Conn in goroutine-per-connection code, so the
+// listener can be passed to http.Serve and the like.
+// Do not call Accept on the listener served by NewTCPService.
 func (t *tcpListener) Accept() (net.Conn, error) {
-	// TODO: how to support blocking mode
-	return t.accept(nil)
+	t.acceptMu.Lock()
+	defer t.acceptMu.Unlock()
+	for {
+		if t.nfd.closed.Load() {
+			return nil, t.opError(net.ErrClosed)
+		}
+		deadline := t.getDeadline()
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return nil, t.opError(os.ErrDeadlineExceeded)
+		}
+		conn, err := t.accept(nil)
+		if err == nil {
+			return conn, nil
+		}
+		if ne, ok := err.(netError); !ok || !errors.Is(ne.error, unix.EAGAIN) {
+			return nil, t.opError(err)
+		}
+		if err := t.waitReadable(deadline); err != nil {
+			return nil, t.opError(err)
+		}
+	}
+}
+
+// waitReadable waits until the listener is readable, closed, or the deadline is changed
+// or exceeded. The listener is only watched by the poller while Accept is waiting, so
+// that the pending connections don't wake up the poller over and over again.
+func (t *tcpListener) waitReadable(deadline time.Time) error {
+	t.watching.Store(true)
+	var err error
+	switch {
+	case t.nfd.closed.Load():
+		err = net.ErrClosed
+	case !t.scheduled:
+		err = t.nfd.Schedule(tcpListenerOnRead, nil, tcpListenerOnHup, t)
+		t.scheduled = err == nil
+	default:
+		err = t.nfd.Control(poller.Readable)
+	}
+	if err != nil {
+		t.watching.Store(false)
+		if t.nfd.closed.Load() {
+			return net.ErrClosed
+		}
+		return err
+	}
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-t.readable:
+	case <-t.closeCh:
+	case <-t.deadlineCh:
+	case <-timeout:
+	}
+	t.unwatch()
+	return nil
+}
+
+// unwatch removes the listener from the poller if it is still watched.
+func (t *tcpListener) unwatch() {
+	if t.watching.CAS(true, false) {
+		_ = t.nfd.Control(poller.Detach)
+	}
+}
+
+func tcpListenerOnRead(data interface{}, _ *iovec.IOData) error {
+	t, ok := data.(*tcpListener)
+	if !ok || t == nil {
+		panic(fmt.Sprintf("bug: data is not *tcpListener type (%v) or t is nil pointer (%v)", !ok, t == nil))
+	}
+	t.unwatch()
+	select {
+	case t.readable <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func tcpListenerOnHup(data interface{}) {
+	_ = tcpListenerOnRead(data, nil)
+}
+
+// SetDeadline sets the deadline of Accept, the same as net.TCPListener.SetDeadline.
+// A zero value for tm means Accept will not time out.
+func (t *tcpListener) SetDeadline(tm time.Time) error {
+	if t.nfd.closed.Load() {
+		return t.opError(net.ErrClosed)
+	}
+	t.deadlineMu.Lock()
+	t.deadline = tm
+	t.deadlineMu.Unlock()
+	select {
+	case t.deadlineCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (t *tcpListener) getDeadline() time.Time {
+	t.deadlineMu.Lock()
+	defer t.deadlineMu.Unlock()
+	return t.deadline
+}
+
+func (t *tcpListener) opError(err error) error {
+	return &net.OpError{Op: "accept", Net: t.nfd.network, Addr: t.nfd.laddr, Err: err}
 }
 
 func (t *tcpListener) accept(handle OnTCPOpened) (net.Conn, error) {
@@ -93,12 +220,13 @@ func (t *tcpListener) accept(handle OnTCPOpened) (net.Conn, error) {
 	if !MassiveConnections.Load() {
 		conn.writevData = iovec.NewIOData(iovec.WithLength(systype.MaxLen))
 	}
+	conn.nfd.stats.init()
 	conn.inBuffer.Initialize()
 	conn.outBuffer.Initialize()
 	conn.closedReadBuf.Initialize(nil, ErrConnClosed)
 	if handle != nil {
 		if err := handle(conn); err != nil {
-			conn.Close()
+			conn.closeWithReason(CloseReasonHandlerError)
 			return nil, fmt.Errorf("on tcp opened error: %w", err)
 		}
 	}
@@ -106,16 +234,19 @@ func (t *tcpListener) accept(handle OnTCPOpened) (net.Conn, error) {
 		return nil, fmt.Errorf("set tcp no delay error: %w", err)
 	}
 	if err := conn.nfd.Schedule(tcpOnRead, tcpOnWrite, tcpOnHup, conn); err != nil {
-		conn.Close()
+		conn.closeWithReason(CloseReasonIOError)
 		return nil, fmt.Errorf("connection netfd schedule error: %w", err)
 	}
 	metrics.Add(metrics.TCPConnsCreate, 1)
 	return conn, nil
 }
 
-// Close closes the tcp listener.
+// Close closes the tcp listener, the blocked Accept returns net.ErrClosed.
 func (t *tcpListener) Close() error {
 	t.nfd.close()
+	t.closeOnce.Do(func() {
+		close(t.closeCh)
+	})
 	return nil
 }
 
@@ -150,6 +281,9 @@ func newListener(listener net.Listener) (*tcpListener, error) {
 			network: listener.Addr().Network(),
 			laddr:   listener.Addr(),
 		},
+		readable:   make(chan struct{}, 1),
+		closeCh:    make(chan struct{}),
+		deadlineCh: make(chan struct{}, 1),
 	}
 	return ln, nil
 }
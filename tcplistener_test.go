@@ -14,8 +14,11 @@
 package tnet
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"testing"
 	"time"
 	"unsafe"
@@ -89,12 +92,14 @@ func TestListenerAccept(t *testing.T) {
 			require.Nil(t, err)
 			defer ln.Close()
 
+			// Accept blocks until the deadline if there is no connection.
+			require.Nil(t, ln.(*tcpListener).SetDeadline(time.Now().Add(20*time.Millisecond)))
 			_, err = ln.Accept()
 			assert.NotNil(t, err)
 			ne, ok := err.(net.Error)
 			require.Equal(t, true, ok)
-			require.Equal(t, true, ne.Temporary())
-			assert.Equal(t, false, ne.Timeout())
+			assert.Equal(t, true, ne.Timeout())
+			require.Nil(t, ln.(*tcpListener).SetDeadline(time.Time{}))
 
 			client, err := net.Dial(ln.Addr().Network(), ln.Addr().String())
 			require.Nil(t, err)
@@ -154,6 +159,68 @@ func TestListenerAcceptAfterClose(t *testing.T) {
 	}
 }
 
+func TestListenerAcceptBlocking(t *testing.T) {
+	ln, err := Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := ln.Accept()
+		assert.Nil(t, err)
+		accepted <- conn
+	}()
+	time.Sleep(20 * time.Millisecond)
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+	defer client.Close()
+	conn := <-accepted
+	defer conn.Close()
+
+	// The accepted connection works as an ordinary net.Conn.
+	_, err = client.Write(helloWorld)
+	require.Nil(t, err)
+	buf := make([]byte, len(helloWorld))
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = io.ReadFull(conn, buf)
+	require.Nil(t, err)
+	assert.Equal(t, helloWorld, buf)
+
+	// Close unblocks Accept.
+	errCh := make(chan error)
+	go func() {
+		_, err := ln.Accept()
+		errCh <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.Nil(t, ln.Close())
+	select {
+	case err := <-errCh:
+		assert.True(t, errors.Is(err, net.ErrClosed))
+	case <-time.After(time.Second):
+		t.Fatal("Accept is not unblocked by Close")
+	}
+}
+
+func TestListenerHTTPServe(t *testing.T) {
+	ln, err := Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("path " + r.URL.Path))
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	client := http.Client{Timeout: time.Second}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("http://" + ln.Addr().String() + "/foo")
+		require.Nil(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.Nil(t, err)
+		assert.Equal(t, "path /foo", string(body))
+	}
+}
+
 func TestListenerLocalAddr(t *testing.T) {
 	ln, err := Listen("tcp", ":0") // Use random port.
 	if err != nil {
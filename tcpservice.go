@@ -65,6 +65,7 @@ func newTCPService(ln *tcpListener, handler TCPHandler, opt ...Option) (Service,
 	for _, o := range opt {
 		o.f(&opts)
 	}
+	handler = opts.applyTCPInterceptors(handler)
 
 	s := &tcpservice{
 		ln:        ln,
@@ -145,32 +146,27 @@ func tcpServiceOnRead(data interface{}, _ *iovec.IOData) error {
 		if !ok {
 			return errors.New("bug: conn is not tcpconn type")
 		}
-		if err := tconn.SetOnRequest(s.reqHandle); err != nil {
-			return fmt.Errorf("tnet connection set on request error: %w", err)
-		}
-		if err := tconn.SetKeepAlive(s.opts.tcpKeepAlive); err != nil {
-			return fmt.Errorf("tnet connection set keep alive error: %w", err)
-		}
-		if err := tconn.SetIdleTimeout(s.opts.tcpIdleTimeout); err != nil {
-			return fmt.Errorf("tnet connection set idle timeout error: %w", err)
-		}
-		if err := tconn.SetWriteIdleTimeout(s.opts.tcpWriteIdleTimeout); err != nil {
-			return fmt.Errorf("tnet connection set write idle timeout error: %w", err)
+		handler := s.reqHandle
+		var proxyReader *proxyHeaderReader
+		if s.opts.proxyProtocol != ProxyProtocolDisabled {
+			// The connection is opened after the PROXY protocol header is read.
+			proxyReader = newProxyHeaderReader(s, tconn)
+			handler = proxyReader.handle
 		}
-		if err := tconn.SetReadIdleTimeout(s.opts.tcpReadIdleTimeout); err != nil {
-			return fmt.Errorf("tnet connection set read idle timeout error: %w", err)
+		if err := tconn.SetOnRequest(handler); err != nil {
+			return fmt.Errorf("tnet connection set on request error: %w", err)
 		}
-		tconn.outboundBufferLimit = s.opts.tcpOutboundBufferLimit
-		tconn.SetNonBlocking(s.opts.nonblocking)
-		tconn.SetSafeWrite(s.opts.safeWrite)
-		if s.opts.onTCPClosed != nil {
-			tconn.SetOnClosed(s.opts.onTCPClosed)
+		if err := tconn.applyOptions(&s.opts); err != nil {
+			return err
 		}
 		tconn.service = s
 		s.storeConn(tconn)
+		if proxyReader != nil {
+			return proxyReader.start()
+		}
 		// Execute the hook function set by the user for tcp connection creation.
 		if s.opts.onTCPOpened != nil {
-			return s.opts.onTCPOpened(tconn)
+			return tconn.callTCPHandler(TCPHandler(s.opts.onTCPOpened))
 		}
 		return nil
 	}
@@ -246,7 +242,7 @@ func (s *tcpservice) closeAll() {
 	s.connCond.Broadcast()
 	s.mu.Unlock()
 	for _, conn := range conns {
-		conn.Close()
+		conn.closeWithReason(CloseReasonServiceShutdown)
 	}
 }
 
@@ -53,7 +53,8 @@ func fixConfig(config *tls.Config, addr string) *tls.Config {
 }
 
 func dial(network, addr string, options *clientOptions, config *tls.Config) (Conn, error) {
-	rawConn, err := tnet.DialTCP(network, addr, options.dialTimeout)
+	d := tnet.Dialer{Timeout: options.dialTimeout, Proxy: options.proxy}
+	rawConn, err := d.DialContext(context.Background(), network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -15,7 +15,10 @@ package tls
 
 import (
 	"crypto/tls"
+	"net/url"
 	"time"
+
+	"trpc.group/trpc-go/tnet"
 )
 
 const defaultDialTimeout = 10 * time.Second
@@ -26,6 +29,8 @@ type serverOptions struct {
 	idleTimeout         time.Duration
 	flushWrite          bool
 	outboundBufferLimit int
+	proxyProtocol       tnet.ProxyProtocolMode
+	proxyHeaderTimeout  time.Duration
 	onOpened            OnOpened
 	onClosed            OnClosed
 }
@@ -80,6 +85,22 @@ func WithServerOutboundBufferLimit(limit int) ServerOption {
 	}
 }
 
+// WithServerProxyProtocol sets whether the service reads the PROXY protocol header before
+// the tls handshake, see tnet.WithProxyProtocol.
+func WithServerProxyProtocol(mode tnet.ProxyProtocolMode) ServerOption {
+	return func(o *serverOptions) {
+		o.proxyProtocol = mode
+	}
+}
+
+// WithServerProxyHeaderTimeout sets the timeout of reading the PROXY protocol header.
+// If d is 0, the default of tnet is used, if d < 0, there is no timeout.
+func WithServerProxyHeaderTimeout(d time.Duration) ServerOption {
+	return func(o *serverOptions) {
+		o.proxyHeaderTimeout = d
+	}
+}
+
 // WithOnOpened registers the OnOpened method that is fired when connection is established.
 func WithOnOpened(onOpened OnOpened) ServerOption {
 	return func(o *serverOptions) {
@@ -99,6 +120,7 @@ type clientOptions struct {
 	dialTimeout time.Duration
 	idleTimeout time.Duration
 	flushWrite  bool
+	proxy       func(address string) (*url.URL, error)
 }
 
 // ClientOption is the type for a single client option.
@@ -135,3 +157,10 @@ func WithClientIdleTimeout(idleTimeout time.Duration) ClientOption {
 		o.idleTimeout = idleTimeout
 	}
 }
+
+// WithProxy sets the proxy to connect through, see tnet.Dialer.Proxy.
+func WithProxy(proxy func(address string) (*url.URL, error)) ClientOption {
+	return func(o *clientOptions) {
+		o.proxy = proxy
+	}
+}
@@ -30,6 +30,7 @@ func NewService(ln net.Listener, handler Handler, opts ...ServerOption) (tnet.Se
 	for _, opt := range opts {
 		opt(&options)
 	}
+	h := newHandler(handler, &options)
 	tnetOpts := []tnet.Option{
 		tnet.WithTCPKeepAlive(options.keepAlive),
 		tnet.WithTCPIdleTimeout(options.idleTimeout),
@@ -39,7 +40,41 @@ func NewService(ln net.Listener, handler Handler, opts ...ServerOption) (tnet.Se
 		// a copy is made when the data is written.
 		tnet.WithSafeWrite(true),
 		tnet.WithFlushWrite(options.flushWrite),
-		tnet.WithOnTCPOpened(func(c tnet.Conn) error {
+		tnet.WithOnTCPOpened(h.OnOpened),
+	}
+	if options.proxyProtocol != tnet.ProxyProtocolDisabled {
+		tnetOpts = append(tnetOpts, tnet.WithProxyProtocol(options.proxyProtocol))
+	}
+	if options.proxyHeaderTimeout != 0 {
+		tnetOpts = append(tnetOpts, tnet.WithProxyHeaderTimeout(options.proxyHeaderTimeout))
+	}
+	if h.OnClosed != nil {
+		tnetOpts = append(tnetOpts, tnet.WithOnTCPClosed(h.OnClosed))
+	}
+	return tnet.NewTCPService(ln, h.OnRequest, tnetOpts...)
+}
+
+// NewHandler creates the handler of the tls route of a protomux service, so that tls is
+// served on the same listener as other protocols. The options about the tcp connection,
+// such as WithTCPKeepAlive and WithServerProxyProtocol, are ignored, set them by
+// protomux.WithTCPOptions instead.
+func NewHandler(handler Handler, opts ...ServerOption) tnet.TCPHandlers {
+	var options serverOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	h := newHandler(handler, &options)
+	onOpened := h.OnOpened
+	h.OnOpened = func(c tnet.Conn) error {
+		c.SetSafeWrite(true)
+		return onOpened(c)
+	}
+	return h
+}
+
+func newHandler(handler Handler, options *serverOptions) tnet.TCPHandlers {
+	h := tnet.TCPHandlers{
+		OnOpened: func(c tnet.Conn) error {
 			tc := &conn{
 				Conn: tls.Server(c, options.cfg),
 				raw:  c,
@@ -49,31 +84,31 @@ func NewService(ln net.Listener, handler Handler, opts ...ServerOption) (tnet.Se
 				return options.onOpened(tc)
 			}
 			return nil
-		}),
-	}
-	handleFunc := func(c tnet.Conn) error {
-		if c.GetMetaData() == nil {
-			return errors.New("metadata is empty, expect tls connection")
-		}
-		tc, ok := c.GetMetaData().(*conn)
-		if !ok {
-			return errors.New("tls connection is not stored in metadata")
-		}
-		// Inside the crypto/tls, there is an internal buffer to store data.
-		// When the tnet buffer is empty but there is data present in the crypto/tls buffer,
-		// the onRequest function does not trigger. In order to ensure that we can read all
-		// the data from connection, we use a for loop here.
-		for {
-			if !c.IsActive() {
-				return tnet.ErrConnClosed
+		},
+		OnRequest: func(c tnet.Conn) error {
+			if c.GetMetaData() == nil {
+				return errors.New("metadata is empty, expect tls connection")
 			}
-			if err := handler(tc); err != nil {
-				return err
+			tc, ok := c.GetMetaData().(*conn)
+			if !ok {
+				return errors.New("tls connection is not stored in metadata")
 			}
-		}
+			// Inside the crypto/tls, there is an internal buffer to store data.
+			// When the tnet buffer is empty but there is data present in the crypto/tls buffer,
+			// the onRequest function does not trigger. In order to ensure that we can read all
+			// the data from connection, we use a for loop here.
+			for {
+				if !c.IsActive() {
+					return tnet.ErrConnClosed
+				}
+				if err := handler(tc); err != nil {
+					return err
+				}
+			}
+		},
 	}
 	if options.onClosed != nil {
-		tnetOpts = append(tnetOpts, tnet.WithOnTCPClosed(func(c tnet.Conn) error {
+		h.OnClosed = func(c tnet.Conn) error {
 			if c.GetMetaData() == nil {
 				return errors.New("metadata is empty, expect tls connection")
 			}
@@ -82,7 +117,7 @@ func NewService(ln net.Listener, handler Handler, opts ...ServerOption) (tnet.Se
 				return errors.New("tls connection is not stored in metadata")
 			}
 			return options.onClosed(tc)
-		}))
+		}
 	}
-	return tnet.NewTCPService(ln, handleFunc, tnetOpts...)
+	return h
 }
@@ -18,6 +18,7 @@ import (
 	"crypto/rand"
 	stdtls "crypto/tls"
 	"errors"
+	"io"
 	"log"
 	"net"
 	"sync"
@@ -26,6 +27,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"trpc.group/trpc-go/tnet"
+	"trpc.group/trpc-go/tnet/proxytest"
 	"trpc.group/trpc-go/tnet/tls"
 )
 
@@ -143,6 +145,34 @@ func TestTNetClientTNetServer(t *testing.T) {
 	<-done
 }
 
+func TestTNetClientProxy(t *testing.T) {
+	done := make(chan struct{})
+	cancel := runServer(t, func(c tls.Conn) error {
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return err
+		}
+		_, err := c.Write(buf)
+		return err
+	}, done, tls.WithServerTLSConfig(getTLSCfg()))
+	proxy := proxytest.NewServer()
+	defer proxy.Close()
+	conn, err := tls.Dial("tcp", addr,
+		tls.WithClientTLSConfig(&stdtls.Config{InsecureSkipVerify: true}),
+		tls.WithProxy(tnet.ProxyURL(proxy.URL("socks5"))))
+	require.Nil(t, err)
+	_, err = conn.Write([]byte("hello"))
+	require.Nil(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.Nil(t, err)
+	require.Equal(t, []byte("hello"), buf)
+	require.Equal(t, []string{addr}, proxy.Targets())
+	require.Nil(t, conn.Close())
+	cancel()
+	<-done
+}
+
 func TestTNetClientNormalServer(t *testing.T) {
 	cancel := runNormalServer(t)
 	defer cancel()
@@ -353,6 +383,34 @@ func BenchmarkNormalClientServer(b *testing.B) {
 	ln.Close()
 }
 
+func TestServerProxyProtocol(t *testing.T) {
+	done := make(chan struct{})
+	cancel := runServer(t, func(c tls.Conn) error {
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return err
+		}
+		_, err := c.Write([]byte(c.RemoteAddr().String()))
+		return err
+	}, done, tls.WithServerTLSConfig(getTLSCfg()),
+		tls.WithServerProxyProtocol(tnet.ProxyProtocolRequired),
+		tls.WithServerProxyHeaderTimeout(time.Second))
+	raw, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	_, err = raw.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 5000 443\r\n"))
+	require.Nil(t, err)
+	conn := stdtls.Client(raw, &stdtls.Config{InsecureSkipVerify: true})
+	_, err = conn.Write([]byte("hello"))
+	require.Nil(t, err)
+	buf := make([]byte, len("192.0.2.1:5000"))
+	_, err = io.ReadFull(conn, buf)
+	require.Nil(t, err)
+	require.Equal(t, "192.0.2.1:5000", string(buf))
+	require.Nil(t, conn.Close())
+	cancel()
+	<-done
+}
+
 func getTLSCfg() *stdtls.Config {
 	cert, err := stdtls.LoadX509KeyPair("testdata/server.crt", "testdata/server.key")
 	if err != nil {
@@ -74,6 +74,28 @@ type BaseConn interface {
 	GetMetaData() interface{}
 }
 
+// StatsConn is optionally implemented by connections that record their statistics, such as
+// the connections created by tnet.
+type StatsConn interface {
+	// ID returns the process-unique id of the connection.
+	ID() uint64
+
+	// Stats returns the statistics snapshot of the connection.
+	Stats() ConnStats
+}
+
+// ContextConn is optionally implemented by connections that report when and why they are
+// closed, such as the connections created by tnet.
+type ContextConn interface {
+	// Context returns the context of the connection, which is canceled when the connection
+	// is closed. Since go1.20, context.Cause of it returns the CloseReason.
+	Context() context.Context
+
+	// CloseReason returns why the connection is closed, or CloseReasonNone if it is active.
+	// It is set before OnTCPClosed/OnUDPClosed is called.
+	CloseReason() CloseReason
+}
+
 // Conn is generic for stream oriented network connection.
 type Conn interface {
 	BaseConn
@@ -144,6 +166,77 @@ type Conn interface {
 	SetSafeWrite(safeWrite bool)
 }
 
+// BlockReader is optionally implemented by connections that can return the inbound data as
+// the blocks of the underlying buffer, such as the tcp connections created by tnet.
+type BlockReader interface {
+	// PeekBlocks is similar to Peek, except that the bytes are returned as the blocks of the
+	// underlying buffer, so no copy is made even if the data spans several blocks.
+	// The blocks stop being valid at the next ReadN or Release call, or once the data is
+	// handed over to another connection by Forward.
+	// Zero-Copy API.
+	PeekBlocks(n int) ([][]byte, error)
+
+	// NextBlocks is similar to Next, except that the bytes are returned as the blocks of the
+	// underlying buffer, so no copy is made even if the data spans several blocks.
+	// The blocks stop being valid at the next ReadN or Release call, use Forward to
+	// write them to another connection without copying.
+	// Zero-Copy API.
+	NextBlocks(n int) ([][]byte, error)
+}
+
+// OwnedReader is optionally implemented by connections that can detach the inbound data to
+// the caller, such as the tcp connections created by tnet.
+type OwnedReader interface {
+	// NextOwned is similar to Next, except that the underlying memory blocks are detached
+	// from the connection and owned by the returned OwnedBytes. So the bytes stay valid after
+	// Release, and can be handed over to other goroutines without copying.
+	// Please call OwnedBytes.Free() when it is unused.
+	// Zero-Copy API.
+	NextOwned(n int) (OwnedBytes, error)
+}
+
+// CallbackWriter is optionally implemented by connections that can notify when the written
+// data reaches the kernel, such as the tcp connections created by tnet.
+type CallbackWriter interface {
+	// WritevWithCallback is the same as Writev, and done is called once the data of p has been
+	// written to the kernel, or with an error when it can't be sent, such as the connection is closed.
+	// So the slices of p can be reused safely after done, even if SafeWrite is disabled.
+	// done may be called in the event loop, it should return quickly.
+	WritevWithCallback(done func(error), p ...[]byte) (int, error)
+}
+
+// ContextReadWriter is optionally implemented by connections whose blocking reads can be
+// canceled by a context, such as the tcp connections created by tnet.
+type ContextReadWriter interface {
+	// PeekContext is the same as Peek, and it also stops waiting when ctx is done,
+	// in which case ctx.Err() is returned.
+	PeekContext(ctx context.Context, n int) ([]byte, error)
+
+	// NextContext is the same as Next, and it also stops waiting when ctx is done,
+	// in which case ctx.Err() is returned.
+	NextContext(ctx context.Context, n int) ([]byte, error)
+
+	// ReadNContext is the same as ReadN, and it also stops waiting when ctx is done,
+	// in which case ctx.Err() is returned.
+	ReadNContext(ctx context.Context, n int) ([]byte, error)
+
+	// WritevContext is the same as Writev, except that it returns ctx.Err() without
+	// writing if ctx is done. Writev never blocks on the network, the data is sent asynchronously.
+	WritevContext(ctx context.Context, p ...[]byte) (int, error)
+}
+
+// Corker is optionally implemented by connections that support holding back writes explicitly,
+// such as the tcp connections created by tnet.
+type Corker interface {
+	// Cork holds back the sending of the following Write/Writev calls, the data is collected
+	// in the outbound buffer until the matching Uncork. Cork can be nested and called from
+	// several goroutines, the collected data is sent by one write when the last Uncork is called.
+	Cork()
+
+	// Uncork ends a Cork. If it is the last one, all the collected data is sent at once.
+	Uncork() error
+}
+
 // Service provides startup method to udp/tcp server.
 type Service interface {
 	// Serve registers a listener and runs blockingly to provide service, including listening to ports,
@@ -203,6 +296,15 @@ type PacketConn interface {
 	SetExactUDPBufferSizeEnabled(exactUDPBufferSizeEnabled bool)
 }
 
+// WriterToFrom is optionally implemented by packet connections that can choose the source
+// address of the sent packets, such as the udp connections created by tnet.
+type WriterToFrom interface {
+	// WriteToFrom writes a packet with payload p to dst, using src as the source address,
+	// which is usually the DstIP of the request packet on a service bound to a wildcard address.
+	// It is only supported on linux.
+	WriteToFrom(p []byte, dst net.Addr, src net.IP) (int, error)
+}
+
 // Packet represents a UDP packet, created by PacketConn Zero-Copy API ReadPacket.
 type Packet interface {
 	// Data returns the data of the packet.
@@ -214,6 +316,26 @@ type Packet interface {
 	Free()
 }
 
+// PacketInfo is optionally implemented by packets that report how they are received,
+// such as the packets read from the udp connections created by tnet.
+type PacketInfo interface {
+	// DstIP returns the local destination address of the packet, nil if it is unknown.
+	// It is available if the packet info is enabled by WithUDPPacketInfo.
+	DstIP() net.IP
+
+	// IfIndex returns the index of the interface the packet arrived on, 0 if it is unknown.
+	// It is available if the packet info is enabled by WithUDPPacketInfo.
+	IfIndex() int
+
+	// Timestamp returns the kernel receive timestamp of the packet, zero time if it is unknown.
+	// It is available if the timestamp is enabled by WithUDPTimestamp.
+	Timestamp() time.Time
+
+	// TOS returns the TOS byte (traffic class for IPv6) of the packet, whose lowest two bits are
+	// the ECN field. ok is false if it is unknown. It is available if enabled by WithUDPRecvTOS.
+	TOS() (tos uint8, ok bool)
+}
+
 // ListenPackets announces on the local network address. Reuseport sets whether to enable
 // reuseport when creating PacketConns, it will return multiple PacketConn if reuseport is true.
 // Generally, enabling reuseport can make effective use of multicore and improve performance.
@@ -14,6 +14,7 @@
 package tnet
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
@@ -34,7 +35,12 @@ import (
 )
 
 // udpconn must implements Conn interface.
-var _ PacketConn = (*udpconn)(nil)
+var (
+	_ PacketConn   = (*udpconn)(nil)
+	_ ContextConn  = (*udpconn)(nil)
+	_ StatsConn    = (*udpconn)(nil)
+	_ WriterToFrom = (*udpconn)(nil)
+)
 
 type udpconn struct {
 	metaData    interface{}
@@ -48,11 +54,16 @@ type udpconn struct {
 	nfd         netFD
 
 	closer
+	connContext
 	postpone     autopostpone.PostponeWrite
 	reading      locker.Locker
 	writing      locker.Locker
 	nonblocking  bool
 	closeService *sync.WaitGroup
+	panicHandler PanicHandler
+	// packetsRead counts the packets taken out of inBuffer, so that the request handler
+	// can tell whether it has read the packet regardless of the packets arriving meanwhile.
+	packetsRead atomic.Uint64
 }
 
 func (uc *udpconn) schedule() error {
@@ -103,6 +114,8 @@ func (uc *udpconn) waitReadWithTimeout() error {
 	return nil
 }
 
+var _ PacketInfo = (*packet)(nil)
+
 type packet struct {
 	block []byte
 }
@@ -117,6 +130,28 @@ func (p *packet) Free() {
 	mcache.Free(p.block)
 }
 
+// DstIP returns the local destination address of the packet.
+func (p *packet) DstIP() net.IP {
+	ip, _ := getUDPMetaAddr(p.block)
+	return ip
+}
+
+// IfIndex returns the index of the interface the packet arrived on.
+func (p *packet) IfIndex() int {
+	_, ifIndex := getUDPMetaAddr(p.block)
+	return ifIndex
+}
+
+// Timestamp returns the kernel receive timestamp of the packet.
+func (p *packet) Timestamp() time.Time {
+	return getUDPMetaTimestamp(p.block)
+}
+
+// TOS returns the TOS byte of the packet.
+func (p *packet) TOS() (uint8, bool) {
+	return getUDPMetaTOS(p.block)
+}
+
 // ReadPacket reads a packet from the connection, without copying the underlying buffer.
 func (uc *udpconn) ReadPacket() (Packet, net.Addr, error) {
 	if !uc.beginJobSafely(apiRead) {
@@ -173,9 +208,25 @@ func (uc *udpconn) readBlock() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	uc.packetsRead.Inc()
 	return block, nil
 }
 
+// dropPacket discards the next packet in the inbound buffer.
+func (uc *udpconn) dropPacket() {
+	if !uc.beginJobSafely(apiRead) {
+		return
+	}
+	defer uc.endJobSafely(apiRead)
+	block, err := uc.inBuffer.ReadBlock()
+	if err != nil {
+		return
+	}
+	uc.packetsRead.Inc()
+	mcache.Free(block)
+	uc.inBuffer.Release()
+}
+
 func (uc *udpconn) errTimeout() error {
 	err := fmt.Errorf("write udp %s: i/o timeout",
 		uc.LocalAddr().String())
@@ -185,6 +236,18 @@ func (uc *udpconn) errTimeout() error {
 
 // WriteTo writes a packet with payload p to addr.
 func (uc *udpconn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return uc.writeToFrom(p, addr, nil)
+}
+
+// WriteToFrom writes a packet with payload p to dst, using src as the source address.
+func (uc *udpconn) WriteToFrom(p []byte, dst net.Addr, src net.IP) (int, error) {
+	if src == nil {
+		return 0, errors.New("source address can't be nil")
+	}
+	return uc.writeToFrom(p, dst, src)
+}
+
+func (uc *udpconn) writeToFrom(p []byte, addr net.Addr, src net.IP) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
@@ -197,20 +260,20 @@ func (uc *udpconn) WriteTo(p []byte, addr net.Addr) (int, error) {
 	}
 	defer uc.endJobSafely(apiWrite)
 	if uc.postpone.Enabled() {
-		return uc.writeToBuffer(p, addr)
+		return uc.writeToBuffer(p, addr, src)
 	}
-	n, err := uc.writeToNetFD(p, addr)
+	n, err := uc.writeToNetFD(p, addr, src)
 	if (n == 0 && err == nil) || errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EWOULDBLOCK) {
-		return uc.writeToBuffer(p, addr)
+		return uc.writeToBuffer(p, addr, src)
 	}
 	return n, err
 }
 
-func (uc *udpconn) writeToNetFD(p []byte, addr net.Addr) (int, error) {
+func (uc *udpconn) writeToNetFD(p []byte, addr net.Addr, src net.IP) (int, error) {
 	if !uc.writing.TryLock() {
 		return 0, nil
 	}
-	n, err := uc.nfd.WriteTo(p, addr)
+	n, err := uc.nfd.WriteToFrom(p, addr, src)
 	metrics.Add(metrics.UDPWriteToCalls, 1)
 	uc.writing.Unlock()
 	if err != nil {
@@ -223,13 +286,13 @@ func (uc *udpconn) writeToNetFD(p []byte, addr net.Addr) (int, error) {
 	return n, err
 }
 
-func (uc *udpconn) writeToBuffer(p []byte, addr net.Addr) (int, error) {
-	block, err := parcel(p, addr)
+func (uc *udpconn) writeToBuffer(p []byte, addr net.Addr, src net.IP) (int, error) {
+	block, err := parcelFrom(p, addr, src)
 	if err != nil {
 		return 0, err
 	}
 	n := uc.outBuffer.Write(false, block)
-	n -= netutil.SockaddrSize
+	n -= udpHeaderLen(block)
 	if !uc.writing.TryLock() {
 		return n, nil
 	}
@@ -245,6 +308,12 @@ func (uc *udpconn) writeToBuffer(p []byte, addr net.Addr) (int, error) {
 
 // Close closes the connection.
 func (uc *udpconn) Close() error {
+	return uc.closeWithReason(CloseReasonUser)
+}
+
+// closeWithReason closes the udpconn, the reason takes effect only if it is the first close.
+func (uc *udpconn) closeWithReason(reason CloseReason) error {
+	uc.setCloseReason(reason)
 	if !uc.beginJobSafely(closeAll) {
 		return nil
 	}
@@ -252,9 +321,11 @@ func (uc *udpconn) Close() error {
 	uc.closeJobSafely(sysRead)
 	close(uc.readTrigger)
 	uc.closeAllJobs()
+	// Cancel the context before onClosed, so that the close reason is visible to it.
+	uc.cancelContext()
 
 	if onClosed := uc.getOnClosed(); onClosed != nil {
-		onClosed(uc)
+		uc.callUDPHandler(UDPHandler(onClosed))
 	}
 	uc.metaData = nil
 	if uc.rtimer != nil {
@@ -295,7 +366,11 @@ func (uc *udpconn) Write(b []byte) (int, error) {
 		return 0, ErrConnClosed
 	}
 	defer uc.endJobSafely(apiWrite)
-	return unix.Write(uc.nfd.fd, b)
+	n, err := unix.Write(uc.nfd.fd, b)
+	if err == nil {
+		uc.nfd.stats.onWrite(n)
+	}
+	return n, err
 }
 
 // LocalAddr returns the local network address.
@@ -496,16 +571,138 @@ func udpOnWrite(data interface{}) error {
 	return nil
 }
 
+// A UDP block in the inbound and outbound buffers is laid out as [sockaddr | flags | meta | payload].
+// The sockaddr is the peer address. The meta is reserved only if metaReserved is set in the flags,
+// which is the case for the packets received with any packet info enabled and the packets sent
+// from a given source address. It holds the local address, the interface index, the TOS byte
+// and the kernel receive timestamp of the packet, which are valid only if the corresponding bits
+// are set in the flags.
+const (
+	metaFlagsOffset     = netutil.SockaddrSize
+	udpHeaderSize       = metaFlagsOffset + 1
+	metaAddrOffset      = udpHeaderSize
+	metaIfIndexOffset   = metaAddrOffset + net.IPv6len
+	metaTOSOffset       = metaIfIndexOffset + 4
+	metaTimestampOffset = metaTOSOffset + 1
+	udpMetaHeaderSize   = metaTimestampOffset + 8
+)
+
+const (
+	metaReserved uint8 = 1 << iota
+	metaHasAddr
+	metaHasTOS
+	metaHasTimestamp
+)
+
+// initUDPHeader initializes the flags of the block, and reserves the meta if meta is true.
+// The block must be at least udpMetaHeaderSize long if meta is true.
+func initUDPHeader(block []byte, meta bool) {
+	if !meta {
+		block[metaFlagsOffset] = 0
+		return
+	}
+	block[metaFlagsOffset] = metaReserved
+	resetUDPMeta(block)
+}
+
+// udpHeaderLen returns the length of the header of the block, including the meta if it is reserved.
+func udpHeaderLen(block []byte) int {
+	if len(block) > metaFlagsOffset && block[metaFlagsOffset]&metaReserved != 0 {
+		return udpMetaHeaderSize
+	}
+	return udpHeaderSize
+}
+
+// hasUDPMeta reports whether the meta of the block is reserved and the flag is set.
+func hasUDPMeta(block []byte, flag uint8) bool {
+	return len(block) >= udpMetaHeaderSize && block[metaFlagsOffset]&(metaReserved|flag) == metaReserved|flag
+}
+
+// resetUDPMeta clears the meta of the block if it is reserved.
+func resetUDPMeta(block []byte) {
+	if !hasUDPMeta(block, metaReserved) {
+		return
+	}
+	block[metaFlagsOffset] = metaReserved
+	meta := block[udpHeaderSize:udpMetaHeaderSize]
+	for i := range meta {
+		meta[i] = 0
+	}
+}
+
+// setUDPMetaAddr saves the local address and the interface index into the meta of the block,
+// it is ignored if the meta is not reserved.
+func setUDPMetaAddr(block []byte, ip net.IP, ifIndex int) {
+	if !hasUDPMeta(block, metaReserved) {
+		return
+	}
+	copy(block[metaAddrOffset:metaIfIndexOffset], ip.To16())
+	binary.LittleEndian.PutUint32(block[metaIfIndexOffset:metaTOSOffset], uint32(ifIndex))
+	block[metaFlagsOffset] |= metaHasAddr
+}
+
+// getUDPMetaAddr returns the local address and the interface index in the meta of the block,
+// nil address is returned if they are not set.
+func getUDPMetaAddr(block []byte) (net.IP, int) {
+	if !hasUDPMeta(block, metaHasAddr) {
+		return nil, 0
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, block[metaAddrOffset:metaIfIndexOffset])
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return ip, int(binary.LittleEndian.Uint32(block[metaIfIndexOffset:metaTOSOffset]))
+}
+
+// setUDPMetaTOS saves the TOS byte into the meta of the block, it is ignored if the meta
+// is not reserved.
+func setUDPMetaTOS(block []byte, tos uint8) {
+	if !hasUDPMeta(block, metaReserved) {
+		return
+	}
+	block[metaTOSOffset] = tos
+	block[metaFlagsOffset] |= metaHasTOS
+}
+
+// getUDPMetaTOS returns the TOS byte in the meta of the block, ok is false if it is not set.
+func getUDPMetaTOS(block []byte) (tos uint8, ok bool) {
+	if !hasUDPMeta(block, metaHasTOS) {
+		return 0, false
+	}
+	return block[metaTOSOffset], true
+}
+
+// setUDPMetaTimestamp saves the timestamp in nanoseconds into the meta of the block, it is
+// ignored if the meta is not reserved.
+func setUDPMetaTimestamp(block []byte, ns int64) {
+	if !hasUDPMeta(block, metaReserved) {
+		return
+	}
+	binary.LittleEndian.PutUint64(block[metaTimestampOffset:udpMetaHeaderSize], uint64(ns))
+	block[metaFlagsOffset] |= metaHasTimestamp
+}
+
+// getUDPMetaTimestamp returns the timestamp in the meta of the block, zero time is returned
+// if it is not set.
+func getUDPMetaTimestamp(block []byte) time.Time {
+	if !hasUDPMeta(block, metaHasTimestamp) {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(block[metaTimestampOffset:udpMetaHeaderSize])))
+}
+
 func getUDPData(block []byte) ([]byte, error) {
-	if len(block) < netutil.SockaddrSize {
+	n := udpHeaderLen(block)
+	if len(block) < n {
 		return nil, errors.New("invalid UDP packet")
 	}
-	buf := block[netutil.SockaddrSize:]
+	buf := block[n:]
 	return buf, nil
 }
 
 func getUDPAddr(block []byte) (net.Addr, error) {
-	if len(block) < netutil.SockaddrSize {
+	if len(block) < udpHeaderLen(block) {
 		return nil, errors.New("invalid UDP packet")
 	}
 	sockaddr := block[:netutil.SockaddrSize]
@@ -529,6 +726,11 @@ func getUDPDataAndAddr(block []byte) ([]byte, net.Addr, error) {
 }
 
 func parcel(buf []byte, addr net.Addr) ([]byte, error) {
+	return parcelFrom(buf, addr, nil)
+}
+
+// parcelFrom builds a block of buf to addr, the source address src is saved into the meta if not nil.
+func parcelFrom(buf []byte, addr net.Addr, src net.IP) ([]byte, error) {
 	udpAddr, ok := addr.(*net.UDPAddr)
 	if !ok {
 		return nil, errors.New("only UDPAddr can be parceled")
@@ -537,7 +739,20 @@ func parcel(buf []byte, addr net.Addr) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	block := append(sockaddr, buf...)
+	if src != nil && src.To16() == nil {
+		return nil, fmt.Errorf("invalid source address %v", src)
+	}
+	n := udpHeaderSize
+	if src != nil {
+		n = udpMetaHeaderSize
+	}
+	block := make([]byte, n+len(buf))
+	copy(block, sockaddr)
+	if src != nil {
+		initUDPHeader(block, true)
+		setUDPMetaAddr(block, src, 0)
+	}
+	copy(block[n:], buf)
 	return block, nil
 }
 
@@ -548,9 +763,9 @@ func udpAsyncHandler(conn *udpconn) {
 	}
 	for {
 		for conn.Len() > 0 && conn.IsActive() {
-			if err := handler(conn); err != nil {
+			if err := conn.callUDPRequestHandler(handler); err != nil {
 				conn.reading.Unlock()
-				conn.Close()
+				conn.closeWithReason(CloseReasonHandlerError)
 				return
 			}
 		}
@@ -571,8 +786,8 @@ func udpSyncHandle(conn *udpconn) error {
 	conn.postpone.ResetLoopCnt()
 	for conn.Len() > 0 && conn.IsActive() {
 		conn.postpone.IncLoopCnt()
-		if err := handler(conn); err != nil {
-			conn.Close()
+		if err := conn.callUDPRequestHandler(handler); err != nil {
+			conn.closeWithReason(CloseReasonHandlerError)
 			return err
 		}
 	}
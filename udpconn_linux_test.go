@@ -4,6 +4,8 @@
 package tnet
 
 import (
+	"context"
+	"fmt"
 	"net"
 	"testing"
 	"time"
@@ -193,3 +195,118 @@ func readUDPPayloads(t *testing.T, receiver net.PacketConn, n int) [][]byte {
 	}
 	return got
 }
+
+func TestUDPServiceReplyFromDstIP(t *testing.T) {
+	lns, err := ListenPackets("udp4", "0.0.0.0:0", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstCh := make(chan net.IP, 1)
+	s, err := NewUDPService(lns, func(conn PacketConn) error {
+		p, addr, err := conn.ReadPacket()
+		if err != nil {
+			return err
+		}
+		defer p.Free()
+		data, err := p.Data()
+		if err != nil {
+			return err
+		}
+		dst := p.(PacketInfo).DstIP()
+		dstCh <- dst
+		_, err = conn.(WriterToFrom).WriteToFrom(data, addr, dst)
+		return err
+	}, WithUDPPacketInfo(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx)
+
+	port := lns[0].LocalAddr().(*net.UDPAddr).Port
+	client, err := net.Dial("udp4", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 16)
+	n, err := client.Read(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p[:n]) != "hello" {
+		t.Fatalf("reply = %q, want %q", p[:n], "hello")
+	}
+	if dst := <-dstCh; !dst.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("DstIP() = %v, want 127.0.0.1", dst)
+	}
+}
+
+func TestSetUDPOptionsOnDialedConn(t *testing.T) {
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn, err := DialUDP("udp4", server.LocalAddr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	readBlock := func() []byte {
+		if _, err := server.WriteTo([]byte("hello"), conn.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		p, _, err := conn.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := append([]byte(nil), p.(*packet).block...)
+		p.Free()
+		return block
+	}
+	// The meta is not reserved if all the packet info is disabled.
+	if n := udpHeaderLen(readBlock()); n != udpHeaderSize {
+		t.Fatalf("header length = %d, want %d", n, udpHeaderSize)
+	}
+
+	if err := SetUDPOptions(conn, WithUDPPacketInfo(true)); err != nil {
+		t.Fatal(err)
+	}
+	block := readBlock()
+	if dst, _ := getUDPMetaAddr(block); !dst.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("DstIP() = %v, want 127.0.0.1", dst)
+	}
+	if data, _ := getUDPData(block); string(data) != "hello" {
+		t.Fatalf("data = %q, want %q", data, "hello")
+	}
+
+	// The options not given are kept.
+	if err := SetUDPOptions(conn, WithUDPTimestamp(true)); err != nil {
+		t.Fatal(err)
+	}
+	if !conn.(*udpconn).nfd.udpPktInfo.Load() {
+		t.Fatal("packet info is disabled")
+	}
+	if err := SetUDPOptions(conn, WithUDPPacketInfo(false), WithUDPTimestamp(false)); err != nil {
+		t.Fatal(err)
+	}
+	if n := udpHeaderLen(readBlock()); n != udpHeaderSize {
+		t.Fatalf("header length = %d, want %d", n, udpHeaderSize)
+	}
+
+	if err := SetUDPOptions(nil, WithUDPPacketInfo(true)); err == nil {
+		t.Fatal("SetUDPOptions on a conn not created by tnet should fail")
+	}
+}
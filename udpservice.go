@@ -45,6 +45,7 @@ func newUDPService(lns []PacketConn, handler UDPHandler, opt ...Option) (Service
 	for _, o := range opt {
 		o.f(&opts)
 	}
+	handler = opts.applyUDPInterceptors(handler)
 	wg := &sync.WaitGroup{}
 	wg.Add(len(lns))
 	s := &udpservice{
@@ -59,6 +60,9 @@ func newUDPService(lns []PacketConn, handler UDPHandler, opt ...Option) (Service
 		}
 		conn.SetMaxPacketSize(s.opts.maxUDPPacketSize)
 		conn.SetExactUDPBufferSizeEnabled(s.opts.exactUDPBufferSizeEnabled)
+		if err := conn.setUDPOptions(&s.opts); err != nil {
+			return nil, err
+		}
 		conn.closeService = wg
 		s.conns = append(s.conns, conn)
 	}
@@ -78,6 +82,28 @@ func NewPacketConn(conn net.PacketConn) (PacketConn, error) {
 	return uc, nil
 }
 
+// SetUDPOptions sets the UDP socket options WithUDPGSO, WithUDPGRO, WithUDPPacketInfo,
+// WithUDPTimestamp, WithUDPRecvTOS and WithUDPTOS of a PacketConn created by tnet, such as
+// the ones returned by DialUDP and ListenPackets, the other options are ignored. The options
+// not given are kept unchanged, and it is safe to call while the conn is in use.
+func SetUDPOptions(conn PacketConn, opt ...Option) error {
+	uc, ok := conn.(*udpconn)
+	if !ok {
+		return fmt.Errorf("conn is not of udpconn type: %T, it should be created by tnet", conn)
+	}
+	opts := options{
+		udpGSO:       uc.nfd.udpGSO.Load(),
+		udpGRO:       uc.nfd.udpGRO.Load(),
+		udpPktInfo:   uc.nfd.udpPktInfo.Load(),
+		udpTimestamp: uc.nfd.udpTimestamp.Load(),
+		udpRecvTOS:   uc.nfd.udpRecvTOS.Load(),
+	}
+	for _, o := range opt {
+		o.f(&opts)
+	}
+	return uc.setUDPOptions(&opts)
+}
+
 func listenUDP(network string, address string, reuseport bool) ([]PacketConn, error) {
 	var lns []PacketConn
 	n := 1
@@ -119,11 +145,49 @@ func newUDPConn(listener net.PacketConn) (*udpconn, error) {
 		},
 		readTrigger: make(chan struct{}, 1),
 	}
+	conn.nfd.stats.init()
 	conn.inBuffer.Initialize()
 	conn.outBuffer.Initialize()
 	return conn, nil
 }
 
+// setUDPOptions applies the UDP socket options of opts that differ from the current ones.
+// It falls back without GSO or GRO if the kernel doesn't support them.
+func (uc *udpconn) setUDPOptions(opts *options) error {
+	nfd := &uc.nfd
+	if opts.udpGSO != nfd.udpGSO.Load() {
+		if err := nfd.setUDPGSO(opts.udpGSO); err != nil {
+			log.Infof("tnet udp conn falls back to sending without GSO: %v", err)
+		}
+	}
+	if opts.udpGRO != nfd.udpGRO.Load() {
+		if err := nfd.setUDPGRO(opts.udpGRO); err != nil {
+			log.Infof("tnet udp conn falls back to receiving without GRO: %v", err)
+		}
+	}
+	if opts.udpPktInfo != nfd.udpPktInfo.Load() {
+		if err := nfd.setUDPPktInfo(opts.udpPktInfo); err != nil {
+			return fmt.Errorf("set udp packet info error: %w", err)
+		}
+	}
+	if opts.udpTimestamp != nfd.udpTimestamp.Load() {
+		if err := nfd.setUDPTimestamp(opts.udpTimestamp); err != nil {
+			return fmt.Errorf("set udp timestamp error: %w", err)
+		}
+	}
+	if opts.udpRecvTOS != nfd.udpRecvTOS.Load() {
+		if err := nfd.setUDPRecvTOS(opts.udpRecvTOS); err != nil {
+			return fmt.Errorf("set udp receiving tos error: %w", err)
+		}
+	}
+	if opts.udpTOSSet {
+		if err := nfd.setUDPTOS(opts.udpTOS); err != nil {
+			return fmt.Errorf("set udp tos error: %w", err)
+		}
+	}
+	return nil
+}
+
 type udpservice struct {
 	reqHandle     UDPHandler
 	conns         []*udpconn
@@ -141,6 +205,7 @@ func (s *udpservice) Serve(ctx context.Context) error {
 			return err
 		}
 		conn.SetNonBlocking(s.opts.nonblocking)
+		conn.panicHandler = s.opts.panicHandler
 
 		if s.opts.onUDPClosed != nil {
 			conn.SetOnClosed(s.opts.onUDPClosed)
@@ -169,7 +234,7 @@ func (s *udpservice) Serve(ctx context.Context) error {
 
 func (s *udpservice) close() error {
 	for _, conn := range s.conns {
-		if err := conn.Close(); err != nil {
+		if err := conn.closeWithReason(CloseReasonServiceShutdown); err != nil {
 			return err
 		}
 	}
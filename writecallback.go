@@ -0,0 +1,134 @@
+//
+//
+// Tencent is pleased to support the open source community by making tRPC available.
+//
+// Copyright (C) 2023 Tencent.
+// All rights reserved.
+//
+// If you have downloaded a copy of the tRPC source code from Tencent,
+// please note that tRPC source code is licensed under the  Apache 2.0 License,
+// A copy of the Apache 2.0 License is included in this file.
+//
+//
+
+package tnet
+
+import (
+	"sync"
+
+	"go.uber.org/atomic"
+	"trpc.group/trpc-go/tnet/metrics"
+)
+
+// writeCallback is the done callback of the data whose end is at offset of outBuffer.
+type writeCallback struct {
+	offset uint64
+	done   func(error)
+}
+
+// writeCallbacks keeps the done callbacks of WritevWithCallback in order of offset.
+type writeCallbacks struct {
+	mu    sync.Mutex
+	queue []writeCallback
+	// sent is the total number of bytes written to the kernel from outBuffer.
+	sent atomic.Uint64
+	// pending is the number of callbacks in queue, it avoids locking mu when
+	// there is no callback.
+	pending atomic.Int32
+}
+
+// add adds the callback of the data ending at offset, the callback is fired
+// at once if the data has been sent.
+func (w *writeCallbacks) add(offset uint64, done func(error)) {
+	w.mu.Lock()
+	i := len(w.queue)
+	for i > 0 && w.queue[i-1].offset > offset {
+		i--
+	}
+	w.queue = append(w.queue, writeCallback{})
+	copy(w.queue[i+1:], w.queue[i:])
+	w.queue[i] = writeCallback{offset: offset, done: done}
+	w.pending.Inc()
+	w.mu.Unlock()
+	// The data may have been sent before the callback is added.
+	if w.sent.Load() >= offset {
+		w.fire(w.sent.Load())
+	}
+}
+
+// onSent records n bytes written to the kernel and fires the callbacks whose data has been sent.
+func (w *writeCallbacks) onSent(n int) {
+	sent := w.sent.Add(uint64(n))
+	if w.pending.Load() > 0 {
+		w.fire(sent)
+	}
+}
+
+func (w *writeCallbacks) fire(sent uint64) {
+	w.mu.Lock()
+	var i int
+	for i < len(w.queue) && w.queue[i].offset <= sent {
+		i++
+	}
+	ready := make([]writeCallback, i)
+	copy(ready, w.queue[:i])
+	w.queue = w.queue[:copy(w.queue, w.queue[i:])]
+	w.pending.Sub(int32(i))
+	w.mu.Unlock()
+	for _, cb := range ready {
+		cb.done(nil)
+	}
+}
+
+// closeAll fires all the callbacks left with err.
+func (w *writeCallbacks) closeAll(err error) {
+	w.mu.Lock()
+	queue := w.queue
+	w.queue = nil
+	w.pending.Store(0)
+	w.mu.Unlock()
+	for _, cb := range queue {
+		cb.done(err)
+	}
+}
+
+// WritevWithCallback is the same as Writev, and done is called once the data of p has been
+// written to the kernel, or with an error when the data can't be sent, such as the connection
+// is closed. So the slices of p can be reused safely after done, even if SafeWrite is disabled.
+// done may be called in the event loop, it should return quickly.
+func (tc *tcpconn) WritevWithCallback(done func(error), p ...[]byte) (int, error) {
+	if done == nil {
+		return tc.Writev(p...)
+	}
+	if tc.wtimer != nil && tc.wtimer.Expired() {
+		err := tc.writeTimeoutErr()
+		done(err)
+		return 0, err
+	}
+	if !tc.beginJobSafely(apiWrite) {
+		done(ErrConnClosed)
+		return 0, ErrConnClosed
+	}
+	n, offset, err := tc.outBuffer.WritevLimitedWithOffset(tc.safeWrite, tc.outboundBufferLimit, p...)
+	if err != nil {
+		tc.endJobSafely(apiWrite)
+		metrics.Add(metrics.TCPOutboundBufferLimitExceeded, 1)
+		tc.closeWithReason(CloseReasonLimitExceeded)
+		done(ErrOutboundBufferLimitExceeded)
+		return 0, ErrOutboundBufferLimitExceeded
+	}
+	// The callback is added in apiWrite job, so that it is fired either by sending or by Close.
+	tc.writeCallbacks.add(offset, done)
+	// The data is kept in outBuffer until the last Uncork.
+	if tc.corked.Load() > 0 {
+		tc.endJobSafely(apiWrite)
+		return n, nil
+	}
+	if err := tc.send(); err != nil {
+		tc.endJobSafely(apiWrite)
+		tc.closeWithReason(closeReasonOf(err))
+		return n, err
+	}
+	tc.endJobSafely(apiWrite)
+	return n, nil
+}